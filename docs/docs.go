@@ -19,66 +19,163 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/report/": {
+        "/events/stream": {
             "get": {
                 "produces": [
-                    "application/json"
+                    "text/event-stream"
                 ],
                 "tags": [
-                    "report"
+                    "events"
                 ],
-                "summary": "Get history JSON",
+                "summary": "Stream segment membership and segment change events (Server-Sent Events)",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "month",
-                        "name": "month",
-                        "in": "query",
-                        "required": true
+                        "description": "user_id",
+                        "name": "user_id",
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "year",
-                        "name": "year",
-                        "in": "query",
-                        "required": true
+                        "description": "segment",
+                        "name": "segment",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "stream id of the last received event",
+                        "name": "Last-Event-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/avito_task_internal_entity.ReportUserHistory"
-                            }
+                            "$ref": "#/definitions/avito-internship_internal_entity.Event"
                         }
                     }
                 }
             }
         },
-        "/report/file": {
+        "/layer/create": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "layer"
+                ],
+                "summary": "Create layer of mutually exclusive segments",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.LayerRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created"
+                    }
+                }
+            }
+        },
+        "/purge/jobs": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "purge"
+                ],
+                "summary": "Create background job purging deleted segment with all its history",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.PurgeJobRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.PurgeJobCreateResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/purge/jobs/{id}": {
             "get": {
                 "produces": [
-                    "text/csv"
+                    "application/json"
                 ],
                 "tags": [
-                    "report"
+                    "purge"
                 ],
-                "summary": "Get report file",
+                "summary": "Get purge job status and number of purged records",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "month",
-                        "name": "month",
-                        "in": "query",
+                        "type": "integer",
+                        "description": "job id",
+                        "name": "id",
+                        "in": "path",
                         "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.PurgeJob"
+                        }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/purge/jobs/{id}/file": {
+            "get": {
+                "produces": [
+                    "text/csv"
+                ],
+                "tags": [
+                    "purge"
+                ],
+                "summary": "Download history archive saved by purge job",
+                "parameters": [
                     {
-                        "type": "string",
-                        "description": "year",
-                        "name": "year",
-                        "in": "query",
+                        "type": "integer",
+                        "description": "job id",
+                        "name": "id",
+                        "in": "path",
                         "required": true
                     }
                 ],
@@ -91,11 +188,23 @@ const docTemplate = `{
                                 "type": "integer"
                             }
                         }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
                     }
                 }
             }
         },
-        "/report/link": {
+        "/report/": {
             "get": {
                 "produces": [
                     "application/json"
@@ -103,68 +212,156 @@ const docTemplate = `{
                 "tags": [
                     "report"
                 ],
-                "summary": "Get report file",
+                "summary": "Get history JSON",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "month",
+                        "type": "integer",
+                        "description": "month (together with year)",
                         "name": "month",
-                        "in": "query",
-                        "required": true
+                        "in": "query"
                     },
                     {
-                        "type": "string",
-                        "description": "year",
+                        "type": "integer",
+                        "description": "year (together with month)",
                         "name": "year",
-                        "in": "query",
-                        "required": true
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period start, RFC3339 (inclusive)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period end, RFC3339 (exclusive)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "segment names",
+                        "name": "segment",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "user ids",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "add",
+                            "remove"
+                        ],
+                        "type": "string",
+                        "description": "operation",
+                        "name": "operation",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/avito-internship_internal_entity.ReportUserHistory"
                             }
                         }
                     }
                 }
             }
         },
-        "/segment/create": {
-            "post": {
-                "consumes": [
-                    "application/json"
-                ],
+        "/report/file": {
+            "get": {
                 "produces": [
-                    "application/json"
+                    "text/csv"
                 ],
                 "tags": [
-                    "segment"
+                    "report"
                 ],
-                "summary": "Create segment",
+                "summary": "Get report file",
                 "parameters": [
                     {
-                        "description": "request",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/avito_task_internal_entity.SegmentRequest"
-                        }
+                        "type": "integer",
+                        "description": "month (together with year)",
+                        "name": "month",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "year (together with month)",
+                        "name": "year",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period start, RFC3339 (inclusive)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period end, RFC3339 (exclusive)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "segment names",
+                        "name": "segment",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "user ids",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "add",
+                            "remove"
+                        ],
+                        "type": "string",
+                        "description": "operation",
+                        "name": "operation",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "integer"
+                            }
+                        }
                     }
                 }
             }
         },
-        "/segment/delete": {
-            "delete": {
+        "/report/jobs": {
+            "post": {
                 "consumes": [
                     "application/json"
                 ],
@@ -172,9 +369,9 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "segment"
+                    "report"
                 ],
-                "summary": "Delete segment",
+                "summary": "Create background report job",
                 "parameters": [
                     {
                         "description": "request",
@@ -182,62 +379,69 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/avito_task_internal_entity.SegmentRequest"
+                            "$ref": "#/definitions/avito-internship_internal_entity.ReportJobRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK"
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.ReportJobCreateResponse"
+                        }
                     }
                 }
             }
         },
-        "/user/add": {
-            "post": {
-                "consumes": [
-                    "application/json"
-                ],
+        "/report/jobs/{id}": {
+            "get": {
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "report"
                 ],
-                "summary": "Add user to segment",
+                "summary": "Get report job status and progress",
                 "parameters": [
                     {
-                        "description": "request",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/avito_task_internal_entity.UserAddToSegmentRequest"
-                        }
+                        "type": "integer",
+                        "description": "job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK"
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.ReportJob"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
                     }
                 }
             }
         },
-        "/user/get": {
+        "/report/jobs/{id}/file": {
             "get": {
                 "produces": [
-                    "application/json"
+                    "text/csv"
                 ],
                 "tags": [
-                    "user"
+                    "report"
                 ],
-                "summary": "Get active user's segments",
+                "summary": "Download report file of completed job",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "user_id",
-                        "name": "user_id",
-                        "in": "query",
+                        "type": "integer",
+                        "description": "job id",
+                        "name": "id",
+                        "in": "path",
                         "required": true
                     }
                 ],
@@ -245,39 +449,379 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "array",
-                                "items": {
-                                    "type": "string"
-                                }
+                            "type": "array",
+                            "items": {
+                                "type": "integer"
                             }
                         }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
                     }
                 }
             }
         },
-        "/user/remove": {
-            "delete": {
-                "consumes": [
-                    "application/json"
-                ],
+        "/report/link": {
+            "get": {
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "report"
                 ],
-                "summary": "Remove user from segment",
+                "summary": "Get report file",
                 "parameters": [
                     {
-                        "description": "request",
-                        "name": "request",
+                        "type": "integer",
+                        "description": "month (together with year)",
+                        "name": "month",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "year (together with month)",
+                        "name": "year",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period start, RFC3339 (inclusive)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period end, RFC3339 (exclusive)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "segment names",
+                        "name": "segment",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "integer"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "user ids",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "add",
+                            "remove"
+                        ],
+                        "type": "string",
+                        "description": "operation",
+                        "name": "operation",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/segment": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "List segments catalog",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "segment name prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "segment tags (all must match)",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "active",
+                            "scheduled",
+                            "ended",
+                            "deleted"
+                        ],
+                        "type": "string",
+                        "description": "segment status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size (1-500, default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/create": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Create segment",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "initiator of the change, saved to the segment catalog",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created"
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/delete": {
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Delete segment",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/rename": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Rename segment",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentRenameRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "initiator of the change, saved to the segment catalog",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentAlias"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/restore": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Restore deleted segment",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentRestoreRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "initiator of the change, saved to the segment catalog and the user history",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentRestoreResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/segment/update": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Update segment percent, rule, payload and catalog metadata",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/avito_task_internal_entity.UserRemoveFromSegmentRequest"
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentUpdateRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "initiator of the change, saved to the segment catalog",
+                        "name": "X-Actor",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -286,55 +830,1104 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "avito_task_internal_entity.ReportUserHistory": {
+        },
+        "/segment/{name}/users": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segment"
+                ],
+                "summary": "Get users of segment at point in time",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "segment name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "point in time, RFC3339 (default now)",
+                        "name": "as_of",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "ttl",
+                            "permanent"
+                        ],
+                        "type": "string",
+                        "description": "membership type",
+                        "name": "membership",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size (1-1000, default 100)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.SegmentMembersResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/add": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Add user to segment",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserAddToSegmentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "initiator of the change, saved to the user history",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "headers": {
+                            "Deprecation": {
+                                "type": "string",
+                                "description": "true if a renamed segment was referenced by its old name"
+                            },
+                            "X-Segment-Aliases": {
+                                "type": "string",
+                                "description": "old=current names of renamed segments used in the request"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/attributes": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Set user's attributes for rule-based segments",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserAttributesRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/user/config": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get active user's segments with merged payloads",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user_id",
+                        "name": "user_id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserConfigResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/get": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get active user's segments",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user_id",
+                        "name": "user_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "point in time, RFC3339 (segments from membership history only)",
+                        "name": "as_of",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/avito-internship_internal_entity.UserSegment"
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/user/import": {
+            "post": {
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Import users to segments from CSV or JSON Lines file",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "file with rows user_id,segment,ttl (.csv or .jsonl)",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "validate rows without saving changes",
+                        "name": "dry_run",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserImportResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/remove": {
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Remove user from segment",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserRemoveFromSegmentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "initiator of the change, saved to the user history",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "headers": {
+                            "Deprecation": {
+                                "type": "string",
+                                "description": "true if a renamed segment was referenced by its old name"
+                            },
+                            "X-Segment-Aliases": {
+                                "type": "string",
+                                "description": "old=current names of renamed segments used in the request"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/user/segments:batchGet": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get active segments of many users",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserBatchActiveSegmentRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserBatchActiveSegmentResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}": {
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Erase user (right to be forgotten)",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "initiator of the erasure, saved to the erasure audit record",
+                        "name": "X-Actor",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserErasureReceipt"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}/history": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get membership history of user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "segment names",
+                        "name": "segment",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period start, RFC3339 (inclusive)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "period end, RFC3339 (exclusive)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size (1-500, default 50)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserHistoryResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/create": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhook"
+                ],
+                "summary": "Subscribe webhook to segment events",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.WebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.WebhookCreateResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/delete": {
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhook"
+                ],
+                "summary": "Delete webhook subscription",
+                "parameters": [
+                    {
+                        "description": "request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.WebhookDeleteRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/avito-internship_internal_apperror.AppError"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/list": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhook"
+                ],
+                "summary": "Get webhook subscriptions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/avito-internship_internal_entity.Webhook"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "avito-internship_internal_apperror.AppError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito-internship_internal_entity.Event": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "occurred_at": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "expired"
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "membership.removed"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "variant": {
+                    "type": "string",
+                    "example": "control"
+                }
+            }
+        },
+        "avito-internship_internal_entity.LayerRequest": {
+            "type": "object",
+            "required": [
+                "layer"
+            ],
+            "properties": {
+                "layer": {
+                    "type": "string",
+                    "example": "CHECKOUT_EXPERIMENTS"
+                }
+            }
+        },
+        "avito-internship_internal_entity.PurgeJob": {
+            "type": "object",
+            "properties": {
+                "archive": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "cutoff": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "location": {
+                    "type": "string",
+                    "example": "/api/v1/purge/jobs/1/file"
+                },
+                "purged": {
+                    "type": "integer",
+                    "example": 20000
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "running"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "segment"
+                }
+            }
+        },
+        "avito-internship_internal_entity.PurgeJobCreateResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "avito-internship_internal_entity.PurgeJobRequest": {
+            "type": "object",
+            "required": [
+                "segment"
+            ],
+            "properties": {
+                "archive": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                }
+            }
+        },
+        "avito-internship_internal_entity.ReportJob": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "location": {
+                    "type": "string",
+                    "example": "/api/v1/report/jobs/1/file"
+                },
+                "month": {
+                    "type": "integer",
+                    "example": 8
+                },
+                "progress": {
+                    "type": "number",
+                    "example": 0.45
+                },
+                "status": {
+                    "type": "string",
+                    "example": "running"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "file"
+                },
+                "year": {
+                    "type": "integer",
+                    "example": 2023
+                }
+            }
+        },
+        "avito-internship_internal_entity.ReportJobCreateResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "avito-internship_internal_entity.ReportJobRequest": {
+            "type": "object",
+            "required": [
+                "month",
+                "type",
+                "year"
+            ],
+            "properties": {
+                "month": {
+                    "type": "integer",
+                    "example": 8
+                },
+                "type": {
+                    "type": "string",
+                    "example": "file"
+                },
+                "year": {
+                    "type": "integer",
+                    "example": 2023
+                }
+            }
+        },
+        "avito-internship_internal_entity.ReportUserHistory": {
+            "type": "object",
+            "required": [
+                "date",
+                "operation",
+                "segment",
+                "user_id"
+            ],
+            "properties": {
+                "actor": {
+                    "type": "string",
+                    "example": "support@avito.ru"
+                },
+                "date": {
+                    "type": "string"
+                },
+                "operation": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "segment": {
+                    "type": "string"
+                },
+                "segment_at_event": {
+                    "description": "SegmentAtEvent название сегмента в момент операции (Segment - текущее название)",
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "source": {
+                    "type": "string",
+                    "example": "manual"
+                },
+                "user_id": {
+                    "type": "string"
+                },
+                "variant": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentAlias": {
+            "type": "object",
+            "properties": {
+                "alias": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "alias_until": {
+                    "type": "string"
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "VOICE_MESSAGES_V2"
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentInfo": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string",
+                    "example": "support@avito.ru"
+                },
+                "deleted_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Голосовые сообщения в чатах"
+                },
+                "ends_at": {
+                    "type": "string"
+                },
+                "layer": {
+                    "type": "string",
+                    "example": "CHECKOUT_EXPERIMENTS"
+                },
+                "members": {
+                    "type": "integer",
+                    "example": 1500
+                },
+                "name": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "owner_team": {
+                    "type": "string",
+                    "example": "messenger"
+                },
+                "percent": {
+                    "type": "number",
+                    "example": 0.5
+                },
+                "rule": {
+                    "type": "string",
+                    "example": "platform == 'ios'"
+                },
+                "starts_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "active"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "voice",
+                        "messenger"
+                    ]
+                },
+                "ticket_url": {
+                    "type": "string",
+                    "example": "https://jira.avito.ru/browse/MSG-1234"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "updated_by": {
+                    "type": "string",
+                    "example": "support@avito.ru"
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentListResponse": {
+            "type": "object",
+            "properties": {
+                "next_cursor": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito-internship_internal_entity.SegmentInfo"
+                    }
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentMember": {
+            "type": "object",
+            "properties": {
+                "added_at": {
+                    "type": "string"
+                },
+                "left_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "variant": {
+                    "type": "string",
+                    "example": "control"
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentMembersResponse": {
+            "type": "object",
+            "properties": {
+                "as_of": {
+                    "type": "string"
+                },
+                "next_cursor": {
+                    "type": "string",
+                    "example": "1000"
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 1500
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito-internship_internal_entity.SegmentMember"
+                    }
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentRenameRequest": {
+            "type": "object",
+            "required": [
+                "new_name",
+                "segment"
+            ],
+            "properties": {
+                "alias_until": {
+                    "type": "string",
+                    "example": "2024-01-01T00:00:00+03:00"
+                },
+                "new_name": {
+                    "type": "string",
+                    "example": "VOICE_MESSAGES_V2"
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentRequest": {
+            "type": "object",
+            "required": [
+                "segment"
+            ],
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "Голосовые сообщения в чатах"
+                },
+                "ends_at": {
+                    "type": "string",
+                    "example": "2023-12-01T00:00:00+03:00"
+                },
+                "layer": {
+                    "type": "string",
+                    "example": "CHECKOUT_EXPERIMENTS"
+                },
+                "owner_team": {
+                    "type": "string",
+                    "example": "messenger"
+                },
+                "payload": {
+                    "type": "object"
+                },
+                "percent": {
+                    "type": "number",
+                    "example": 0.5
+                },
+                "rule": {
+                    "type": "string",
+                    "example": "city in ('Moscow','Kazan') AND platform == 'ios'"
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "starts_at": {
+                    "type": "string",
+                    "example": "2023-11-01T00:00:00+03:00"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "voice",
+                        "messenger"
+                    ]
+                },
+                "ticket_url": {
+                    "type": "string",
+                    "example": "https://jira.avito.ru/browse/MSG-1234"
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito-internship_internal_entity.Variant"
+                    }
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentRestoreRequest": {
             "type": "object",
             "required": [
-                "date",
-                "operation",
-                "segment",
-                "user_id"
+                "segment"
             ],
             "properties": {
-                "date": {
-                    "type": "string"
-                },
-                "operation": {
-                    "type": "string"
+                "reenroll": {
+                    "type": "boolean",
+                    "example": true
                 },
                 "segment": {
-                    "type": "string"
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                }
+            }
+        },
+        "avito-internship_internal_entity.SegmentRestoreResponse": {
+            "type": "object",
+            "properties": {
+                "reenrolled": {
+                    "type": "integer",
+                    "example": 1500
                 },
-                "user_id": {
-                    "type": "string"
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
                 }
             }
         },
-        "avito_task_internal_entity.SegmentRequest": {
+        "avito-internship_internal_entity.SegmentUpdateRequest": {
             "type": "object",
             "required": [
                 "segment"
             ],
             "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "Голосовые сообщения в чатах"
+                },
+                "owner_team": {
+                    "type": "string",
+                    "example": "messenger"
+                },
+                "payload": {
+                    "type": "object"
+                },
                 "percent": {
                     "type": "number",
-                    "example": 0.5
+                    "example": 0.3
+                },
+                "rule": {
+                    "type": "string",
+                    "example": "registered_before 2023-01-01"
                 },
                 "segment": {
                     "type": "string",
                     "example": "AVITO_VOICE_MESSAGES"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "voice",
+                        "messenger"
+                    ]
+                },
+                "ticket_url": {
+                    "type": "string",
+                    "example": "https://jira.avito.ru/browse/MSG-1234"
                 }
             }
         },
-        "avito_task_internal_entity.UserAddToSegmentRequest": {
+        "avito-internship_internal_entity.UserAddToSegmentRequest": {
             "type": "object",
             "required": [
                 "segments",
                 "user_id"
             ],
             "properties": {
+                "end_at": {
+                    "type": "string",
+                    "example": "2026-11-15T00:00:00+03:00"
+                },
                 "segments": {
                     "type": "array",
                     "items": {
@@ -345,6 +1938,10 @@ const docTemplate = `{
                         "AVITO_PERFORMANCE_VAS"
                     ]
                 },
+                "start_at": {
+                    "type": "string",
+                    "example": "2026-11-01T00:00:00+03:00"
+                },
                 "ttl": {
                     "type": "integer",
                     "example": 2
@@ -355,7 +1952,176 @@ const docTemplate = `{
                 }
             }
         },
-        "avito_task_internal_entity.UserRemoveFromSegmentRequest": {
+        "avito-internship_internal_entity.UserAttributesRequest": {
+            "type": "object",
+            "required": [
+                "attributes",
+                "user_id"
+            ],
+            "properties": {
+                "attributes": {
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1000
+                }
+            }
+        },
+        "avito-internship_internal_entity.UserBatchActiveSegmentRequest": {
+            "type": "object",
+            "required": [
+                "user_ids"
+            ],
+            "properties": {
+                "user_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    },
+                    "example": [
+                        1000,
+                        1001,
+                        1002
+                    ]
+                }
+            }
+        },
+        "avito-internship_internal_entity.UserBatchActiveSegmentResponse": {
+            "type": "object",
+            "properties": {
+                "users": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/avito-internship_internal_entity.UserSegment"
+                        }
+                    }
+                }
+            }
+        },
+        "avito-internship_internal_entity.UserConfigResponse": {
+            "type": "object",
+            "properties": {
+                "payload": {
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito-internship_internal_entity.UserSegment"
+                    }
+                }
+            }
+        },
+        "avito-internship_internal_entity.UserErasureReceipt": {
+            "type": "object",
+            "properties": {
+                "erased_at": {
+                    "type": "string"
+                },
+                "events": {
+                    "type": "integer",
+                    "example": 24
+                },
+                "memberships": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "purge_archives_pseudonymized": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    },
+                    "example": [
+                        2
+                    ]
+                },
+                "receipt_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "report_jobs_to_regenerate": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    },
+                    "example": [
+                        3
+                    ]
+                },
+                "reports_to_regenerate": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "report_20230801T000000_20230901T000000.csv"
+                    ]
+                }
+            }
+        },
+        "avito-internship_internal_entity.UserHistoryResponse": {
+            "type": "object",
+            "properties": {
+                "history": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito-internship_internal_entity.ReportUserHistory"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                }
+            }
+        },
+        "avito-internship_internal_entity.UserImportRejectedRow": {
+            "type": "object",
+            "properties": {
+                "line": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "segment does not exist or is not active"
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1000
+                }
+            }
+        },
+        "avito-internship_internal_entity.UserImportResponse": {
+            "type": "object",
+            "properties": {
+                "accepted": {
+                    "type": "integer",
+                    "example": 199998
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "rejected": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "rejected_rows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/avito-internship_internal_entity.UserImportRejectedRow"
+                    }
+                }
+            }
+        },
+        "avito-internship_internal_entity.UserRemoveFromSegmentRequest": {
             "type": "object",
             "required": [
                 "segments",
@@ -377,6 +2143,111 @@ const docTemplate = `{
                     "example": 1000
                 }
             }
+        },
+        "avito-internship_internal_entity.UserSegment": {
+            "type": "object",
+            "properties": {
+                "payload": {
+                    "type": "object"
+                },
+                "segment": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "variant": {
+                    "type": "string",
+                    "example": "control"
+                }
+            }
+        },
+        "avito-internship_internal_entity.Variant": {
+            "type": "object",
+            "required": [
+                "name",
+                "weight"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "example": "control"
+                },
+                "weight": {
+                    "type": "integer",
+                    "example": 50
+                }
+            }
+        },
+        "avito-internship_internal_entity.Webhook": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "membership.added",
+                        "membership.removed"
+                    ]
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://example.com/hooks/segments"
+                }
+            }
+        },
+        "avito-internship_internal_entity.WebhookCreateResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "avito-internship_internal_entity.WebhookDeleteRequest": {
+            "type": "object",
+            "required": [
+                "id"
+            ],
+            "properties": {
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "avito-internship_internal_entity.WebhookRequest": {
+            "type": "object",
+            "required": [
+                "events",
+                "secret",
+                "url"
+            ],
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "membership.added",
+                        "membership.removed"
+                    ]
+                },
+                "secret": {
+                    "type": "string",
+                    "example": "3f9a1c..."
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://example.com/hooks/segments"
+                }
+            }
         }
     }
 }`
@@ -2,18 +2,44 @@ package app
 
 import (
 	"avito-internship/internal/config"
+	grpcv1 "avito-internship/internal/controller/grpc/v1"
 	v1 "avito-internship/internal/controller/http/v1"
 	"avito-internship/internal/repository"
 	"avito-internship/internal/service"
 	"avito-internship/internal/webapi/googledrive"
+	"avito-internship/internal/webapi/webhook"
 	"avito-internship/pkg/database/postgresdb"
+	"avito-internship/pkg/grpcserver"
 	"avito-internship/pkg/httpserver"
 	"avito-internship/pkg/logging"
+	"avito-internship/pkg/worker"
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+)
+
+const (
+	// segmentExpireInterval период проверки сегментов с закончившимся окном действия
+	segmentExpireInterval = time.Minute
+	// membershipSweepInterval период фиксации истёкшего по ttl членства пользователей
+	membershipSweepInterval = time.Minute
+	// eventDispatchInterval период распределения новых событий журнала по подпискам и потоку событий
+	eventDispatchInterval = time.Second
+	// webhookDeliveryInterval период отправки событий подписчикам вебхуков
+	webhookDeliveryInterval = 5 * time.Second
+	// reportJobWorkers количество воркеров, параллельно строящих отчеты
+	reportJobWorkers = 2
+	// reportJobPollInterval период проверки очереди заданий на построение отчетов
+	reportJobPollInterval = 2 * time.Second
+	// retentionInterval период постановки задания на удаление истории старше срока хранения
+	retentionInterval = 24 * time.Hour
+	// purgeJobPollInterval период проверки очереди заданий на удаление истории
+	purgeJobPollInterval = 5 * time.Second
 )
 
 // @title Dynamic user segmentation service
@@ -47,9 +73,111 @@ func Run(configPath string) {
 	deps := service.ServicesDependencies{
 		Repos:  repositories,
 		GDrive: googledrive.New(cfg.GDriveJSONFilePath),
+		Sender: webhook.New(),
+
+		RetentionMonths:  cfg.RetentionMonths,
+		RetentionArchive: cfg.RetentionArchive,
 	}
 	services := service.NewServices(deps)
 
+	// Workers
+	logger.Info("Starting background workers...")
+	segmentExpirer := worker.New(func(ctx context.Context) error {
+		expired, err := services.Segment.ExpireSegments(ctx)
+		if expired > 0 {
+			logger.Infof("segment expirer: %d users left expired segments", expired)
+		}
+
+		return err
+	},
+		worker.Interval(segmentExpireInterval),
+		worker.ErrorHandler(func(err error) {
+			logger.WithError(err).Error("app.Run - segmentExpirer")
+		}),
+	)
+
+	membershipSweeper := worker.New(func(ctx context.Context) error {
+		expired, err := services.User.FinalizeExpiredMemberships(ctx)
+		if expired > 0 {
+			logger.Infof("membership sweeper: %d memberships expired", expired)
+		}
+
+		return err
+	},
+		worker.Interval(membershipSweepInterval),
+		worker.ErrorHandler(func(err error) {
+			logger.WithError(err).Error("app.Run - membershipSweeper")
+		}),
+	)
+
+	eventDispatcher := worker.New(func(ctx context.Context) error {
+		_, err := services.Webhook.DispatchEvents(ctx)
+
+		return err
+	},
+		worker.Interval(eventDispatchInterval),
+		worker.ErrorHandler(func(err error) {
+			logger.WithError(err).Error("app.Run - eventDispatcher")
+		}),
+	)
+
+	webhookDeliverer := worker.New(func(ctx context.Context) error {
+		_, err := services.Webhook.DeliverEvents(ctx)
+
+		return err
+	},
+		worker.Interval(webhookDeliveryInterval),
+		worker.ErrorHandler(func(err error) {
+			logger.WithError(err).Error("app.Run - webhookDeliverer")
+		}),
+	)
+
+	reportJobWorkerPool := make([]*worker.Worker, 0, reportJobWorkers)
+	for i := 0; i < reportJobWorkers; i++ {
+		reportJobWorkerPool = append(reportJobWorkerPool, worker.New(func(ctx context.Context) error {
+			processed, err := services.Report.ProcessReportJobs(ctx)
+			if processed > 0 {
+				logger.Infof("report jobs: %d jobs completed", processed)
+			}
+
+			return err
+		},
+			worker.Interval(reportJobPollInterval),
+			worker.ErrorHandler(func(err error) {
+				logger.WithError(err).Error("app.Run - reportJobWorker")
+			}),
+		))
+	}
+
+	retentionEnforcer := worker.New(func(ctx context.Context) error {
+		id, err := services.Purge.EnforceRetention(ctx)
+		if id > 0 {
+			logger.Infof("retention: purge job %d created", id)
+		}
+
+		return err
+	},
+		worker.Interval(retentionInterval),
+		worker.ErrorHandler(func(err error) {
+			logger.WithError(err).Error("app.Run - retentionEnforcer")
+		}),
+	)
+
+	// Задания на удаление выполняются одним воркером, чтобы не нагружать бд параллельными удалениями
+	purgeJobWorker := worker.New(func(ctx context.Context) error {
+		processed, err := services.Purge.ProcessPurgeJobs(ctx)
+		if processed > 0 {
+			logger.Infof("purge jobs: %d jobs completed", processed)
+		}
+
+		return err
+	},
+		worker.Interval(purgeJobPollInterval),
+		worker.ErrorHandler(func(err error) {
+			logger.WithError(err).Error("app.Run - purgeJobWorker")
+		}),
+	)
+
 	// Handler
 	logger.Info("Initializing handlers and routes...")
 	handler := gin.Default()
@@ -59,6 +187,12 @@ func Run(configPath string) {
 	logger.Infof("Starting http server on port :%s", cfg.PortHttp)
 	httpServer := httpserver.New(handler, httpserver.Port(fmt.Sprintf(cfg.PortHttp)))
 
+	// gRPC server
+	logger.Infof("Starting grpc server on port :%s", cfg.PortGrpc)
+	grpcServer := grpcserver.New(func(server *grpc.Server) {
+		grpcv1.NewServer(server, &logger, services)
+	}, grpcserver.Port(cfg.PortGrpc))
+
 	// Waiting signal
 	logger.Info("Configuring graceful shutdown...")
 	interrupt := make(chan os.Signal, 1)
@@ -69,6 +203,8 @@ func Run(configPath string) {
 		logger.Info("app.Run - signal: " + s.String())
 	case err = <-httpServer.Notify():
 		logger.WithError(err).Error("app.Run - httpServer.Notify")
+	case err = <-grpcServer.Notify():
+		logger.WithError(err).Error("app.Run - grpcServer.Notify")
 	}
 
 	// Graceful shutdown
@@ -77,4 +213,46 @@ func Run(configPath string) {
 	if err != nil {
 		logger.WithError(err).Error("app.Run - httpServer.Shutdown")
 	}
+
+	err = grpcServer.Shutdown()
+	if err != nil {
+		logger.WithError(err).Error("app.Run - grpcServer.Shutdown")
+	}
+
+	err = segmentExpirer.Shutdown()
+	if err != nil {
+		logger.WithError(err).Error("app.Run - segmentExpirer.Shutdown")
+	}
+
+	err = membershipSweeper.Shutdown()
+	if err != nil {
+		logger.WithError(err).Error("app.Run - membershipSweeper.Shutdown")
+	}
+
+	err = eventDispatcher.Shutdown()
+	if err != nil {
+		logger.WithError(err).Error("app.Run - eventDispatcher.Shutdown")
+	}
+
+	err = webhookDeliverer.Shutdown()
+	if err != nil {
+		logger.WithError(err).Error("app.Run - webhookDeliverer.Shutdown")
+	}
+
+	for _, reportJobWorker := range reportJobWorkerPool {
+		err = reportJobWorker.Shutdown()
+		if err != nil {
+			logger.WithError(err).Error("app.Run - reportJobWorker.Shutdown")
+		}
+	}
+
+	err = retentionEnforcer.Shutdown()
+	if err != nil {
+		logger.WithError(err).Error("app.Run - retentionEnforcer.Shutdown")
+	}
+
+	err = purgeJobWorker.Shutdown()
+	if err != nil {
+		logger.WithError(err).Error("app.Run - purgeJobWorker.Shutdown")
+	}
 }
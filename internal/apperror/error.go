@@ -1,13 +1,43 @@
 package apperror
 
 var (
-	ErrNoSegment          = New(nil, "The specified segments do not exist or have already been deleted")
-	ErrNoUser             = New(nil, "the specified user does not exist")
-	ErrBadRequest         = New(nil, "the request to the server contains a syntax error")
-	ErrWrongPercent       = New(nil, "percentage must be set in the range 0.0-1.0")
-	ErrWrongTtl           = New(nil, "ttl must be strictly positive")
-	ErrFileNotFound       = New(nil, "file not found")
-	ErrGDriveNotAvailable = New(nil, "Google Drive is unavailable, please try again later")
+	ErrNoSegment            = New(nil, "The specified segments do not exist or have already been deleted")
+	ErrNoUser               = New(nil, "the specified user does not exist")
+	ErrBadRequest           = New(nil, "the request to the server contains a syntax error")
+	ErrWrongPercent         = New(nil, "percentage must be set in the range 0.0-1.0")
+	ErrWrongTtl             = New(nil, "ttl must be strictly positive")
+	ErrFileNotFound         = New(nil, "file not found")
+	ErrGDriveNotAvailable   = New(nil, "Google Drive is unavailable, please try again later")
+	ErrWrongRule            = New(nil, "segment rule contains a syntax error")
+	ErrRuleInLayer          = New(nil, "a segment with a targeting rule cannot belong to a layer")
+	ErrWrongVariants        = New(nil, "variants must have unique names and strictly positive weights")
+	ErrNoLayer              = New(nil, "the specified layer does not exist")
+	ErrWrongPayload         = New(nil, "segment payload must be a JSON object")
+	ErrSegmentConflict      = New(nil, "the user already belongs to another segment of the same layer")
+	ErrWrongWindow          = New(nil, "segment end time must be in the future and after its start time")
+	ErrWrongSchedule        = New(nil, "start_at must not be in the past, end_at must be after now and start_at, and cannot be combined with ttl")
+	ErrWrongWebhook         = New(nil, "webhook url must be an absolute http(s) url and events must be known event types")
+	ErrNoWebhook            = New(nil, "the specified webhook does not exist or has already been deleted")
+	ErrWrongBatch           = New(nil, "user_ids must contain from 1 to 500 ids")
+	ErrWrongImportFile      = New(nil, "import file must be a .csv or .jsonl file with at most 500000 rows")
+	ErrWrongReportJob       = New(nil, "report job type must be file or link and month must be in the range 1-12")
+	ErrNoReportJob          = New(nil, "the specified report job does not exist")
+	ErrReportNotReady       = New(nil, "the report file is not ready yet")
+	ErrWrongReportPeriod    = New(nil, "report period must be set either by month and year or by from < to, operation must be add or remove")
+	ErrWrongHistoryQuery    = New(nil, "cursor must be taken from next_cursor, limit must be in the range 1-500 and from must be before to")
+	ErrWrongAsOf            = New(nil, "as_of must not be in the future")
+	ErrWrongMembersQuery    = New(nil, "cursor must be taken from next_cursor, limit must be in the range 1-1000 and membership must be ttl or permanent")
+	ErrWrongMetadata        = New(nil, "ticket_url must be an absolute http(s) url and tags must be unique and non-empty")
+	ErrWrongSegmentsQuery   = New(nil, "status must be active, scheduled, ended or deleted and limit must be in the range 1-500")
+	ErrSegmentDeleted       = New(nil, "a segment with this name was deleted, restore it or choose another name")
+	ErrNoDeletedSegment     = New(nil, "the specified segment does not exist or is not deleted")
+	ErrSegmentNameTaken     = New(nil, "the name is used by another segment or is an alias of a renamed segment")
+	ErrWrongRename          = New(nil, "new_name must differ from the current name and alias_until must be in the future")
+	ErrNoPurgeJob           = New(nil, "the specified purge job does not exist")
+	ErrPurgeArchiveNotReady = New(nil, "the purge job has no archive or it is not written yet")
+	ErrSegmentPurging       = New(nil, "the segment is being purged and cannot be restored")
+	ErrReportJobLost        = New(nil, "the report job was claimed by another worker after its lease expired")
+	ErrPurgeJobLost         = New(nil, "the purge job was claimed by another worker after its lease expired")
 )
 
 type AppError struct {
@@ -4,6 +4,7 @@ import "github.com/spf13/viper"
 
 type Config struct {
 	PortHttp           string `mapstructure:"HTTP_PORT"`
+	PortGrpc           string `mapstructure:"GRPC_PORT"`
 	PgUser             string `mapstructure:"POSTGRES_USER"`
 	PgPassword         string `mapstructure:"POSTGRES_Password"`
 	PgHost             string `mapstructure:"POSTGRES_HOST"`
@@ -11,6 +12,8 @@ type Config struct {
 	PgDB               string `mapstructure:"POSTGRES_DB"`
 	PgUrl              string `mapstructure:"POSTGRES_URL"`
 	GDriveJSONFilePath string `mapstructure:"GOOGLE_DRIVE_JSON_FILE_PATH"`
+	RetentionMonths    int    `mapstructure:"RETENTION_MONTHS"`
+	RetentionArchive   bool   `mapstructure:"RETENTION_ARCHIVE"`
 }
 
 // LoadConfig Конструктор для создания Config, который содержит считанные из .env файла данные.
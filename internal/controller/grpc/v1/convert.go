@@ -0,0 +1,157 @@
+package v1
+
+import (
+	segmentationv1 "avito-internship/api/segmentation/v1"
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strings"
+	"time"
+)
+
+// payloadFromStruct преобразует данные сегмента из protobuf в JSON, отсутствующие данные остаются nil
+func payloadFromStruct(payload *structpb.Struct) (json.RawMessage, error) {
+	if payload == nil {
+		return nil, nil
+	}
+
+	data, err := payload.MarshalJSON()
+	if err != nil {
+		return nil, apperror.ErrWrongPayload
+	}
+
+	return data, nil
+}
+
+// payloadToStruct преобразует данные сегмента из JSON в protobuf
+func payloadToStruct(payload json.RawMessage) (*structpb.Struct, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+
+	result := &structpb.Struct{}
+	if err := result.UnmarshalJSON(payload); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func timeFromTimestamp(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+
+	t := ts.AsTime()
+
+	return &t
+}
+
+func userSegmentsToProto(segments []entity.UserSegment) ([]*segmentationv1.UserSegment, error) {
+	result := make([]*segmentationv1.UserSegment, 0, len(segments))
+	for _, segment := range segments {
+		payload, err := payloadToStruct(segment.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, &segmentationv1.UserSegment{
+			Segment: segment.Segment,
+			Variant: segment.Variant,
+			Payload: payload,
+		})
+	}
+
+	return result, nil
+}
+
+func reportRequestFromProto(req *segmentationv1.ReportRequest) entity.ReportRequest {
+	request := entity.ReportRequest{
+		Month:     int(req.GetMonth()),
+		Year:      int(req.GetYear()),
+		Segments:  req.GetSegments(),
+		Operation: req.GetOperation(),
+	}
+	if from := timeFromTimestamp(req.GetFrom()); from != nil {
+		request.From = *from
+	}
+	if to := timeFromTimestamp(req.GetTo()); to != nil {
+		request.To = *to
+	}
+	for _, userId := range req.GetUserIds() {
+		request.UserIds = append(request.UserIds, int(userId))
+	}
+
+	return request
+}
+
+// actorFromContext возвращает инициатора изменения из метаданных запроса x-actor
+func actorFromContext(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, "x-actor"); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// setDeprecationHeader передаёт в метаданных ответа deprecation и список использованных
+// устаревших названий сегментов x-segment-aliases в виде "прежнее=текущее"
+func setDeprecationHeader(ctx context.Context, aliases []entity.SegmentAlias) {
+	if len(aliases) == 0 {
+		return
+	}
+
+	pairs := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		pairs = append(pairs, alias.Alias+"="+alias.Segment)
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("deprecation", "true", "x-segment-aliases", strings.Join(pairs, ",")))
+}
+
+func reportUserHistoryToProto(record entity.ReportUserHistory) *segmentationv1.ReportUserHistory {
+	return &segmentationv1.ReportUserHistory{
+		UserId:    record.UserId,
+		Segment:   record.Segment,
+		Variant:   record.Variant,
+		Operation: record.Operation,
+		Reason:    record.Reason,
+		Date:      timestamppb.New(record.Date),
+		Source:    record.Source,
+		Actor:     record.Actor,
+
+		SegmentAtEvent: record.SegmentAtEvent,
+	}
+}
+
+func segmentInfoToProto(segment entity.SegmentInfo) *segmentationv1.SegmentInfo {
+	info := &segmentationv1.SegmentInfo{
+		Name:        segment.Name,
+		Description: segment.Description,
+		OwnerTeam:   segment.OwnerTeam,
+		Tags:        segment.Tags,
+		TicketUrl:   segment.TicketUrl,
+		Rule:        segment.Rule,
+		Layer:       segment.Layer,
+		Percent:     segment.Percent,
+		Status:      segment.Status,
+		Members:     int64(segment.Members),
+		StartsAt:    timestamppb.New(segment.StartsAt),
+		CreatedAt:   timestamppb.New(segment.CreatedAt),
+		CreatedBy:   segment.CreatedBy,
+		UpdatedAt:   timestamppb.New(segment.UpdatedAt),
+		UpdatedBy:   segment.UpdatedBy,
+	}
+	if segment.EndsAt != nil {
+		info.EndsAt = timestamppb.New(*segment.EndsAt)
+	}
+	if segment.DeletedAt != nil {
+		info.DeletedAt = timestamppb.New(*segment.DeletedAt)
+	}
+
+	return info
+}
@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/pkg/logging"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorCodes соответствие ошибок приложения кодам gRPC
+var errorCodes = []struct {
+	err  *apperror.AppError
+	code codes.Code
+}{
+	{apperror.ErrBadRequest, codes.InvalidArgument},
+	{apperror.ErrWrongPercent, codes.InvalidArgument},
+	{apperror.ErrWrongTtl, codes.InvalidArgument},
+	{apperror.ErrWrongRule, codes.InvalidArgument},
+	{apperror.ErrRuleInLayer, codes.InvalidArgument},
+	{apperror.ErrWrongVariants, codes.InvalidArgument},
+	{apperror.ErrWrongPayload, codes.InvalidArgument},
+	{apperror.ErrWrongWindow, codes.InvalidArgument},
+	{apperror.ErrWrongSchedule, codes.InvalidArgument},
+	{apperror.ErrWrongWebhook, codes.InvalidArgument},
+	{apperror.ErrWrongBatch, codes.InvalidArgument},
+	{apperror.ErrWrongReportPeriod, codes.InvalidArgument},
+	{apperror.ErrWrongHistoryQuery, codes.InvalidArgument},
+	{apperror.ErrWrongAsOf, codes.InvalidArgument},
+	{apperror.ErrWrongMembersQuery, codes.InvalidArgument},
+	{apperror.ErrWrongMetadata, codes.InvalidArgument},
+	{apperror.ErrWrongSegmentsQuery, codes.InvalidArgument},
+	{apperror.ErrWrongRename, codes.InvalidArgument},
+	{apperror.ErrNoSegment, codes.NotFound},
+	{apperror.ErrNoUser, codes.NotFound},
+	{apperror.ErrNoLayer, codes.NotFound},
+	{apperror.ErrNoWebhook, codes.NotFound},
+	{apperror.ErrNoDeletedSegment, codes.NotFound},
+	{apperror.ErrFileNotFound, codes.NotFound},
+	{apperror.ErrSegmentConflict, codes.FailedPrecondition},
+	{apperror.ErrSegmentPurging, codes.FailedPrecondition},
+	{apperror.ErrSegmentDeleted, codes.AlreadyExists},
+	{apperror.ErrSegmentNameTaken, codes.AlreadyExists},
+	{apperror.ErrGDriveNotAvailable, codes.Unavailable},
+}
+
+// errorStatus логирует ошибку и преобразует её в статус gRPC,
+// неизвестные ошибки не раскрываются клиенту.
+func errorStatus(l *logging.Logger, err error) error {
+	l.Error(err)
+
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return status.Error(e.code, e.err.Message)
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	return status.Error(codes.Internal, apperror.SystemError(err).Message)
+}
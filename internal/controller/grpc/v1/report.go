@@ -0,0 +1,75 @@
+package v1
+
+import (
+	segmentationv1 "avito-internship/api/segmentation/v1"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/service"
+	"avito-internship/pkg/logging"
+	"bufio"
+	"context"
+)
+
+// reportFileChunkSize максимальный размер части файла отчета в одном сообщении
+const reportFileChunkSize = 64 * 1024
+
+type reportServer struct {
+	segmentationv1.UnimplementedReportServiceServer
+	reportService service.Report
+	l             *logging.Logger
+}
+
+// GetUserHistory передаёт историю операций по одной записи,
+// клиент может начинать обработку, не дожидаясь всего отчета.
+func (s *reportServer) GetUserHistory(req *segmentationv1.ReportRequest, stream segmentationv1.ReportService_GetUserHistoryServer) error {
+	request := reportRequestFromProto(req)
+	err := s.reportService.StreamUserHistory(stream.Context(), request, func(record entity.ReportUserHistory) error {
+		return stream.Send(reportUserHistoryToProto(record))
+	})
+	if err != nil {
+		return errorStatus(s.l, err)
+	}
+
+	return nil
+}
+
+func (s *reportServer) MakeReportLink(ctx context.Context, req *segmentationv1.ReportRequest) (*segmentationv1.MakeReportLinkResponse, error) {
+	request := reportRequestFromProto(req)
+	link, err := s.reportService.MakeReportLink(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.MakeReportLinkResponse{Link: link}, nil
+}
+
+// MakeReportFile передаёт файл отчета частями не больше reportFileChunkSize,
+// поэтому размер отчета не ограничен максимальным размером сообщения gRPC.
+func (s *reportServer) MakeReportFile(req *segmentationv1.ReportRequest, stream segmentationv1.ReportService_MakeReportFileServer) error {
+	request := reportRequestFromProto(req)
+	file := bufio.NewWriterSize(reportFileWriter{stream}, reportFileChunkSize)
+	err := s.reportService.WriteReportFile(stream.Context(), request, file)
+	if err == nil {
+		err = file.Flush()
+	}
+	if err != nil {
+		return errorStatus(s.l, err)
+	}
+
+	return nil
+}
+
+// reportFileWriter отправляет записанные данные сообщениями потока MakeReportFile
+type reportFileWriter struct {
+	stream segmentationv1.ReportService_MakeReportFileServer
+}
+
+func (w reportFileWriter) Write(p []byte) (int, error) {
+	for sent := 0; sent < len(p); sent += reportFileChunkSize {
+		chunk := p[sent:min(sent+reportFileChunkSize, len(p))]
+		if err := w.stream.Send(&segmentationv1.MakeReportFileResponse{File: chunk}); err != nil {
+			return sent, err
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,195 @@
+package v1
+
+import (
+	segmentationv1 "avito-internship/api/segmentation/v1"
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/service"
+	"avito-internship/pkg/logging"
+	"context"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type segmentServer struct {
+	segmentationv1.UnimplementedSegmentServiceServer
+	segmentService service.Segment
+	l              *logging.Logger
+}
+
+func (s *segmentServer) CreateSegment(ctx context.Context, req *segmentationv1.CreateSegmentRequest) (*segmentationv1.CreateSegmentResponse, error) {
+	if req.GetSegment() == "" {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	payload, err := payloadFromStruct(req.GetPayload())
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	variants := make([]entity.Variant, 0, len(req.GetVariants()))
+	for _, variant := range req.GetVariants() {
+		variants = append(variants, entity.Variant{Name: variant.GetName(), Weight: int(variant.GetWeight())})
+	}
+
+	request := entity.SegmentRequest{
+		Segment:  req.GetSegment(),
+		Percent:  req.GetPercent(),
+		Rule:     req.GetRule(),
+		Variants: variants,
+		Layer:    req.GetLayer(),
+		Payload:  payload,
+		StartsAt: timeFromTimestamp(req.GetStartsAt()),
+		EndsAt:   timeFromTimestamp(req.GetEndsAt()),
+
+		Description: req.GetDescription(),
+		OwnerTeam:   req.GetOwnerTeam(),
+		Tags:        req.GetTags(),
+		TicketUrl:   req.GetTicketUrl(),
+		Actor:       actorFromContext(ctx),
+	}
+	if err = s.segmentService.CreateSegment(ctx, request); err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.CreateSegmentResponse{}, nil
+}
+
+func (s *segmentServer) UpdateSegment(ctx context.Context, req *segmentationv1.UpdateSegmentRequest) (*segmentationv1.UpdateSegmentResponse, error) {
+	if req.GetSegment() == "" {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	payload, err := payloadFromStruct(req.GetPayload())
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	request := entity.SegmentUpdateRequest{
+		Segment: req.GetSegment(),
+		Percent: req.Percent,
+		Rule:    req.Rule,
+		Payload: payload,
+
+		Description: req.Description,
+		OwnerTeam:   req.OwnerTeam,
+		TicketUrl:   req.TicketUrl,
+		Actor:       actorFromContext(ctx),
+	}
+	if req.GetTags() != nil {
+		request.Tags = append([]string{}, req.GetTags().GetTags()...)
+	}
+	if err = s.segmentService.UpdateSegment(ctx, request); err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.UpdateSegmentResponse{}, nil
+}
+
+func (s *segmentServer) DeleteSegment(ctx context.Context, req *segmentationv1.DeleteSegmentRequest) (*segmentationv1.DeleteSegmentResponse, error) {
+	if req.GetSegment() == "" {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	request := entity.SegmentRequest{Segment: req.GetSegment()}
+	if err := s.segmentService.DeleteSegment(ctx, request); err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.DeleteSegmentResponse{}, nil
+}
+
+func (s *segmentServer) RestoreSegment(ctx context.Context, req *segmentationv1.RestoreSegmentRequest) (*segmentationv1.RestoreSegmentResponse, error) {
+	if req.GetSegment() == "" {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	request := entity.SegmentRestoreRequest{
+		Segment:  req.GetSegment(),
+		Reenroll: req.GetReenroll(),
+		Actor:    actorFromContext(ctx),
+	}
+	restored, err := s.segmentService.RestoreSegment(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.RestoreSegmentResponse{Reenrolled: int64(restored.Reenrolled)}, nil
+}
+
+func (s *segmentServer) RenameSegment(ctx context.Context, req *segmentationv1.RenameSegmentRequest) (*segmentationv1.RenameSegmentResponse, error) {
+	if req.GetSegment() == "" || req.GetNewName() == "" {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	request := entity.SegmentRenameRequest{
+		Segment:    req.GetSegment(),
+		NewName:    req.GetNewName(),
+		AliasUntil: timeFromTimestamp(req.GetAliasUntil()),
+		Actor:      actorFromContext(ctx),
+	}
+	alias, err := s.segmentService.RenameSegment(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.RenameSegmentResponse{AliasUntil: timestamppb.New(alias.AliasUntil)}, nil
+}
+
+func (s *segmentServer) GetMembers(ctx context.Context, req *segmentationv1.GetMembersRequest) (*segmentationv1.GetMembersResponse, error) {
+	if req.GetSegment() == "" {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	request := entity.SegmentMembersRequest{
+		Segment:    req.GetSegment(),
+		AsOf:       timeFromTimestamp(req.GetAsOf()),
+		Membership: req.GetMembership(),
+		Cursor:     req.GetCursor(),
+		Limit:      int(req.GetLimit()),
+	}
+	members, err := s.segmentService.GetMembers(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	response := &segmentationv1.GetMembersResponse{
+		Segment:    members.Segment,
+		AsOf:       timestamppb.New(members.AsOf),
+		Total:      int64(members.Total),
+		NextCursor: members.NextCursor,
+	}
+	for _, member := range members.Users {
+		user := &segmentationv1.SegmentMember{
+			UserId:  int64(member.UserId),
+			Variant: member.Variant,
+			AddedAt: timestamppb.New(member.AddedAt),
+		}
+		if member.LeftAt != nil {
+			user.LeftAt = timestamppb.New(*member.LeftAt)
+		}
+		response.Users = append(response.Users, user)
+	}
+
+	return response, nil
+}
+
+func (s *segmentServer) ListSegments(ctx context.Context, req *segmentationv1.ListSegmentsRequest) (*segmentationv1.ListSegmentsResponse, error) {
+	request := entity.SegmentListRequest{
+		Prefix: req.GetPrefix(),
+		Tags:   req.GetTags(),
+		Status: req.GetStatus(),
+		Cursor: req.GetCursor(),
+		Limit:  int(req.GetLimit()),
+	}
+	segments, err := s.segmentService.GetSegments(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	response := &segmentationv1.ListSegmentsResponse{NextCursor: segments.NextCursor}
+	for _, segment := range segments.Segments {
+		response.Segments = append(response.Segments, segmentInfoToProto(segment))
+	}
+
+	return response, nil
+}
@@ -0,0 +1,14 @@
+package v1
+
+import (
+	segmentationv1 "avito-internship/api/segmentation/v1"
+	"avito-internship/internal/service"
+	"avito-internship/pkg/logging"
+	"google.golang.org/grpc"
+)
+
+func NewServer(server *grpc.Server, l *logging.Logger, services *service.Services) {
+	segmentationv1.RegisterSegmentServiceServer(server, &segmentServer{segmentService: services.Segment, l: l})
+	segmentationv1.RegisterUserServiceServer(server, &userServer{userService: services.User, l: l})
+	segmentationv1.RegisterReportServiceServer(server, &reportServer{reportService: services.Report, l: l})
+}
@@ -0,0 +1,188 @@
+package v1
+
+import (
+	segmentationv1 "avito-internship/api/segmentation/v1"
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/service"
+	"avito-internship/pkg/logging"
+	"context"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type userServer struct {
+	segmentationv1.UnimplementedUserServiceServer
+	userService service.User
+	l           *logging.Logger
+}
+
+func (s *userServer) AddSegments(ctx context.Context, req *segmentationv1.AddSegmentsRequest) (*segmentationv1.AddSegmentsResponse, error) {
+	if req.GetUserId() == 0 || len(req.GetSegments()) == 0 {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+	if req.GetTtl() < 0 {
+		return nil, errorStatus(s.l, apperror.ErrWrongTtl)
+	}
+
+	request := entity.UserAddToSegmentRequest{
+		UserId:   int(req.GetUserId()),
+		Segments: req.GetSegments(),
+		Ttl:      int(req.GetTtl()),
+		StartAt:  timeFromTimestamp(req.GetStartAt()),
+		EndAt:    timeFromTimestamp(req.GetEndAt()),
+		Actor:    actorFromContext(ctx),
+	}
+	aliases, err := s.userService.AddSegment(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+	setDeprecationHeader(ctx, aliases)
+
+	return &segmentationv1.AddSegmentsResponse{}, nil
+}
+
+func (s *userServer) RemoveSegments(ctx context.Context, req *segmentationv1.RemoveSegmentsRequest) (*segmentationv1.RemoveSegmentsResponse, error) {
+	if req.GetUserId() == 0 || len(req.GetSegments()) == 0 {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	request := entity.UserRemoveFromSegmentRequest{
+		UserId:   int(req.GetUserId()),
+		Segments: req.GetSegments(),
+		Actor:    actorFromContext(ctx),
+	}
+	aliases, err := s.userService.RemoveSegment(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+	setDeprecationHeader(ctx, aliases)
+
+	return &segmentationv1.RemoveSegmentsResponse{}, nil
+}
+
+func (s *userServer) GetActiveSegments(ctx context.Context, req *segmentationv1.GetActiveSegmentsRequest) (*segmentationv1.GetActiveSegmentsResponse, error) {
+	request := entity.UserActiveSegmentRequest{UserId: int(req.GetUserId()), AsOf: timeFromTimestamp(req.GetAsOf())}
+	segments, err := s.userService.GetActiveSegments(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	result, err := userSegmentsToProto(segments)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.GetActiveSegmentsResponse{Segments: result}, nil
+}
+
+func (s *userServer) BatchGetActiveSegments(ctx context.Context, req *segmentationv1.BatchGetActiveSegmentsRequest) (*segmentationv1.BatchGetActiveSegmentsResponse, error) {
+	request := entity.UserBatchActiveSegmentRequest{UserIds: make([]int, 0, len(req.GetUserIds()))}
+	for _, id := range req.GetUserIds() {
+		request.UserIds = append(request.UserIds, int(id))
+	}
+
+	users, err := s.userService.GetActiveSegmentsBatch(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	result := make(map[int64]*segmentationv1.UserSegments, len(users))
+	for id, segments := range users {
+		userSegments, err := userSegmentsToProto(segments)
+		if err != nil {
+			return nil, errorStatus(s.l, err)
+		}
+		result[int64(id)] = &segmentationv1.UserSegments{Segments: userSegments}
+	}
+
+	return &segmentationv1.BatchGetActiveSegmentsResponse{Users: result}, nil
+}
+
+func (s *userServer) GetConfig(ctx context.Context, req *segmentationv1.GetConfigRequest) (*segmentationv1.GetConfigResponse, error) {
+	request := entity.UserActiveSegmentRequest{UserId: int(req.GetUserId())}
+	config, err := s.userService.GetConfig(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	segments, err := userSegmentsToProto(config.Segments)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	payload, err := structpb.NewStruct(config.Payload)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.GetConfigResponse{Segments: segments, Payload: payload}, nil
+}
+
+func (s *userServer) SetAttributes(ctx context.Context, req *segmentationv1.SetAttributesRequest) (*segmentationv1.SetAttributesResponse, error) {
+	if req.GetUserId() == 0 || req.GetAttributes() == nil {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	request := entity.UserAttributesRequest{
+		UserId:     int(req.GetUserId()),
+		Attributes: req.GetAttributes().AsMap(),
+	}
+	if err := s.userService.SetAttributes(ctx, request); err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.SetAttributesResponse{}, nil
+}
+
+func (s *userServer) GetHistory(ctx context.Context, req *segmentationv1.GetHistoryRequest) (*segmentationv1.GetHistoryResponse, error) {
+	request := entity.UserHistoryRequest{
+		UserId:   int(req.GetUserId()),
+		Segments: req.GetSegments(),
+		Cursor:   req.GetCursor(),
+		Limit:    int(req.GetLimit()),
+	}
+	if from := timeFromTimestamp(req.GetFrom()); from != nil {
+		request.From = *from
+	}
+	if to := timeFromTimestamp(req.GetTo()); to != nil {
+		request.To = *to
+	}
+
+	history, err := s.userService.GetHistory(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	response := &segmentationv1.GetHistoryResponse{NextCursor: history.NextCursor}
+	for _, record := range history.History {
+		response.History = append(response.History, reportUserHistoryToProto(record))
+	}
+
+	return response, nil
+}
+
+func (s *userServer) EraseUser(ctx context.Context, req *segmentationv1.EraseUserRequest) (*segmentationv1.EraseUserResponse, error) {
+	if req.GetUserId() <= 0 {
+		return nil, errorStatus(s.l, apperror.ErrBadRequest)
+	}
+
+	request := entity.UserEraseRequest{
+		UserId: int(req.GetUserId()),
+		Actor:  actorFromContext(ctx),
+	}
+	receipt, err := s.userService.EraseUser(ctx, request)
+	if err != nil {
+		return nil, errorStatus(s.l, err)
+	}
+
+	return &segmentationv1.EraseUserResponse{
+		ReceiptId:                  receipt.ReceiptId,
+		Memberships:                int64(receipt.Memberships),
+		Events:                     int64(receipt.Events),
+		ReportsToRegenerate:        receipt.Reports,
+		ReportJobsToRegenerate:     receipt.ReportJobs,
+		ErasedAt:                   timestamppb.New(receipt.ErasedAt),
+		PurgeArchivesPseudonymized: receipt.PurgeArchives,
+	}, nil
+}
@@ -0,0 +1,138 @@
+package v1
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/service"
+	"avito-internship/pkg/httpserver"
+	"avito-internship/pkg/logging"
+	"errors"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// eventsPollInterval период опроса журнала событий
+	eventsPollInterval = time.Second
+	// eventsHeartbeatInterval период отправки комментария, не дающего прокси закрыть соединение
+	eventsHeartbeatInterval = 15 * time.Second
+)
+
+type eventRoutes struct {
+	eventService service.Event
+	l            *logging.Logger
+}
+
+func newEventRoutes(h *gin.RouterGroup, eventService service.Event, l *logging.Logger) {
+	r := &eventRoutes{eventService, l}
+
+	{
+		h.GET("/stream", r.stream)
+	}
+}
+
+// @Summary Stream segment membership and segment change events (Server-Sent Events)
+// @Tags events
+// @Produce text/event-stream
+// @Param user_id query string false "user_id"
+// @Param segment query string false "segment"
+// @Param Last-Event-ID header string false "stream id of the last received event"
+// @Success 200 {object} entity.Event
+// @Router /events/stream [get]
+func (r *eventRoutes) stream(c *gin.Context) {
+	var request entity.EventStreamRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("last_event_id")
+	}
+
+	var (
+		afterSeq int64
+		err      error
+	)
+	if lastEventId != "" {
+		afterSeq, err = strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil || afterSeq < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+			return
+		}
+	} else {
+		// Без Last-Event-ID передаются только новые события
+		afterSeq, err = r.eventService.GetLastEventSeq(ctx)
+		if err != nil {
+			r.l.Error(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+			return
+		}
+	}
+
+	// Поток не должен обрываться таймаутом записи http сервера
+	err = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		r.l.Error(err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	poll := time.NewTicker(eventsPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	// Поток закрывается при остановке сервера, клиент переподключится с Last-Event-ID
+	shutdown := httpserver.ShuttingDown(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-shutdown:
+			return
+		case <-heartbeat.C:
+			if _, err = c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-poll.C:
+			written := false
+			err = r.eventService.StreamEvents(ctx, request, afterSeq, func(event entity.Event) error {
+				c.Render(-1, sse.Event{
+					Id:    strconv.FormatInt(event.Seq, 10),
+					Event: event.Type,
+					Data:  event,
+				})
+				afterSeq = event.Seq
+				written = true
+
+				return nil
+			})
+			if written {
+				c.Writer.Flush()
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					r.l.Error(err)
+				}
+
+				return
+			}
+		}
+	}
+}
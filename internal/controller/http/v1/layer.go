@@ -0,0 +1,50 @@
+package v1
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/service"
+	"avito-internship/pkg/logging"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type layerRoutes struct {
+	layerService service.Layer
+	l            *logging.Logger
+}
+
+func newLayerRoutes(h *gin.RouterGroup, layerService service.Layer, l *logging.Logger) {
+	r := &layerRoutes{layerService, l}
+
+	{
+		h.POST("/create", r.create)
+	}
+}
+
+// @Summary Create layer of mutually exclusive segments
+// @Tags layer
+// @Accept json
+// @Produce json
+// @Param request body entity.LayerRequest true "request"
+// @Success 201
+// @Router /layer/create [post]
+func (r *layerRoutes) create(c *gin.Context) {
+	var request entity.LayerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	err := r.layerService.CreateLayer(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "created"})
+}
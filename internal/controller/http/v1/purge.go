@@ -0,0 +1,149 @@
+package v1
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/service"
+	"avito-internship/pkg/logging"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type purgeRoutes struct {
+	purgeService service.Purge
+	l            *logging.Logger
+}
+
+func newPurgeRoutes(h *gin.RouterGroup, purgeService service.Purge, l *logging.Logger) {
+	r := &purgeRoutes{purgeService, l}
+
+	{
+		h.POST("/jobs", r.createJob)
+		h.GET("/jobs/:id", r.getJob)
+		h.GET("/jobs/:id/file", r.getJobFile)
+	}
+}
+
+// @Summary Create background job purging deleted segment with all its history
+// @Tags purge
+// @Accept json
+// @Produce json
+// @Param request body entity.PurgeJobRequest true "request"
+// @Success 202 {object} entity.PurgeJobCreateResponse
+// @Failure 404 {object} apperror.AppError
+// @Router /purge/jobs [post]
+func (r *purgeRoutes) createJob(c *gin.Context) {
+	var request entity.PurgeJobRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	id, err := r.purgeService.CreatePurgeJob(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrNoDeletedSegment) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoDeletedSegment)
+
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("%s/%d", c.Request.URL.Path, id))
+	c.JSON(http.StatusAccepted, entity.PurgeJobCreateResponse{Id: id})
+}
+
+// @Summary Get purge job status and number of purged records
+// @Tags purge
+// @Produce json
+// @Param id path int true "job id"
+// @Success 200 {object} entity.PurgeJob
+// @Failure 404 {object} apperror.AppError
+// @Router /purge/jobs/{id} [get]
+func (r *purgeRoutes) getJob(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	job, err := r.purgeService.GetPurgeJob(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoPurgeJob) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoPurgeJob)
+
+			return
+		}
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	if job.Archive && job.Status == entity.PurgeJobStatusDone {
+		job.Location = c.Request.URL.Path + "/file"
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// @Summary Download history archive saved by purge job
+// @Tags purge
+// @Produce text/csv
+// @Param id path int true "job id"
+// @Success 200 {object} []byte
+// @Failure 404 {object} apperror.AppError
+// @Failure 409 {object} apperror.AppError
+// @Router /purge/jobs/{id}/file [get]
+func (r *purgeRoutes) getJobFile(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	// Архив передаётся частями по мере чтения из бд, запись может занять больше таймаута http сервера
+	err = http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(reportFileTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		r.l.Error(err)
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=archive.csv")
+	c.Status(http.StatusOK)
+
+	err = r.purgeService.WritePurgeJobFile(c.Request.Context(), id, c.Writer)
+	if err != nil {
+		// Пока в ответ ничего не записано, клиенту можно вернуть ошибку
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			if errors.Is(err, apperror.ErrNoPurgeJob) {
+				c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoPurgeJob)
+
+				return
+			}
+			if errors.Is(err, apperror.ErrPurgeArchiveNotReady) {
+				c.AbortWithStatusJSON(http.StatusConflict, apperror.ErrPurgeArchiveNotReady)
+
+				return
+			}
+			r.l.Error(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+			return
+		}
+		r.l.Error(err)
+	}
+}
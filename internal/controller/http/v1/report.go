@@ -6,11 +6,16 @@ import (
 	"avito-internship/internal/service"
 	"avito-internship/pkg/logging"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
+	"time"
 )
 
+// reportFileTimeout время на передачу csv отчета, превышающее таймаут записи http сервера
+const reportFileTimeout = 10 * time.Minute
+
 type reportRoutes struct {
 	reportService service.Report
 	l             *logging.Logger
@@ -23,34 +28,40 @@ func newReportRoutes(h *gin.RouterGroup, reportService service.Report, l *loggin
 		h.GET("/", r.getHistory)
 		h.GET("/link", r.getReportLink)
 		h.GET("/file", r.getReportFile)
+		h.POST("/jobs", r.createJob)
+		h.GET("/jobs/:id", r.getJob)
+		h.GET("/jobs/:id/file", r.getJobFile)
 	}
 }
 
 // @Summary Get history JSON
 // @Tags report
 // @Produce json
-// @Param month query string true "month"
-// @Param year query string true "year"
+// @Param month query int false "month (together with year)"
+// @Param year query int false "year (together with month)"
+// @Param from query string false "period start, RFC3339 (inclusive)"
+// @Param to query string false "period end, RFC3339 (exclusive)"
+// @Param segment query []string false "segment names" collectionFormat(multi)
+// @Param user_id query []int false "user ids" collectionFormat(multi)
+// @Param operation query string false "operation" Enums(add, remove)
 // @Success 200 {object} []entity.ReportUserHistory
 // @Router /report/ [get]
 func (r *reportRoutes) getHistory(c *gin.Context) {
-	month, err := strconv.Atoi(c.Query("month"))
-	if err != nil {
+	var request entity.ReportRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
 
 		return
 	}
-	year, err := strconv.Atoi(c.Query("year"))
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
 
-		return
-	}
-
-	request := entity.ReportRequest{Month: month, Year: year}
 	userHistory, err := r.reportService.GetUserHistory(c.Request.Context(), request)
 	if err != nil {
 		r.l.Error(err)
+		if errors.Is(err, apperror.ErrWrongReportPeriod) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongReportPeriod)
+
+			return
+		}
 		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
 
 		return
@@ -62,28 +73,31 @@ func (r *reportRoutes) getHistory(c *gin.Context) {
 // @Summary Get report file
 // @Tags report
 // @Produce json
-// @Param month query string true "month"
-// @Param year query string true "year"
+// @Param month query int false "month (together with year)"
+// @Param year query int false "year (together with month)"
+// @Param from query string false "period start, RFC3339 (inclusive)"
+// @Param to query string false "period end, RFC3339 (exclusive)"
+// @Param segment query []string false "segment names" collectionFormat(multi)
+// @Param user_id query []int false "user ids" collectionFormat(multi)
+// @Param operation query string false "operation" Enums(add, remove)
 // @Success 200 {object} map[string]string
 // @Router /report/link [get]
 func (r *reportRoutes) getReportLink(c *gin.Context) {
-	month, err := strconv.Atoi(c.Query("month"))
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
-
-		return
-	}
-	year, err := strconv.Atoi(c.Query("year"))
-	if err != nil {
+	var request entity.ReportRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
 
 		return
 	}
 
-	request := entity.ReportRequest{Month: month, Year: year}
 	link, err := r.reportService.MakeReportLink(c.Request.Context(), request)
 	if err != nil {
 		r.l.Error(err)
+		if errors.Is(err, apperror.ErrWrongReportPeriod) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongReportPeriod)
+
+			return
+		}
 		if errors.Is(err, apperror.ErrGDriveNotAvailable) {
 			c.AbortWithStatusJSON(http.StatusOK, apperror.ErrGDriveNotAvailable)
 
@@ -100,32 +114,175 @@ func (r *reportRoutes) getReportLink(c *gin.Context) {
 // @Summary Get report file
 // @Tags report
 // @Produce text/csv
-// @Param month query string true "month"
-// @Param year query string true "year"
+// @Param month query int false "month (together with year)"
+// @Param year query int false "year (together with month)"
+// @Param from query string false "period start, RFC3339 (inclusive)"
+// @Param to query string false "period end, RFC3339 (exclusive)"
+// @Param segment query []string false "segment names" collectionFormat(multi)
+// @Param user_id query []int false "user ids" collectionFormat(multi)
+// @Param operation query string false "operation" Enums(add, remove)
 // @Success 200 {object} []byte
 // @Router /report/file [get]
 func (r *reportRoutes) getReportFile(c *gin.Context) {
-	month, err := strconv.Atoi(c.Query("month"))
+	var request entity.ReportRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	// Отчет передаётся по мере чтения из бд (chunked), запись может занять больше таймаута http сервера
+	err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(reportFileTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		r.l.Error(err)
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=report.csv")
+	c.Status(http.StatusOK)
+
+	err = r.reportService.WriteReportFile(c.Request.Context(), request, c.Writer)
 	if err != nil {
+		r.l.Error(err)
+		// Пока в ответ ничего не записано, клиенту можно вернуть ошибку
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			if errors.Is(err, apperror.ErrWrongReportPeriod) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongReportPeriod)
+
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+		}
+
+		return
+	}
+}
+
+// @Summary Create background report job
+// @Tags report
+// @Accept json
+// @Produce json
+// @Param request body entity.ReportJobRequest true "request"
+// @Success 202 {object} entity.ReportJobCreateResponse
+// @Router /report/jobs [post]
+func (r *reportRoutes) createJob(c *gin.Context) {
+	var request entity.ReportJobRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
 		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
 
 		return
 	}
-	year, err := strconv.Atoi(c.Query("year"))
+
+	id, err := r.reportService.CreateReportJob(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrWrongReportJob) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongReportJob)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrGDriveNotAvailable) {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, apperror.ErrGDriveNotAvailable)
+
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("%s/%d", c.Request.URL.Path, id))
+	c.JSON(http.StatusAccepted, entity.ReportJobCreateResponse{Id: id})
+}
+
+// @Summary Get report job status and progress
+// @Tags report
+// @Produce json
+// @Param id path int true "job id"
+// @Success 200 {object} entity.ReportJob
+// @Failure 404 {object} apperror.AppError
+// @Router /report/jobs/{id} [get]
+func (r *reportRoutes) getJob(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
 
 		return
 	}
 
-	request := entity.ReportRequest{Month: month, Year: year}
-	file, err := r.reportService.MakeReportFile(c.Request.Context(), request)
+	job, err := r.reportService.GetReportJob(c.Request.Context(), id)
 	if err != nil {
+		if errors.Is(err, apperror.ErrNoReportJob) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoReportJob)
+
+			return
+		}
 		r.l.Error(err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
 
 		return
 	}
 
-	c.Data(http.StatusOK, "text/csv", file)
+	if job.Status == entity.ReportJobStatusDone {
+		job.Location = job.Link
+		if job.Type == entity.ReportJobTypeFile {
+			job.Location = c.Request.URL.Path + "/file"
+		}
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// @Summary Download report file of completed job
+// @Tags report
+// @Produce text/csv
+// @Param id path int true "job id"
+// @Success 200 {object} []byte
+// @Failure 404 {object} apperror.AppError
+// @Failure 409 {object} apperror.AppError
+// @Router /report/jobs/{id}/file [get]
+func (r *reportRoutes) getJobFile(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	// Файл передаётся частями по мере чтения из бд, запись может занять больше таймаута http сервера
+	err = http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(reportFileTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		r.l.Error(err)
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=report.csv")
+	c.Status(http.StatusOK)
+
+	err = r.reportService.WriteReportJobFile(c.Request.Context(), id, c.Writer)
+	if err != nil {
+		// Пока в ответ ничего не записано, клиенту можно вернуть ошибку
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			if errors.Is(err, apperror.ErrNoReportJob) {
+				c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoReportJob)
+
+				return
+			}
+			if errors.Is(err, apperror.ErrReportNotReady) {
+				c.AbortWithStatusJSON(http.StatusConflict, apperror.ErrReportNotReady)
+
+				return
+			}
+			r.l.Error(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+			return
+		}
+		r.l.Error(err)
+	}
 }
@@ -20,6 +20,10 @@ func NewRouter(handler *gin.Engine, l *logging.Logger, services *service.Service
 		newSegmentRoutes(h.Group("/segment"), services.Segment, l)
 		newUserRoutes(h.Group("/user"), services.User, l)
 		newReportRoutes(h.Group("/report"), services.Report, l)
+		newPurgeRoutes(h.Group("/purge"), services.Purge, l)
+		newLayerRoutes(h.Group("/layer"), services.Layer, l)
+		newWebhookRoutes(h.Group("/webhook"), services.Webhook, l)
+		newEventRoutes(h.Group("/events"), services.Event, l)
 	}
 
 }
@@ -19,8 +19,13 @@ func newSegmentRoutes(h *gin.RouterGroup, segmentService service.Segment, l *log
 	r := &segmentRoutes{segmentService, l}
 
 	{
+		h.GET("", r.list)
 		h.POST("/create", r.create)
+		h.PUT("/update", r.update)
 		h.DELETE("/delete", r.delete)
+		h.POST("/restore", r.restore)
+		h.POST("/rename", r.rename)
+		h.GET("/:name/users", r.getMembers)
 	}
 }
 
@@ -29,7 +34,9 @@ func newSegmentRoutes(h *gin.RouterGroup, segmentService service.Segment, l *log
 // @Accept json
 // @Produce json
 // @Param request body entity.SegmentRequest true "request"
+// @Param X-Actor header string false "initiator of the change, saved to the segment catalog"
 // @Success 201
+// @Failure 409 {object} apperror.AppError
 // @Router /segment/create [post]
 func (r *segmentRoutes) create(c *gin.Context) {
 	var request entity.SegmentRequest
@@ -39,6 +46,7 @@ func (r *segmentRoutes) create(c *gin.Context) {
 
 		return
 	}
+	request.Actor = c.GetHeader(actorHeader)
 
 	err := r.segmentService.CreateSegment(c.Request.Context(), request)
 	if err != nil {
@@ -48,6 +56,51 @@ func (r *segmentRoutes) create(c *gin.Context) {
 
 			return
 		}
+		if errors.Is(err, apperror.ErrWrongRule) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongRule)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrRuleInLayer) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrRuleInLayer)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrWrongPayload) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongPayload)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrWrongVariants) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongVariants)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrWrongWindow) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongWindow)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrNoLayer) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrNoLayer)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrWrongMetadata) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongMetadata)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrSegmentDeleted) {
+			c.AbortWithStatusJSON(http.StatusConflict, apperror.ErrSegmentDeleted)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrSegmentNameTaken) {
+			c.AbortWithStatusJSON(http.StatusConflict, apperror.ErrSegmentNameTaken)
+
+			return
+		}
 
 		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
 
@@ -57,12 +110,73 @@ func (r *segmentRoutes) create(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"message": "created"})
 }
 
+// @Summary Update segment percent, rule, payload and catalog metadata
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param request body entity.SegmentUpdateRequest true "request"
+// @Param X-Actor header string false "initiator of the change, saved to the segment catalog"
+// @Success 200
+// @Router /segment/update [put]
+func (r *segmentRoutes) update(c *gin.Context) {
+	var request entity.SegmentUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+	request.Actor = c.GetHeader(actorHeader)
+
+	err := r.segmentService.UpdateSegment(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrWrongPercent) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongPercent)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrWrongRule) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongRule)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrRuleInLayer) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrRuleInLayer)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrWrongPayload) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongPayload)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrWrongMetadata) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongMetadata)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrNoSegment) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrNoSegment)
+
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+}
+
 // @Summary Delete segment
 // @Tags segment
 // @Accept json
 // @Produce json
 // @Param request body entity.SegmentRequest true "request"
 // @Success 200
+// @Failure 404 {object} apperror.AppError
 // @Router /segment/delete [delete]
 func (r *segmentRoutes) delete(c *gin.Context) {
 	var request entity.SegmentRequest
@@ -76,6 +190,11 @@ func (r *segmentRoutes) delete(c *gin.Context) {
 
 	err := r.segmentService.DeleteSegment(c.Request.Context(), request)
 	if err != nil {
+		if errors.Is(err, apperror.ErrNoSegment) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoSegment)
+
+			return
+		}
 		r.l.Error(err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
 
@@ -84,3 +203,171 @@ func (r *segmentRoutes) delete(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
 }
+
+// @Summary Restore deleted segment
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param request body entity.SegmentRestoreRequest true "request"
+// @Param X-Actor header string false "initiator of the change, saved to the segment catalog and the user history"
+// @Success 200 {object} entity.SegmentRestoreResponse
+// @Failure 404 {object} apperror.AppError
+// @Failure 409 {object} apperror.AppError
+// @Router /segment/restore [post]
+func (r *segmentRoutes) restore(c *gin.Context) {
+	var request entity.SegmentRestoreRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+	request.Actor = c.GetHeader(actorHeader)
+
+	restored, err := r.segmentService.RestoreSegment(c.Request.Context(), request)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoDeletedSegment) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoDeletedSegment)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrSegmentPurging) {
+			c.AbortWithStatusJSON(http.StatusConflict, apperror.ErrSegmentPurging)
+
+			return
+		}
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, restored)
+}
+
+// @Summary Rename segment
+// @Tags segment
+// @Accept json
+// @Produce json
+// @Param request body entity.SegmentRenameRequest true "request"
+// @Param X-Actor header string false "initiator of the change, saved to the segment catalog"
+// @Success 200 {object} entity.SegmentAlias
+// @Failure 404 {object} apperror.AppError
+// @Failure 409 {object} apperror.AppError
+// @Router /segment/rename [post]
+func (r *segmentRoutes) rename(c *gin.Context) {
+	var request entity.SegmentRenameRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+	request.Actor = c.GetHeader(actorHeader)
+
+	alias, err := r.segmentService.RenameSegment(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrWrongRename) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongRename)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrNoSegment) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoSegment)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrSegmentNameTaken) {
+			c.AbortWithStatusJSON(http.StatusConflict, apperror.ErrSegmentNameTaken)
+
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, alias)
+}
+
+// @Summary List segments catalog
+// @Tags segment
+// @Produce json
+// @Param prefix query string false "segment name prefix"
+// @Param tag query []string false "segment tags (all must match)" collectionFormat(multi)
+// @Param status query string false "segment status" Enums(active, scheduled, ended, deleted)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "page size (1-500, default 100)"
+// @Success 200 {object} entity.SegmentListResponse
+// @Failure 400 {object} apperror.AppError
+// @Router /segment [get]
+func (r *segmentRoutes) list(c *gin.Context) {
+	var request entity.SegmentListRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	segments, err := r.segmentService.GetSegments(c.Request.Context(), request)
+	if err != nil {
+		if errors.Is(err, apperror.ErrWrongSegmentsQuery) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongSegmentsQuery)
+
+			return
+		}
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, segments)
+}
+
+// @Summary Get users of segment at point in time
+// @Tags segment
+// @Produce json
+// @Param name path string true "segment name"
+// @Param as_of query string false "point in time, RFC3339 (default now)"
+// @Param membership query string false "membership type" Enums(ttl, permanent)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "page size (1-1000, default 100)"
+// @Success 200 {object} entity.SegmentMembersResponse
+// @Failure 404 {object} apperror.AppError
+// @Router /segment/{name}/users [get]
+func (r *segmentRoutes) getMembers(c *gin.Context) {
+	var request entity.SegmentMembersRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+	request.Segment = c.Param("name")
+
+	members, err := r.segmentService.GetMembers(c.Request.Context(), request)
+	if err != nil {
+		if errors.Is(err, apperror.ErrWrongAsOf) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongAsOf)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrWrongMembersQuery) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongMembersQuery)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrNoSegment) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoSegment)
+
+			return
+		}
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
@@ -8,9 +8,21 @@ import (
 	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// actorHeader заголовок с инициатором изменения, сохраняется в истории пользователя и в каталоге сегментов
+const actorHeader = "X-Actor"
+
+// aliasesHeader заголовок ответа со списком использованных устаревших названий сегментов в виде "прежнее=текущее"
+const aliasesHeader = "X-Segment-Aliases"
+
+// importTimeout время на загрузку и применение файла импорта, превышающее таймауты http сервера
+const importTimeout = 5 * time.Minute
+
 type userRoutes struct {
 	userService service.User
 	l           *logging.Logger
@@ -21,8 +33,15 @@ func newUserRoutes(h *gin.RouterGroup, userService service.User, l *logging.Logg
 
 	{
 		h.POST("/add", r.add)
+		h.POST("/import", r.importSegments)
 		h.DELETE("/remove", r.remove)
+		h.DELETE("/:id", r.erase)
 		h.GET("/get", r.get)
+		h.GET("/:id/history", r.getHistory)
+		// gin не поддерживает экранирование ':' в пути, поэтому имя метода сегментов разбирается в обработчике
+		h.POST("/segments:method", r.segmentsMethod)
+		h.GET("/config", r.getConfig)
+		h.POST("/attributes", r.setAttributes)
 	}
 }
 
@@ -31,7 +50,11 @@ func newUserRoutes(h *gin.RouterGroup, userService service.User, l *logging.Logg
 // @Accept json
 // @Produce json
 // @Param request body entity.UserAddToSegmentRequest true "request"
+// @Param X-Actor header string false "initiator of the change, saved to the user history"
 // @Success 200
+// @Failure 409 {object} apperror.AppError
+// @Header 200 {string} Deprecation "true if a renamed segment was referenced by its old name"
+// @Header 200 {string} X-Segment-Aliases "old=current names of renamed segments used in the request"
 // @Router /user/add [post]
 func (r *userRoutes) add(c *gin.Context) {
 	var request entity.UserAddToSegmentRequest
@@ -48,8 +71,9 @@ func (r *userRoutes) add(c *gin.Context) {
 
 		return
 	}
+	request.Actor = c.GetHeader(actorHeader)
 
-	err := r.userService.AddSegment(c.Request.Context(), request)
+	aliases, err := r.userService.AddSegment(c.Request.Context(), request)
 	if err != nil {
 		r.l.Error(err)
 		if errors.Is(err, apperror.ErrNoSegment) {
@@ -57,19 +81,98 @@ func (r *userRoutes) add(c *gin.Context) {
 
 			return
 		}
+		if errors.Is(err, apperror.ErrWrongSchedule) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongSchedule)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrSegmentConflict) {
+			c.AbortWithStatusJSON(http.StatusConflict, apperror.ErrSegmentConflict)
+
+			return
+		}
 		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
 
 		return
 	}
+
+	setDeprecationHeaders(c, aliases)
 	c.JSON(http.StatusOK, gin.H{"message": "added"})
 }
 
+// @Summary Import users to segments from CSV or JSON Lines file
+// @Tags user
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "file with rows user_id,segment,ttl (.csv or .jsonl)"
+// @Param dry_run query bool false "validate rows without saving changes"
+// @Success 200 {object} entity.UserImportResponse
+// @Router /user/import [post]
+func (r *userRoutes) importSegments(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	controller := http.NewResponseController(c.Writer)
+	err = controller.SetReadDeadline(time.Now().Add(importTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		r.l.Error(err)
+	}
+	err = controller.SetWriteDeadline(time.Now().Add(importTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		r.l.Error(err)
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+	defer file.Close()
+
+	request := entity.UserImportRequest{
+		File:   file,
+		Format: strings.TrimPrefix(strings.ToLower(filepath.Ext(fileHeader.Filename)), "."),
+		DryRun: dryRun,
+	}
+	response, err := r.userService.ImportSegments(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrWrongImportFile) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongImportFile)
+
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // @Summary Remove user from segment
 // @Tags user
 // @Accept json
 // @Produce json
 // @Param request body entity.UserRemoveFromSegmentRequest true "request"
+// @Param X-Actor header string false "initiator of the change, saved to the user history"
 // @Success 200
+// @Header 200 {string} Deprecation "true if a renamed segment was referenced by its old name"
+// @Header 200 {string} X-Segment-Aliases "old=current names of renamed segments used in the request"
 // @Router /user/remove [delete]
 func (r *userRoutes) remove(c *gin.Context) {
 	var request entity.UserRemoveFromSegmentRequest
@@ -79,8 +182,9 @@ func (r *userRoutes) remove(c *gin.Context) {
 
 		return
 	}
+	request.Actor = c.GetHeader(actorHeader)
 
-	err := r.userService.RemoveSegment(c.Request.Context(), request)
+	aliases, err := r.userService.RemoveSegment(c.Request.Context(), request)
 	if err != nil {
 		r.l.Error(err)
 		if errors.Is(err, apperror.ErrNoUser) {
@@ -93,14 +197,56 @@ func (r *userRoutes) remove(c *gin.Context) {
 		return
 	}
 
+	setDeprecationHeaders(c, aliases)
 	c.JSON(http.StatusOK, gin.H{"message": "removed"})
 }
 
+// @Summary Erase user (right to be forgotten)
+// @Tags user
+// @Produce json
+// @Param id path int true "user id"
+// @Param X-Actor header string false "initiator of the erasure, saved to the erasure audit record"
+// @Success 200 {object} entity.UserErasureReceipt
+// @Failure 404 {object} apperror.AppError
+// @Failure 503 {object} apperror.AppError
+// @Router /user/{id} [delete]
+func (r *userRoutes) erase(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	// Отрицательные id принадлежат псевдонимам уже удалённых пользователей
+	if err != nil || userId <= 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	request := entity.UserEraseRequest{UserId: userId, Actor: c.GetHeader(actorHeader)}
+	receipt, err := r.userService.EraseUser(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrNoUser) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoUser)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrGDriveNotAvailable) {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, apperror.ErrGDriveNotAvailable)
+
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, receipt)
+}
+
 // @Summary Get active user's segments
 // @Tags user
 // @Produce json
 // @Param user_id query string true "user_id"
-// @Success 200 {object} map[string][]string
+// @Param as_of query string false "point in time, RFC3339 (segments from membership history only)"
+// @Success 200 {object} map[string][]entity.UserSegment
 // @Router /user/get [get]
 func (r *userRoutes) get(c *gin.Context) {
 	userId, err := strconv.Atoi(c.Query("user_id"))
@@ -111,8 +257,23 @@ func (r *userRoutes) get(c *gin.Context) {
 	}
 
 	request := entity.UserActiveSegmentRequest{UserId: userId}
+	if asOf := c.Query("as_of"); asOf != "" {
+		at, err := time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+			return
+		}
+		request.AsOf = &at
+	}
+
 	segments, err := r.userService.GetActiveSegments(c.Request.Context(), request)
 	if err != nil {
+		if errors.Is(err, apperror.ErrWrongAsOf) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongAsOf)
+
+			return
+		}
 		if errors.Is(err, apperror.ErrNoUser) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrNoUser)
 
@@ -126,3 +287,172 @@ func (r *userRoutes) get(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"segment": segments})
 }
+
+// @Summary Get membership history of user
+// @Tags user
+// @Produce json
+// @Param id path int true "user id"
+// @Param segment query []string false "segment names" collectionFormat(multi)
+// @Param from query string false "period start, RFC3339 (inclusive)"
+// @Param to query string false "period end, RFC3339 (exclusive)"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "page size (1-500, default 50)"
+// @Success 200 {object} entity.UserHistoryResponse
+// @Failure 404 {object} apperror.AppError
+// @Router /user/{id}/history [get]
+func (r *userRoutes) getHistory(c *gin.Context) {
+	var request entity.UserHistoryRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+	request.UserId = userId
+
+	history, err := r.userService.GetHistory(c.Request.Context(), request)
+	if err != nil {
+		if errors.Is(err, apperror.ErrWrongHistoryQuery) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongHistoryQuery)
+
+			return
+		}
+		if errors.Is(err, apperror.ErrNoUser) {
+			c.AbortWithStatusJSON(http.StatusNotFound, apperror.ErrNoUser)
+
+			return
+		}
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+func (r *userRoutes) segmentsMethod(c *gin.Context) {
+	switch c.Param("method") {
+	case ":batchGet":
+		r.batchGet(c)
+	default:
+		c.AbortWithStatus(http.StatusNotFound)
+	}
+}
+
+// @Summary Get active segments of many users
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body entity.UserBatchActiveSegmentRequest true "request"
+// @Success 200 {object} entity.UserBatchActiveSegmentResponse
+// @Router /user/segments:batchGet [post]
+func (r *userRoutes) batchGet(c *gin.Context) {
+	var request entity.UserBatchActiveSegmentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	users, err := r.userService.GetActiveSegmentsBatch(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrWrongBatch) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongBatch)
+
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, entity.UserBatchActiveSegmentResponse{Users: users})
+}
+
+// @Summary Get active user's segments with merged payloads
+// @Tags user
+// @Produce json
+// @Param user_id query string true "user_id"
+// @Success 200 {object} entity.UserConfigResponse
+// @Router /user/config [get]
+func (r *userRoutes) getConfig(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	request := entity.UserActiveSegmentRequest{UserId: userId}
+	config, err := r.userService.GetConfig(c.Request.Context(), request)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNoUser) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrNoUser)
+
+			return
+		}
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// @Summary Set user's attributes for rule-based segments
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param request body entity.UserAttributesRequest true "request"
+// @Success 200
+// @Router /user/attributes [post]
+func (r *userRoutes) setAttributes(c *gin.Context) {
+	var request entity.UserAttributesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	err := r.userService.SetAttributes(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "saved"})
+}
+
+// setDeprecationHeaders помечает ответ, в запросе которого использованы устаревшие названия сегментов:
+// Deprecation, Sunset (ближайшее окончание действия псевдонимов) и список псевдонимов
+func setDeprecationHeaders(c *gin.Context, aliases []entity.SegmentAlias) {
+	if len(aliases) == 0 {
+		return
+	}
+
+	sunset := aliases[0].AliasUntil
+	pairs := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		pairs = append(pairs, alias.Alias+"="+alias.Segment)
+		if alias.AliasUntil.Before(sunset) {
+			sunset = alias.AliasUntil
+		}
+	}
+
+	c.Header("Deprecation", "true")
+	c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+	c.Header(aliasesHeader, strings.Join(pairs, ","))
+}
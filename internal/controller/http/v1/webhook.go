@@ -0,0 +1,111 @@
+package v1
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/service"
+	"avito-internship/pkg/logging"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type webhookRoutes struct {
+	webhookService service.Webhook
+	l              *logging.Logger
+}
+
+func newWebhookRoutes(h *gin.RouterGroup, webhookService service.Webhook, l *logging.Logger) {
+	r := &webhookRoutes{webhookService, l}
+
+	{
+		h.POST("/create", r.create)
+		h.GET("/list", r.list)
+		h.DELETE("/delete", r.delete)
+	}
+}
+
+// @Summary Subscribe webhook to segment events
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param request body entity.WebhookRequest true "request"
+// @Success 201 {object} entity.WebhookCreateResponse
+// @Failure 400 {object} apperror.AppError
+// @Router /webhook/create [post]
+func (r *webhookRoutes) create(c *gin.Context) {
+	var request entity.WebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	webhookId, err := r.webhookService.CreateWebhook(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrWrongWebhook) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrWrongWebhook)
+
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, entity.WebhookCreateResponse{Id: webhookId})
+}
+
+// @Summary Get webhook subscriptions
+// @Tags webhook
+// @Produce json
+// @Success 200 {array} entity.Webhook
+// @Router /webhook/list [get]
+func (r *webhookRoutes) list(c *gin.Context) {
+	webhooks, err := r.webhookService.GetWebhooks(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// @Summary Delete webhook subscription
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param request body entity.WebhookDeleteRequest true "request"
+// @Success 200
+// @Failure 400 {object} apperror.AppError
+// @Router /webhook/delete [delete]
+func (r *webhookRoutes) delete(c *gin.Context) {
+	var request entity.WebhookDeleteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		r.l.Error(apperror.ErrBadRequest)
+		c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrBadRequest)
+
+		return
+	}
+
+	err := r.webhookService.DeleteWebhook(c.Request.Context(), request)
+	if err != nil {
+		r.l.Error(err)
+		if errors.Is(err, apperror.ErrNoWebhook) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apperror.ErrNoWebhook)
+
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusInternalServerError, apperror.SystemError(err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
@@ -0,0 +1,42 @@
+package entity
+
+import "time"
+
+const (
+	EventMembershipAdded   = "membership.added"
+	EventMembershipRemoved = "membership.removed"
+	EventSegmentCreated    = "segment.created"
+	EventSegmentDeleted    = "segment.deleted"
+	EventSegmentRestored   = "segment.restored"
+	EventSegmentRenamed    = "segment.renamed"
+)
+
+const (
+	RemovalReasonManual         = "manual"
+	RemovalReasonExpired        = "expired"
+	RemovalReasonRollout        = "rollout"
+	RemovalReasonSegmentEnded   = "segment_ended"
+	RemovalReasonSegmentDeleted = "segment_deleted"
+	RemovalReasonUserErased     = "user_erased"
+)
+
+// EventTypes все типы событий, на которые можно подписаться
+var EventTypes = []string{EventMembershipAdded, EventMembershipRemoved, EventSegmentCreated, EventSegmentDeleted,
+	EventSegmentRestored, EventSegmentRenamed}
+
+type Event struct {
+	Id         int64     `json:"id"                                example:"42"`
+	Type       string    `json:"type"                              example:"membership.removed"`
+	UserId     int       `json:"user_id,omitempty"                 example:"1000"`
+	Segment    string    `json:"segment"                           example:"AVITO_VOICE_MESSAGES"`
+	Variant    string    `json:"variant,omitempty"                 example:"control"`
+	Reason     string    `json:"reason,omitempty"                  example:"expired"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// Seq номер события в потоке, передаётся в SSE как id события
+	Seq int64 `json:"-"`
+}
+
+type EventStreamRequest struct {
+	UserId  int    `form:"user_id"                            example:"1000"`
+	Segment string `form:"segment"                            example:"AVITO_VOICE_MESSAGES"`
+}
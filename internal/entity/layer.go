@@ -0,0 +1,5 @@
+package entity
+
+type LayerRequest struct {
+	Layer string `json:"layer"         binding:"required"  example:"CHECKOUT_EXPERIMENTS"`
+}
@@ -0,0 +1,44 @@
+package entity
+
+import "time"
+
+const (
+	PurgeJobTypeSegment   = "segment"
+	PurgeJobTypeRetention = "retention"
+)
+
+const (
+	PurgeJobStatusPending = "pending"
+	PurgeJobStatusRunning = "running"
+	PurgeJobStatusDone    = "done"
+	PurgeJobStatusFailed  = "failed"
+)
+
+// PurgeJobRequest запрос окончательного удаления сегмента со всей историей (сегмент должен быть удалён),
+// Archive - перед удалением сохранить историю сегмента в csv файл
+type PurgeJobRequest struct {
+	Segment string `json:"segment"       binding:"required"  example:"AVITO_VOICE_MESSAGES"`
+	Archive bool   `json:"archive"       example:"true"`
+}
+
+type PurgeJobCreateResponse struct {
+	Id int64 `json:"id"                                      example:"1"`
+}
+
+// PurgeJob задание на окончательное удаление истории,
+// Cutoff - граница retention, Purged - количество уже удалённых записей членства
+type PurgeJob struct {
+	Id         int64      `json:"id"                          example:"1"`
+	Type       string     `json:"type"                        example:"segment"`
+	SegmentId  int        `json:"-"`
+	Segment    string     `json:"segment,omitempty"           example:"AVITO_VOICE_MESSAGES"`
+	Cutoff     *time.Time `json:"cutoff,omitempty"`
+	Archive    bool       `json:"archive"                     example:"true"`
+	Status     string     `json:"status"                      example:"running"`
+	Purged     int64      `json:"purged"                      example:"20000"`
+	Attempts   int        `json:"-"`
+	Location   string     `json:"location,omitempty"          example:"/api/v1/purge/jobs/1/file"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -2,9 +2,33 @@ package entity
 
 import "time"
 
+const (
+	ReportJobTypeFile = "file"
+	ReportJobTypeLink = "link"
+)
+
+const (
+	ReportJobStatusPending = "pending"
+	ReportJobStatusRunning = "running"
+	ReportJobStatusDone    = "done"
+	ReportJobStatusFailed  = "failed"
+)
+
+const (
+	ReportOperationAdd    = "add"
+	ReportOperationRemove = "remove"
+)
+
+// ReportRequest период отчета задаётся месяцем и годом либо границами from (включительно) и to (не включительно),
+// остальные поля - необязательные фильтры
 type ReportRequest struct {
-	Month int
-	Year  int
+	Month     int       `form:"month"                                                 example:"8"`
+	Year      int       `form:"year"                                                  example:"2023"`
+	From      time.Time `form:"from"       time_format:"2006-01-02T15:04:05Z07:00"`
+	To        time.Time `form:"to"         time_format:"2006-01-02T15:04:05Z07:00"`
+	Segments  []string  `form:"segment"`
+	UserIds   []int     `form:"user_id"`
+	Operation string    `form:"operation"                                             example:"add"`
 }
 
 type ReportResponse struct {
@@ -14,6 +38,39 @@ type ReportResponse struct {
 type ReportUserHistory struct {
 	UserId    string    `json:"user_id"       binding:"required"`
 	Segment   string    `json:"segment"       binding:"required"`
+	Variant   string    `json:"variant"`
 	Operation string    `json:"operation"     binding:"required"`
+	Reason    string    `json:"reason,omitempty"`
 	Date      time.Time `json:"date"          binding:"required"`
+	// SegmentAtEvent название сегмента в момент операции (Segment - текущее название)
+	SegmentAtEvent string `json:"segment_at_event"                  example:"AVITO_VOICE_MESSAGES"`
+	// MembershipId, Source и Actor заполняются только в истории пользователя
+	MembershipId int64  `json:"-"`
+	Source       string `json:"source,omitempty"                  example:"manual"`
+	Actor        string `json:"actor,omitempty"                   example:"support@avito.ru"`
+}
+
+type ReportJobRequest struct {
+	Type  string `json:"type"          binding:"required"  example:"file"`
+	Month int    `json:"month"         binding:"required"  example:"8"`
+	Year  int    `json:"year"          binding:"required"  example:"2023"`
+}
+
+type ReportJobCreateResponse struct {
+	Id int64 `json:"id"                                      example:"1"`
+}
+
+type ReportJob struct {
+	Id         int64      `json:"id"                          example:"1"`
+	Type       string     `json:"type"                        example:"file"`
+	Month      int        `json:"month"                       example:"8"`
+	Year       int        `json:"year"                        example:"2023"`
+	Status     string     `json:"status"                      example:"running"`
+	Progress   float32    `json:"progress"                    example:"0.45"`
+	Attempts   int        `json:"-"`
+	Link       string     `json:"-"`
+	Location   string     `json:"location,omitempty"          example:"/api/v1/report/jobs/1/file"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
@@ -1,6 +1,164 @@
 package entity
 
+import (
+	"encoding/json"
+	"time"
+)
+
+type Segment struct {
+	Id          int
+	Name        string
+	Percent     float32
+	Rule        string
+	Variants    []Variant
+	LayerId     int
+	Payload     json.RawMessage
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	Description string
+	OwnerTeam   string
+	Tags        []string
+	TicketUrl   string
+	CreatedBy   string
+}
+
+type Variant struct {
+	Name   string `json:"name"          binding:"required"  example:"control"`
+	Weight int    `json:"weight"        binding:"required"  example:"50"`
+}
+
 type SegmentRequest struct {
-	Segment string  `json:"segment"       binding:"required"  example:"AVITO_VOICE_MESSAGES"`
-	Percent float32 `json:"percent"       example:"0.5"`
+	Segment  string          `json:"segment"       binding:"required"  example:"AVITO_VOICE_MESSAGES"`
+	Percent  float32         `json:"percent"       example:"0.5"`
+	Rule     string          `json:"rule"          example:"city in ('Moscow','Kazan') AND platform == 'ios'"`
+	Variants []Variant       `json:"variants"`
+	Layer    string          `json:"layer"         example:"CHECKOUT_EXPERIMENTS"`
+	Payload  json.RawMessage `json:"payload"       swaggertype:"object"`
+	StartsAt *time.Time      `json:"starts_at"     example:"2023-11-01T00:00:00+03:00"`
+	EndsAt   *time.Time      `json:"ends_at"       example:"2023-12-01T00:00:00+03:00"`
+
+	Description string   `json:"description"   example:"Голосовые сообщения в чатах"`
+	OwnerTeam   string   `json:"owner_team"    example:"messenger"`
+	Tags        []string `json:"tags"          example:"voice,messenger"`
+	TicketUrl   string   `json:"ticket_url"    example:"https://jira.avito.ru/browse/MSG-1234"`
+	Actor       string   `json:"-"`
+}
+
+// SegmentUpdateRequest запрос изменения сегмента, не указанные поля не меняются
+type SegmentUpdateRequest struct {
+	Segment string          `json:"segment"       binding:"required"  example:"AVITO_VOICE_MESSAGES"`
+	Percent *float32        `json:"percent"       example:"0.3"`
+	Rule    *string         `json:"rule"          example:"registered_before 2023-01-01"`
+	Payload json.RawMessage `json:"payload"       swaggertype:"object"`
+
+	Description *string  `json:"description"   example:"Голосовые сообщения в чатах"`
+	OwnerTeam   *string  `json:"owner_team"    example:"messenger"`
+	Tags        []string `json:"tags"          example:"voice,messenger"`
+	TicketUrl   *string  `json:"ticket_url"    example:"https://jira.avito.ru/browse/MSG-1234"`
+	Actor       string   `json:"-"`
+}
+
+// SegmentRestoreRequest запрос восстановления удалённого сегмента,
+// Reenroll - вернуть в сегмент пользователей, исключённых при его удалении
+type SegmentRestoreRequest struct {
+	Segment  string `json:"segment"       binding:"required"  example:"AVITO_VOICE_MESSAGES"`
+	Reenroll bool   `json:"reenroll"      example:"true"`
+	Actor    string `json:"-"`
+}
+
+type SegmentRestoreResponse struct {
+	Segment    string `json:"segment"                           example:"AVITO_VOICE_MESSAGES"`
+	Reenrolled int    `json:"reenrolled"                        example:"1500"`
+}
+
+// SegmentRenameRequest запрос переименования сегмента, AliasUntil - до какого момента прежнее название
+// продолжает находить сегмент (по умолчанию 90 дней)
+type SegmentRenameRequest struct {
+	Segment    string     `json:"segment"       binding:"required"  example:"AVITO_VOICE_MESSAGES"`
+	NewName    string     `json:"new_name"      binding:"required"  example:"VOICE_MESSAGES_V2"`
+	AliasUntil *time.Time `json:"alias_until"   example:"2024-01-01T00:00:00+03:00"`
+	Actor      string     `json:"-"`
+}
+
+// SegmentAlias прежнее название сегмента, действующее до AliasUntil
+type SegmentAlias struct {
+	Alias      string    `json:"alias"                             example:"AVITO_VOICE_MESSAGES"`
+	Segment    string    `json:"segment"                           example:"VOICE_MESSAGES_V2"`
+	AliasUntil time.Time `json:"alias_until"`
+}
+
+// Статусы сегмента в каталоге
+const (
+	SegmentStatusActive    = "active"
+	SegmentStatusScheduled = "scheduled"
+	SegmentStatusEnded     = "ended"
+	SegmentStatusDeleted   = "deleted"
+)
+
+// SegmentListRequest запрос каталога сегментов: [опционально] префикс названия, теги (сегмент должен иметь все),
+// статус, Cursor - значение next_cursor предыдущей страницы
+type SegmentListRequest struct {
+	Prefix string   `form:"prefix"                                                example:"AVITO_"`
+	Tags   []string `form:"tag"                                                   example:"voice"`
+	Status string   `form:"status"                                                example:"active"`
+	Cursor string   `form:"cursor"`
+	Limit  int      `form:"limit"                                                 example:"100"`
+}
+
+// SegmentInfo сегмент в каталоге, Members - количество пользователей, явно состоящих в сегменте сейчас
+// (пользователи сегментов по правилам таргетинга не учитываются)
+type SegmentInfo struct {
+	Name        string     `json:"name"                              example:"AVITO_VOICE_MESSAGES"`
+	Description string     `json:"description,omitempty"             example:"Голосовые сообщения в чатах"`
+	OwnerTeam   string     `json:"owner_team,omitempty"              example:"messenger"`
+	Tags        []string   `json:"tags"                              example:"voice,messenger"`
+	TicketUrl   string     `json:"ticket_url,omitempty"              example:"https://jira.avito.ru/browse/MSG-1234"`
+	Rule        string     `json:"rule,omitempty"                    example:"platform == 'ios'"`
+	Layer       string     `json:"layer,omitempty"                   example:"CHECKOUT_EXPERIMENTS"`
+	Percent     float32    `json:"percent"                           example:"0.5"`
+	Status      string     `json:"status"                            example:"active"`
+	Members     int        `json:"members"                           example:"1500"`
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CreatedBy   string     `json:"created_by,omitempty"              example:"support@avito.ru"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	UpdatedBy   string     `json:"updated_by,omitempty"              example:"support@avito.ru"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+type SegmentListResponse struct {
+	Segments   []SegmentInfo `json:"segments"`
+	NextCursor string        `json:"next_cursor,omitempty"             example:"AVITO_VOICE_MESSAGES"`
+}
+
+const (
+	SegmentMembershipTtl       = "ttl"
+	SegmentMembershipPermanent = "permanent"
+)
+
+// SegmentMembersRequest запрос пользователей сегмента, AsOf - момент времени (по умолчанию текущий),
+// Membership - необязательный фильтр по типу членства (ttl или permanent),
+// Cursor - значение next_cursor предыдущей страницы
+type SegmentMembersRequest struct {
+	Segment    string     `form:"-"`
+	AsOf       *time.Time `form:"as_of"      time_format:"2006-01-02T15:04:05Z07:00"`
+	Membership string     `form:"membership"                                            example:"ttl"`
+	Cursor     string     `form:"cursor"`
+	Limit      int        `form:"limit"                                                 example:"100"`
+}
+
+type SegmentMember struct {
+	UserId  int        `json:"user_id"                           example:"1000"`
+	Variant string     `json:"variant,omitempty"                 example:"control"`
+	AddedAt time.Time  `json:"added_at"`
+	LeftAt  *time.Time `json:"left_at,omitempty"`
+}
+
+type SegmentMembersResponse struct {
+	Segment    string          `json:"segment"                           example:"AVITO_VOICE_MESSAGES"`
+	AsOf       time.Time       `json:"as_of"`
+	Total      int             `json:"total"                             example:"1500"`
+	Users      []SegmentMember `json:"users"`
+	NextCursor string          `json:"next_cursor,omitempty"             example:"1000"`
 }
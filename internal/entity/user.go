@@ -1,16 +1,142 @@
 package entity
 
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+const (
+	ImportFormatCSV   = "csv"
+	ImportFormatJSONL = "jsonl"
+)
+
+// Причины отклонения строк при импорте членства
+const (
+	ImportReasonMalformed        = "malformed row"
+	ImportReasonWrongUserId      = "user_id must be a positive integer"
+	ImportReasonNoSegment        = "segment is required"
+	ImportReasonWrongTtl         = "ttl must be a non-negative integer"
+	ImportReasonUnknownSegment   = "segment does not exist or is not active"
+	ImportReasonDuplicate        = "duplicate row"
+	ImportReasonAlreadyInSegment = "user is already in segment"
+	ImportReasonLayerConflict    = "user already belongs to another segment of the same layer"
+)
+
 type UserAddToSegmentRequest struct {
-	UserId   int      `json:"user_id"       binding:"required"  example:"1000"`
-	Segments []string `json:"segments" binding:"required"  example:"AVITO_VOICE_MESSAGES,AVITO_PERFORMANCE_VAS"`
-	Ttl      int      `json:"ttl"                          example:"2"`
+	UserId   int        `json:"user_id"       binding:"required"  example:"1000"`
+	Segments []string   `json:"segments" binding:"required"  example:"AVITO_VOICE_MESSAGES,AVITO_PERFORMANCE_VAS"`
+	Ttl      int        `json:"ttl"                          example:"2"`
+	StartAt  *time.Time `json:"start_at"                     example:"2026-11-01T00:00:00+03:00"`
+	EndAt    *time.Time `json:"end_at"                       example:"2026-11-15T00:00:00+03:00"`
+	Actor    string     `json:"-"`
 }
 
 type UserRemoveFromSegmentRequest struct {
 	UserId   int      `json:"user_id"       binding:"required"  example:"1000"`
 	Segments []string `json:"segments" binding:"required"  example:"AVITO_VOICE_MESSAGES,AVITO_PERFORMANCE_VAS"`
+	Actor    string   `json:"-"`
 }
 
+// UserActiveSegmentRequest запрос сегментов пользователя, при заданном AsOf - на указанный момент времени
 type UserActiveSegmentRequest struct {
 	UserId int
+	AsOf   *time.Time
+}
+
+type UserBatchActiveSegmentRequest struct {
+	UserIds []int `json:"user_ids"      binding:"required"  example:"1000,1001,1002"`
+}
+
+type UserBatchActiveSegmentResponse struct {
+	Users map[int][]UserSegment `json:"users"`
+}
+
+type UserSegment struct {
+	SegmentId int             `json:"-"`
+	Segment   string          `json:"segment"                           example:"AVITO_VOICE_MESSAGES"`
+	Variant   string          `json:"variant,omitempty"                 example:"control"`
+	Payload   json.RawMessage `json:"payload,omitempty"                 swaggertype:"object"`
+}
+
+type UserConfigResponse struct {
+	Segments []UserSegment  `json:"segments"`
+	Payload  map[string]any `json:"payload"`
+}
+
+type UserAttributesRequest struct {
+	UserId     int            `json:"user_id"       binding:"required"  example:"1000"`
+	Attributes map[string]any `json:"attributes"    binding:"required"`
+}
+
+type UserImportRequest struct {
+	File   io.Reader
+	Format string
+	DryRun bool
+}
+
+// UserImportRow строка файла импорта, Line — номер строки в файле
+type UserImportRow struct {
+	Line    int    `json:"-"`
+	UserId  int    `json:"user_id"`
+	Segment string `json:"segment"`
+	Ttl     int    `json:"ttl"`
+}
+
+type UserImportRejectedRow struct {
+	Line    int    `json:"line"                              example:"3"`
+	UserId  int    `json:"user_id,omitempty"                 example:"1000"`
+	Segment string `json:"segment,omitempty"                 example:"AVITO_VOICE_MESSAGES"`
+	Reason  string `json:"reason"                            example:"segment does not exist or is not active"`
+}
+
+type UserImportResponse struct {
+	DryRun       bool                    `json:"dry_run"`
+	Accepted     int                     `json:"accepted"                example:"199998"`
+	Rejected     int                     `json:"rejected"                example:"2"`
+	RejectedRows []UserImportRejectedRow `json:"rejected_rows"`
+}
+
+// UserHistoryRequest запрос истории пользователя, период [from, to) и сегменты - необязательные фильтры,
+// Cursor - значение next_cursor предыдущей страницы
+type UserHistoryRequest struct {
+	UserId   int       `form:"-"`
+	Segments []string  `form:"segment"`
+	From     time.Time `form:"from"       time_format:"2006-01-02T15:04:05Z07:00"`
+	To       time.Time `form:"to"         time_format:"2006-01-02T15:04:05Z07:00"`
+	Cursor   string    `form:"cursor"`
+	Limit    int       `form:"limit"                                                 example:"50"`
+}
+
+type UserHistoryResponse struct {
+	History    []ReportUserHistory `json:"history"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// UserHistoryCursor позиция последней записи страницы истории,
+// записи упорядочены по дате, id членства и операции
+type UserHistoryCursor struct {
+	Date         time.Time
+	MembershipId int64
+	Operation    string
+}
+
+type UserEraseRequest struct {
+	UserId int
+	Actor  string
+}
+
+// UserErasureReceipt квитанция об удалении пользователя: Memberships и Events - количество записей членства
+// и событий, перенесённых на псевдоним, Reports - отчеты в Google Drive, которые содержат исходный id пользователя
+// и должны быть перестроены, ReportJobs - задания на отчеты (готовые и ещё выполняющиеся), файлы которых
+// содержали исходный id и удалены, а сами задания возвращены в очередь, PurgeArchives - задания на удаление истории, в архивах которых
+// исходный id заменён псевдонимом
+type UserErasureReceipt struct {
+	ReceiptId     int64     `json:"receipt_id"                        example:"1"`
+	Memberships   int       `json:"memberships"                       example:"12"`
+	Events        int       `json:"events"                            example:"24"`
+	Reports       []string  `json:"reports_to_regenerate"             example:"report_20230801T000000_20230901T000000.csv"`
+	ReportJobs    []int64   `json:"report_jobs_to_regenerate"         example:"3"`
+	PurgeArchives []int64   `json:"purge_archives_pseudonymized"      example:"2"`
+	ErasedAt      time.Time `json:"erased_at"`
 }
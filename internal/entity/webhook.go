@@ -0,0 +1,31 @@
+package entity
+
+type Webhook struct {
+	Id     int      `json:"id"                                example:"1"`
+	Url    string   `json:"url"                               example:"https://example.com/hooks/segments"`
+	Events []string `json:"events"                            example:"membership.added,membership.removed"`
+	Secret string   `json:"-"`
+}
+
+type WebhookRequest struct {
+	Url    string   `json:"url"           binding:"required"  example:"https://example.com/hooks/segments"`
+	Events []string `json:"events"        binding:"required"  example:"membership.added,membership.removed"`
+	Secret string   `json:"secret"        binding:"required"  example:"3f9a1c..."`
+}
+
+type WebhookDeleteRequest struct {
+	Id int `json:"id"            binding:"required"  example:"1"`
+}
+
+type WebhookCreateResponse struct {
+	Id int `json:"id"            example:"1"`
+}
+
+// WebhookDelivery доставка события подписчику
+type WebhookDelivery struct {
+	Id       int64
+	Url      string
+	Secret   string
+	Attempts int
+	Event    Event
+}
@@ -0,0 +1,194 @@
+package pgdb
+
+import (
+	"avito-internship/internal/entity"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+type EventRepo struct {
+	*postgresdb.Postgres
+}
+
+func NewEventRepo(pg *postgresdb.Postgres) *EventRepo {
+	return &EventRepo{pg}
+}
+
+func (r *EventRepo) GetEvents(ctx context.Context, afterSeq int64, userId int, segment string, limit int) ([]entity.Event, error) {
+	sqlQuery := r.Builder.
+		Select("id", "type", "COALESCE(user_id, 0)", "segment", "COALESCE(variant, '')", "COALESCE(reason, '')", "occurred_at", "seq").
+		From("events").
+		Where(sq.Gt{"seq": afterSeq})
+	if userId != 0 {
+		// События сегментов относятся ко всем пользователям
+		sqlQuery = sqlQuery.Where(sq.Or{sq.Eq{"user_id": userId}, sq.Eq{"user_id": nil}})
+	}
+	if segment != "" {
+		sqlQuery = sqlQuery.Where(sq.Eq{"segment": segment})
+	}
+
+	sql, args, _ := sqlQuery.
+		OrderBy("seq").
+		Limit(uint64(limit)).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []entity.Event
+	for rows.Next() {
+		var event entity.Event
+		err = rows.Scan(&event.Id, &event.Type, &event.UserId, &event.Segment, &event.Variant, &event.Reason, &event.OccurredAt, &event.Seq)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *EventRepo) GetLastEventSeq(ctx context.Context) (int64, error) {
+	sql, args, _ := r.Builder.
+		Select("COALESCE(max(seq), 0)").
+		From("events").
+		ToSql()
+
+	var lastSeq int64
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&lastSeq)
+	if err != nil {
+		return 0, err
+	}
+
+	return lastSeq, nil
+}
+
+// enqueueMembershipEvents записывает в журнал событий события добавления или исключения пользователей
+// по id записей users_segment. Вызывается в транзакции, изменяющей членство.
+// Событие добавления происходит в момент начала членства и до него не публикуется. Исключение из членства,
+// которое ещё не началось, отменяет неопубликованное событие добавления вместо записи события исключения.
+func enqueueMembershipEvents(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx,
+	eventType string, reason string, membershipIds []int64) error {
+	if len(membershipIds) == 0 {
+		return nil
+	}
+
+	occurredAt := "us.left_at"
+	if eventType == entity.EventMembershipAdded {
+		occurredAt = "us.added_at"
+	}
+
+	membershipsQuery := sq.
+		Select().
+		Column("?::varchar", eventType).
+		Column("us.id").
+		Column("us.user_id").
+		Column("s.name").
+		Column("us.variant").
+		Column("?::varchar", nullIfEmpty(reason)).
+		Column(occurredAt).
+		From("users_segment AS us").
+		Join("segments AS s ON s.id = us.segment_id").
+		Where("us.id = ANY(?)", membershipIds).
+		OrderBy("us.id")
+
+	if eventType == entity.EventMembershipRemoved {
+		sql, args, _ := builder.
+			Delete("events").
+			Where(sq.Eq{"dispatched_at": nil}).
+			Where(sq.Expr("membership_id IN (?)", sq.
+				Select("id").
+				From("users_segment").
+				Where("id = ANY(?)", membershipIds).
+				Where("added_at >= left_at"))).
+			ToSql()
+
+		_, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		membershipsQuery = membershipsQuery.Where("us.added_at < us.left_at")
+	}
+
+	sql, args, _ := builder.
+		Insert("events").
+		Columns("type", "membership_id", "user_id", "segment", "variant", "reason", "occurred_at").
+		Select(membershipsQuery).
+		ToSql()
+
+	_, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// cancelMembershipEvents удаляет неопубликованные события отменённых (удалённых до начала) записей членства.
+// Вызывается в транзакции, удаляющей записи users_segment.
+func cancelMembershipEvents(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx, membershipIds []int64) error {
+	if len(membershipIds) == 0 {
+		return nil
+	}
+
+	sql, args, _ := builder.
+		Delete("events").
+		Where("membership_id = ANY(?)", membershipIds).
+		Where(sq.Eq{"dispatched_at": nil}).
+		ToSql()
+
+	_, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// enqueueSegmentEvent записывает в журнал событий событие создания или удаления сегмента.
+// Вызывается в транзакции, изменяющей сегмент.
+func enqueueSegmentEvent(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx,
+	eventType string, segment string) error {
+	sql, args, _ := builder.
+		Insert("events").
+		Columns("type", "segment").
+		Values(eventType, segment).
+		ToSql()
+
+	_, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scanIds считывает id из результата запроса с RETURNING id
+func scanIds(rows pgx.Rows) ([]int64, error) {
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,99 @@
+package pgdb_test
+
+import (
+	"avito-internship/internal/entity"
+	"avito-internship/internal/repository/pgdb"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestGetEvents(t *testing.T) {
+	occurredAt := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+
+	type args struct {
+		ctx      context.Context
+		afterSeq int64
+		userId   int
+		segment  string
+		limit    int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         []entity.Event
+		wantErr      bool
+	}{
+		{
+			name: "OK_all",
+			args: args{ctx: context.Background(), afterSeq: 41, limit: 100},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id", "type", "user_id", "segment", "variant", "reason", "occurred_at", "seq"}).
+					AddRow(int64(42), "segment.created", 0, "AVITO_VOICE_MESSAGES", "", "", occurredAt, int64(42))
+				m.ExpectQuery("SELECT (.+) FROM events WHERE seq > \\$1 ORDER BY seq LIMIT 100").
+					WithArgs(args.afterSeq).
+					WillReturnRows(rows)
+			},
+			wantErr: false,
+			want: []entity.Event{{
+				Id:         42,
+				Type:       entity.EventSegmentCreated,
+				Segment:    "AVITO_VOICE_MESSAGES",
+				OccurredAt: occurredAt,
+				Seq:        42,
+			}},
+		},
+		{
+			name: "OK_filtered",
+			args: args{ctx: context.Background(), afterSeq: 41, userId: 1000, segment: "AVITO_VOICE_MESSAGES", limit: 100},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id", "type", "user_id", "segment", "variant", "reason", "occurred_at", "seq"}).
+					AddRow(int64(45), "membership.removed", 1000, "AVITO_VOICE_MESSAGES", "", "manual", occurredAt, int64(43))
+				m.ExpectQuery("SELECT (.+) FROM events WHERE seq > \\$1 AND \\(user_id = \\$2 OR user_id IS NULL\\) AND segment = \\$3").
+					WithArgs(args.afterSeq, args.userId, args.segment).
+					WillReturnRows(rows)
+			},
+			wantErr: false,
+			want: []entity.Event{{
+				Id:         45,
+				Type:       entity.EventMembershipRemoved,
+				UserId:     1000,
+				Segment:    "AVITO_VOICE_MESSAGES",
+				Reason:     entity.RemovalReasonManual,
+				OccurredAt: occurredAt,
+				Seq:        43,
+			}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			eventRepoMock := pgdb.NewEventRepo(postgresMock)
+			got, err := eventRepoMock.GetEvents(tc.args.ctx, tc.args.afterSeq, tc.args.userId, tc.args.segment, tc.args.limit)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
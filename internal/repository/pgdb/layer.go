@@ -0,0 +1,53 @@
+package pgdb
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+)
+
+type LayerRepo struct {
+	*postgresdb.Postgres
+}
+
+func NewLayerRepo(pg *postgresdb.Postgres) *LayerRepo {
+	return &LayerRepo{pg}
+}
+
+func (r *LayerRepo) CreateLayer(ctx context.Context, layer string) error {
+	sql, args, _ := r.Builder.
+		Insert("layers").
+		Columns("name").
+		Values(layer).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *LayerRepo) GetLayerIdByName(ctx context.Context, layer string) (int, error) {
+	sql, args, _ := r.Builder.
+		Select("id").
+		From("layers").
+		Where("name = ?", layer).
+		ToSql()
+
+	var layerId int
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&layerId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, apperror.ErrNoLayer
+		}
+
+		return 0, err
+	}
+
+	return layerId, nil
+}
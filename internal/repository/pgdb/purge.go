@@ -0,0 +1,584 @@
+package pgdb
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"strconv"
+	"time"
+)
+
+type PurgeRepo struct {
+	*postgresdb.Postgres
+}
+
+func NewPurgeRepo(pg *postgresdb.Postgres) *PurgeRepo {
+	return &PurgeRepo{pg}
+}
+
+func (r *PurgeRepo) CreateSegmentPurgeJob(ctx context.Context, segment string, archive bool) (int64, error) {
+	sql, args, _ := r.Builder.
+		Insert("purge_jobs").
+		Columns("type", "segment_id", "segment", "archive").
+		Select(sq.
+			Select().
+			Column("?", entity.PurgeJobTypeSegment).
+			Column("id").
+			Column("name").
+			Column("?::boolean", archive).
+			From("segments").
+			Where("name = ?", segment).
+			Where(sq.NotEq{"deleted_at": nil})).
+		Suffix("ON CONFLICT DO NOTHING RETURNING id").
+		ToSql()
+
+	var id int64
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	// Задание не создано: сегмент не удалён или для него уже есть незавершённое задание
+	sql, args, _ = r.Builder.
+		Select("j.id").
+		From("purge_jobs AS j").
+		Join("segments AS s ON s.id = j.segment_id").
+		Where("s.name = ?", segment).
+		Where(sq.NotEq{"s.deleted_at": nil}).
+		Where(sq.Eq{"j.status": []string{entity.PurgeJobStatusPending, entity.PurgeJobStatusRunning}}).
+		ToSql()
+
+	err = r.Pool.QueryRow(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, apperror.ErrNoDeletedSegment
+		}
+
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *PurgeRepo) CreateRetentionPurgeJob(ctx context.Context, cutoff time.Time, archive bool) (int64, error) {
+	sql, args, _ := r.Builder.
+		Insert("purge_jobs").
+		Columns("type", "cutoff", "archive").
+		Select(sq.
+			Select().
+			Column("?", entity.PurgeJobTypeRetention).
+			Column("?::timestamptz", cutoff).
+			Column("?::boolean", archive).
+			Where(sq.Expr("NOT EXISTS (?)", sq.
+				Select("1").
+				From("purge_jobs").
+				Where(sq.Eq{
+					"type":   entity.PurgeJobTypeRetention,
+					"status": []string{entity.PurgeJobStatusPending, entity.PurgeJobStatusRunning},
+				})))).
+		Suffix("RETURNING id").
+		ToSql()
+
+	var id int64
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *PurgeRepo) GetPurgeJob(ctx context.Context, id int64) (entity.PurgeJob, error) {
+	sql, args, _ := r.Builder.
+		Select("id", "type", "COALESCE(segment, '')", "cutoff", "archive", "status", "purged", "attempts",
+			"COALESCE(error, '')", "created_at", "finished_at").
+		From("purge_jobs").
+		Where("id = ?", id).
+		ToSql()
+
+	var job entity.PurgeJob
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&job.Id, &job.Type, &job.Segment, &job.Cutoff, &job.Archive,
+		&job.Status, &job.Purged, &job.Attempts, &job.Error, &job.CreatedAt, &job.FinishedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.PurgeJob{}, apperror.ErrNoPurgeJob
+		}
+
+		return entity.PurgeJob{}, err
+	}
+
+	return job, nil
+}
+
+func (r *PurgeRepo) StreamPurgeJobFile(ctx context.Context, id int64, fn func([]byte) error) error {
+	tx, err := r.Pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Select("archive", "status").
+		From("purge_jobs").
+		Where("id = ?", id).
+		ToSql()
+
+	var (
+		archive bool
+		status  string
+	)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&archive, &status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apperror.ErrNoPurgeJob
+		}
+
+		return err
+	}
+
+	if !archive || status != entity.PurgeJobStatusDone {
+		return apperror.ErrPurgeArchiveNotReady
+	}
+
+	sql, args, _ = r.Builder.
+		Select("data").
+		From("purge_jobs_file").
+		Where("job_id = ?", id).
+		OrderBy("part").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return err
+		}
+
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *PurgeRepo) ClaimPurgeJob(ctx context.Context, lease time.Duration) (entity.PurgeJob, error) {
+	sql, args, _ := r.Builder.
+		Update("purge_jobs").
+		Set("status", entity.PurgeJobStatusRunning).
+		Set("locked_until", sq.Expr(fmt.Sprintf("now() + INTERVAL '%d seconds'", int(lease.Seconds())))).
+		Set("attempts", sq.Expr("attempts + 1")).
+		Set("started_at", sq.Expr("COALESCE(started_at, now())")).
+		Where(sq.Expr("id = (?)", sq.
+			Select("id").
+			From("purge_jobs").
+			Where(sq.Or{
+				sq.Eq{"status": entity.PurgeJobStatusPending},
+				sq.And{
+					sq.Eq{"status": entity.PurgeJobStatusRunning},
+					sq.Lt{"locked_until": "now()"},
+				},
+			}).
+			OrderBy("id").
+			Limit(1).
+			Suffix("FOR UPDATE SKIP LOCKED"))).
+		Suffix("RETURNING id, type, COALESCE(segment_id, 0), COALESCE(segment, ''), cutoff, archive, " +
+			"status, purged, attempts, created_at").
+		ToSql()
+
+	var job entity.PurgeJob
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&job.Id, &job.Type, &job.SegmentId, &job.Segment, &job.Cutoff,
+		&job.Archive, &job.Status, &job.Purged, &job.Attempts, &job.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.PurgeJob{}, apperror.ErrNoPurgeJob
+		}
+
+		return entity.PurgeJob{}, err
+	}
+
+	return job, nil
+}
+
+func (r *PurgeRepo) ExtendPurgeJobLease(ctx context.Context, id int64, attempt int, lease time.Duration) error {
+	sql, args, _ := r.Builder.
+		Update("purge_jobs").
+		Set("locked_until", sq.Expr(fmt.Sprintf("now() + INTERVAL '%d seconds'", int(lease.Seconds())))).
+		Where("id = ?", id).
+		Where("status = ?", entity.PurgeJobStatusRunning).
+		Where("attempts = ?", attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrPurgeJobLost
+	}
+
+	return nil
+}
+
+func (r *PurgeRepo) CompletePurgeJob(ctx context.Context, id int64, attempt int) error {
+	sql, args, _ := r.Builder.
+		Update("purge_jobs").
+		Set("status", entity.PurgeJobStatusDone).
+		Set("locked_until", nil).
+		Set("finished_at", "now()").
+		Where("id = ?", id).
+		Where("status = ?", entity.PurgeJobStatusRunning).
+		Where("attempts = ?", attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrPurgeJobLost
+	}
+
+	return nil
+}
+
+func (r *PurgeRepo) FailPurgeJob(ctx context.Context, id int64, attempt int, reason string) error {
+	sql, args, _ := r.Builder.
+		Update("purge_jobs").
+		Set("status", entity.PurgeJobStatusFailed).
+		Set("error", reason).
+		Set("locked_until", nil).
+		Set("finished_at", "now()").
+		Where("id = ?", id).
+		Where("status = ?", entity.PurgeJobStatusRunning).
+		Where("attempts = ?", attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrPurgeJobLost
+	}
+
+	return nil
+}
+
+func (r *PurgeRepo) ReleasePurgeJob(ctx context.Context, id int64, attempt int) error {
+	sql, args, _ := r.Builder.
+		Update("purge_jobs").
+		Set("status", entity.PurgeJobStatusPending).
+		Set("locked_until", nil).
+		Where("id = ?", id).
+		Where("status = ?", entity.PurgeJobStatusRunning).
+		Where("attempts = ?", attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrPurgeJobLost
+	}
+
+	return nil
+}
+
+func (r *PurgeRepo) PurgeSegmentHistory(ctx context.Context, jobId int64, attempt int, segmentId int, limit int,
+	archive func([]entity.ReportUserHistory) ([]byte, error)) (int64, error) {
+	return r.purgeHistory(ctx, jobId, attempt, segmentId, sq.
+		Select("id").
+		From("users_segment").
+		Where("segment_id = ?", segmentId).
+		Limit(uint64(limit)), archive)
+}
+
+func (r *PurgeRepo) PurgeClosedHistory(ctx context.Context, jobId int64, attempt int, cutoff time.Time, limit int,
+	archive func([]entity.ReportUserHistory) ([]byte, error)) (int64, error) {
+	return r.purgeHistory(ctx, jobId, attempt, 0, sq.
+		Select("id").
+		From("users_segment").
+		Where(sq.Lt{"left_at": cutoff}).
+		Where(sq.NotEq{"finalized_at": nil}).
+		Limit(uint64(limit)), archive)
+}
+
+// purgeHistory удаляет записи членства, отобранные запросом batch. Если задан archive, операции удалённых записей
+// передаются в archive и результат сохраняется очередной частью архива задания в той же транзакции, поэтому
+// прерванное задание не теряет и не дублирует строки архива. Если задан segmentId, пачка удаляется только
+// пока сегмент удалён. Возвращает количество удалённых записей.
+func (r *PurgeRepo) purgeHistory(ctx context.Context, jobId int64, attempt int, segmentId int, batch sq.SelectBuilder,
+	archive func([]entity.ReportUserHistory) ([]byte, error)) (int64, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("PurgeRepo.purgeHistory - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Блокировка задания не даёт перехватить его, пока удаляется пачка, а пачки задания удаляются по одной:
+	// номера частей архива и количество удалённых записей не расходятся
+	sql, args, _ := r.Builder.
+		Select("1").
+		From("purge_jobs").
+		Where("id = ?", jobId).
+		Where("status = ?", entity.PurgeJobStatusRunning).
+		Where("attempts = ?", attempt).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	var owned int
+	err = tx.QueryRow(ctx, sql, args...).Scan(&owned)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, apperror.ErrPurgeJobLost
+		}
+
+		return 0, fmt.Errorf("PurgeRepo.purgeHistory - SELECT purge_jobs: %w", err)
+	}
+
+	if segmentId != 0 {
+		// Блокировка сегмента до конца транзакции не даёт восстановить его, пока удаляется пачка,
+		// а восстановление при незавершённом задании отклоняется (см. SegmentRepo.RestoreSegment).
+		// Сегмент, восстановленный до постановки задания в очередь, не удаляется.
+		sql, args, _ := r.Builder.
+			Select("1").
+			From("segments").
+			Where("id = ?", segmentId).
+			Where(sq.NotEq{"deleted_at": nil}).
+			Suffix("FOR SHARE").
+			ToSql()
+
+		var deleted int
+		err = tx.QueryRow(ctx, sql, args...).Scan(&deleted)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, nil
+			}
+
+			return 0, fmt.Errorf("PurgeRepo.purgeHistory - SELECT segments: %w", err)
+		}
+	}
+
+	purged, err := r.deleteBatch(ctx, tx, jobId, sq.Delete("users_segment").Where(sq.Expr("id IN (?)", batch)), archive)
+	if err != nil {
+		return 0, err
+	}
+
+	if purged > 0 {
+		sql, args, _ := r.Builder.
+			Update("purge_jobs").
+			Set("purged", sq.Expr("purged + ?", purged)).
+			Where("id = ?", jobId).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return 0, fmt.Errorf("PurgeRepo.purgeHistory - UPDATE purge_jobs: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("PurgeRepo.purgeHistory - tx.Commit: %w", err)
+	}
+
+	return purged, nil
+}
+
+// deleteBatch удаляет записи членства запросом purge и, если задан archive, сохраняет операции удалённых записей
+// очередной частью архива задания, возвращает количество удалённых записей
+func (r *PurgeRepo) deleteBatch(ctx context.Context, tx pgx.Tx, jobId int64, purge sq.DeleteBuilder,
+	archive func([]entity.ReportUserHistory) ([]byte, error)) (int64, error) {
+	if archive == nil {
+		sql, args, _ := purge.PlaceholderFormat(sq.Dollar).ToSql()
+
+		tag, err := tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return 0, fmt.Errorf("PurgeRepo.deleteBatch - DELETE: %w", err)
+		}
+
+		return tag.RowsAffected(), nil
+	}
+
+	history, purged, err := r.deleteHistory(ctx, tx, purge)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(history) > 0 {
+		data, err := archive(history)
+		if err != nil {
+			return 0, err
+		}
+
+		sql, args, _ := r.Builder.
+			Insert("purge_jobs_file").
+			Columns("job_id", "part", "data").
+			Select(sq.
+				Select().
+				Column("?::bigint", jobId).
+				Column("COALESCE(max(part) + 1, 0)").
+				Column("?::bytea", data).
+				From("purge_jobs_file").
+				Where("job_id = ?", jobId)).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return 0, fmt.Errorf("PurgeRepo.deleteBatch - INSERT purge_jobs_file: %w", err)
+		}
+	}
+
+	return purged, nil
+}
+
+// deleteHistory удаляет записи членства запросом purge и возвращает их операции в формате отчета
+// (добавление и, для завершённого членства, исключение) и количество удалённых записей
+func (r *PurgeRepo) deleteHistory(ctx context.Context, tx pgx.Tx, purge sq.DeleteBuilder) ([]entity.ReportUserHistory, int64, error) {
+	sql, args, _ := r.Builder.
+		Select("p.user_id", "s.name", "COALESCE(p.variant, '')", "o.operation", "o.reason", "o.date",
+			segmentNameAt("o.date")).
+		PrefixExpr(sq.Expr("WITH purged AS (?)",
+			purge.Suffix("RETURNING user_id, segment_id, variant, removal_reason, added_at, left_at"))).
+		From("purged AS p").
+		Join("segments AS s ON s.id = p.segment_id").
+		CrossJoin(fmt.Sprintf("LATERAL (VALUES ('%s', '', p.added_at), ('%s', COALESCE(p.removal_reason, ''), p.left_at)) "+
+			"AS o (operation, reason, date)", entity.ReportOperationAdd, entity.ReportOperationRemove)).
+		Where(sq.NotEq{"o.date": nil}).
+		OrderBy("o.date", "p.user_id").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("PurgeRepo.deleteHistory - DELETE: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		history []entity.ReportUserHistory
+		purged  int64
+	)
+	for rows.Next() {
+		var (
+			userId int
+			record entity.ReportUserHistory
+		)
+		err = rows.Scan(&userId, &record.Segment, &record.Variant, &record.Operation, &record.Reason, &record.Date,
+			&record.SegmentAtEvent)
+		if err != nil {
+			return nil, 0, fmt.Errorf("PurgeRepo.deleteHistory - rows.Scan: %w", err)
+		}
+		record.UserId = strconv.Itoa(userId)
+		history = append(history, record)
+
+		// Каждая удалённая запись даёт ровно одну операцию добавления
+		if record.Operation == entity.ReportOperationAdd {
+			purged++
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("PurgeRepo.deleteHistory - rows.Err: %w", err)
+	}
+
+	return history, purged, nil
+}
+
+func (r *PurgeRepo) GetSegmentsDeletedBefore(ctx context.Context, cutoff time.Time) ([]int, error) {
+	sql, args, _ := r.Builder.
+		Select("id").
+		From("segments").
+		Where(sq.Lt{"deleted_at": cutoff}).
+		OrderBy("id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (r *PurgeRepo) PurgeSegment(ctx context.Context, segmentId int) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("PurgeRepo.PurgeSegment - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	for _, table := range []string{"segments_variant", "segments_alias"} {
+		sql, args, _ := r.Builder.
+			Delete(table).
+			Where(sq.Expr("segment_id = (?)", sq.
+				Select("id").
+				From("segments").
+				Where("id = ?", segmentId).
+				Where(sq.NotEq{"deleted_at": nil}))).
+			ToSql()
+
+		_, err = tx.Exec(ctx, sql, args...)
+		if err != nil {
+			return fmt.Errorf("PurgeRepo.PurgeSegment - DELETE %s: %w", table, err)
+		}
+	}
+
+	sql, args, _ := r.Builder.
+		Delete("segments").
+		Where("id = ?", segmentId).
+		Where(sq.NotEq{"deleted_at": nil}).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("PurgeRepo.PurgeSegment - DELETE segments: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("PurgeRepo.PurgeSegment - tx.Commit: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,562 @@
+package pgdb_test
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/repository/pgdb"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCreateSegmentPurgeJob(t *testing.T) {
+	type args struct {
+		ctx     context.Context
+		segment string
+		archive bool
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int64
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), segment: "Test_Segment", archive: true},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(int64(1))
+				m.ExpectQuery("INSERT INTO purge_jobs \\(type,segment_id,segment,archive\\) "+
+					"SELECT \\$1, id, name, \\$2::boolean FROM segments WHERE name = \\$3 AND deleted_at IS NOT NULL "+
+					"ON CONFLICT DO NOTHING RETURNING id").
+					WithArgs("segment", args.archive, args.segment).
+					WillReturnRows(rows)
+			},
+			want: 1,
+		},
+		{
+			name: "Not_deleted",
+			args: args{ctx: context.Background(), segment: "Test_Segment"},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("INSERT INTO purge_jobs").
+					WithArgs("segment", args.archive, args.segment).
+					WillReturnError(pgx.ErrNoRows)
+				m.ExpectQuery("SELECT j.id FROM purge_jobs AS j").
+					WithArgs(args.segment, "pending", "running").
+					WillReturnError(pgx.ErrNoRows)
+			},
+			wantErr: apperror.ErrNoDeletedSegment,
+		},
+		{
+			name: "Already_queued",
+			args: args{ctx: context.Background(), segment: "Test_Segment"},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("INSERT INTO purge_jobs").
+					WithArgs("segment", args.archive, args.segment).
+					WillReturnError(pgx.ErrNoRows)
+				m.ExpectQuery("SELECT j.id FROM purge_jobs AS j JOIN segments AS s ON s.id = j.segment_id "+
+					"WHERE s.name = \\$1 AND s.deleted_at IS NOT NULL AND j.status IN \\(\\$2,\\$3\\)").
+					WithArgs(args.segment, "pending", "running").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(3)))
+			},
+			want: 3,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			purgeRepoMock := pgdb.NewPurgeRepo(postgresMock)
+			got, err := purgeRepoMock.CreateSegmentPurgeJob(tc.args.ctx, tc.args.segment, tc.args.archive)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateRetentionPurgeJob(t *testing.T) {
+	cutoff := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	type args struct {
+		ctx     context.Context
+		cutoff  time.Time
+		archive bool
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int64
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), cutoff: cutoff, archive: true},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(int64(2))
+				m.ExpectQuery("INSERT INTO purge_jobs \\(type,cutoff,archive\\) "+
+					"SELECT \\$1, \\$2::timestamptz, \\$3::boolean "+
+					"WHERE NOT EXISTS \\(SELECT 1 FROM purge_jobs WHERE (.+)\\) RETURNING id").
+					WithArgs("retention", args.cutoff, args.archive, "pending", "running", "retention").
+					WillReturnRows(rows)
+			},
+			want: 2,
+		},
+		{
+			name: "Already_running",
+			args: args{ctx: context.Background(), cutoff: cutoff},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("INSERT INTO purge_jobs").
+					WithArgs("retention", args.cutoff, args.archive, "pending", "running", "retention").
+					WillReturnError(pgx.ErrNoRows)
+			},
+			want: 0,
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(), cutoff: cutoff},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("INSERT INTO purge_jobs").
+					WithArgs("retention", args.cutoff, args.archive, "pending", "running", "retention").
+					WillReturnError(pgx.ErrTxClosed)
+			},
+			wantErr: pgx.ErrTxClosed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			purgeRepoMock := pgdb.NewPurgeRepo(postgresMock)
+			got, err := purgeRepoMock.CreateRetentionPurgeJob(tc.args.ctx, tc.args.cutoff, tc.args.archive)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestStreamPurgeJobFile(t *testing.T) {
+	type args struct {
+		ctx context.Context
+		id  int64
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         []byte
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), id: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectQuery("SELECT archive, status FROM purge_jobs WHERE id = \\$1").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"archive", "status"}).AddRow(true, "done"))
+				m.ExpectQuery("SELECT data FROM purge_jobs_file WHERE job_id = \\$1 ORDER BY part").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"data"}).AddRow([]byte("1000,Test_Segment\n")))
+				m.ExpectCommit()
+				m.ExpectRollback()
+			},
+			want: []byte("1000,Test_Segment\n"),
+		},
+		{
+			name: "Not_ready",
+			args: args{ctx: context.Background(), id: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectQuery("SELECT archive, status FROM purge_jobs").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"archive", "status"}).AddRow(true, "running"))
+				m.ExpectRollback()
+			},
+			wantErr: apperror.ErrPurgeArchiveNotReady,
+		},
+		{
+			name: "No_job",
+			args: args{ctx: context.Background(), id: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectQuery("SELECT archive, status FROM purge_jobs").
+					WithArgs(args.id).
+					WillReturnError(pgx.ErrNoRows)
+				m.ExpectRollback()
+			},
+			wantErr: apperror.ErrNoPurgeJob,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			purgeRepoMock := pgdb.NewPurgeRepo(postgresMock)
+			var got []byte
+			err := purgeRepoMock.StreamPurgeJobFile(tc.args.ctx, tc.args.id, func(data []byte) error {
+				got = append(got, data...)
+
+				return nil
+			})
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPurgeSegmentHistory(t *testing.T) {
+	date := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	type args struct {
+		ctx       context.Context
+		jobId     int64
+		attempt   int
+		segmentId int
+		limit     int
+		archive   func([]entity.ReportUserHistory) ([]byte, error)
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int64
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), jobId: 1, attempt: 2, segmentId: 1, limit: 1000},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("SELECT 1 FROM purge_jobs WHERE id = \\$1 AND status = \\$2 AND attempts = \\$3 FOR UPDATE").
+					WithArgs(args.jobId, "running", args.attempt).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectQuery("SELECT 1 FROM segments WHERE id = \\$1 AND deleted_at IS NOT NULL FOR SHARE").
+					WithArgs(args.segmentId).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectExec("DELETE FROM users_segment WHERE id IN \\(SELECT id FROM users_segment " +
+					"WHERE segment_id = \\$1 LIMIT 1000\\)").
+					WithArgs(args.segmentId).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1000))
+				m.ExpectExec("UPDATE purge_jobs SET purged = purged \\+ \\$1 WHERE id = \\$2").
+					WithArgs(int64(1000), args.jobId).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				m.ExpectCommit()
+				m.ExpectRollback()
+			},
+			want: 1000,
+		},
+		{
+			name: "OK_archive",
+			args: args{ctx: context.Background(), jobId: 1, attempt: 2, segmentId: 1, limit: 1000,
+				archive: func(history []entity.ReportUserHistory) ([]byte, error) {
+					return []byte(fmt.Sprintf("%s,%s,%s\n", history[0].UserId, history[0].Operation, history[1].Operation)), nil
+				}},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("SELECT 1 FROM purge_jobs").
+					WithArgs(args.jobId, "running", args.attempt).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectQuery("SELECT 1 FROM segments").
+					WithArgs(args.segmentId).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				rows := pgxmock.NewRows([]string{"user_id", "name", "variant", "operation", "reason", "date", "segment_at_event"}).
+					AddRow(1000, "Test_Segment", "", "add", "", date, "Test_Segment").
+					AddRow(1000, "Test_Segment", "", "remove", "segment_deleted", date.AddDate(0, 1, 0), "Test_Segment")
+				m.ExpectQuery("WITH purged AS \\(DELETE FROM users_segment WHERE id IN \\(SELECT id FROM users_segment " +
+					"WHERE segment_id = \\$1 LIMIT 1000\\) " +
+					"RETURNING user_id, segment_id, variant, removal_reason, added_at, left_at\\) " +
+					"SELECT p.user_id, s.name, (.+) FROM purged AS p JOIN segments AS s ON s.id = p.segment_id " +
+					"CROSS JOIN LATERAL \\(VALUES (.+)\\) AS o \\(operation, reason, date\\) " +
+					"WHERE o.date IS NOT NULL ORDER BY o.date, p.user_id").
+					WithArgs(args.segmentId).
+					WillReturnRows(rows)
+				m.ExpectExec("INSERT INTO purge_jobs_file \\(job_id,part,data\\) "+
+					"SELECT \\$1::bigint, COALESCE\\(max\\(part\\) \\+ 1, 0\\), \\$2::bytea FROM purge_jobs_file WHERE job_id = \\$3").
+					WithArgs(args.jobId, []byte("1000,add,remove\n"), args.jobId).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				m.ExpectExec("UPDATE purge_jobs").
+					WithArgs(int64(1), args.jobId).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				m.ExpectCommit()
+				m.ExpectRollback()
+			},
+			want: 1,
+		},
+		{
+			name: "OK_archive_empty",
+			args: args{ctx: context.Background(), jobId: 1, attempt: 2, segmentId: 1, limit: 1000,
+				archive: func(history []entity.ReportUserHistory) ([]byte, error) {
+					return nil, errors.New("unexpected archive")
+				}},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("SELECT 1 FROM purge_jobs").
+					WithArgs(args.jobId, "running", args.attempt).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectQuery("SELECT 1 FROM segments").
+					WithArgs(args.segmentId).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectQuery("WITH purged AS").
+					WithArgs(args.segmentId).
+					WillReturnRows(pgxmock.NewRows([]string{"user_id", "name", "variant", "operation", "reason", "date", "segment_at_event"}))
+				m.ExpectCommit()
+				m.ExpectRollback()
+			},
+			want: 0,
+		},
+		{
+			name: "Job_lost",
+			args: args{ctx: context.Background(), jobId: 1, attempt: 2, segmentId: 1, limit: 1000},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("SELECT 1 FROM purge_jobs").
+					WithArgs(args.jobId, "running", args.attempt).
+					WillReturnError(pgx.ErrNoRows)
+				m.ExpectRollback()
+			},
+			wantErr: apperror.ErrPurgeJobLost,
+		},
+		{
+			name: "Segment_restored",
+			args: args{ctx: context.Background(), jobId: 1, attempt: 2, segmentId: 1, limit: 1000},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("SELECT 1 FROM purge_jobs").
+					WithArgs(args.jobId, "running", args.attempt).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectQuery("SELECT 1 FROM segments").
+					WithArgs(args.segmentId).
+					WillReturnError(pgx.ErrNoRows)
+				m.ExpectRollback()
+			},
+			want: 0,
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(), jobId: 1, attempt: 2, segmentId: 1, limit: 1000},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("SELECT 1 FROM purge_jobs").
+					WithArgs(args.jobId, "running", args.attempt).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectQuery("SELECT 1 FROM segments").
+					WithArgs(args.segmentId).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectExec("DELETE FROM users_segment").
+					WithArgs(args.segmentId).
+					WillReturnError(pgx.ErrTxClosed)
+				m.ExpectRollback()
+			},
+			wantErr: pgx.ErrTxClosed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			purgeRepoMock := pgdb.NewPurgeRepo(postgresMock)
+			got, err := purgeRepoMock.PurgeSegmentHistory(tc.args.ctx, tc.args.jobId, tc.args.attempt, tc.args.segmentId, tc.args.limit,
+				tc.args.archive)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPurgeClosedHistory(t *testing.T) {
+	cutoff := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	type args struct {
+		ctx     context.Context
+		jobId   int64
+		attempt int
+		cutoff  time.Time
+		limit   int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int64
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), jobId: 2, attempt: 1, cutoff: cutoff, limit: 1000},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("SELECT 1 FROM purge_jobs").
+					WithArgs(args.jobId, "running", args.attempt).
+					WillReturnRows(pgxmock.NewRows([]string{"?column?"}).AddRow(1))
+				m.ExpectExec("DELETE FROM users_segment WHERE id IN \\(SELECT id FROM users_segment " +
+					"WHERE left_at < \\$1 AND finalized_at IS NOT NULL LIMIT 1000\\)").
+					WithArgs(args.cutoff).
+					WillReturnResult(pgxmock.NewResult("DELETE", 15))
+				m.ExpectExec("UPDATE purge_jobs").
+					WithArgs(int64(15), args.jobId).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				m.ExpectCommit()
+				m.ExpectRollback()
+			},
+			want: 15,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			purgeRepoMock := pgdb.NewPurgeRepo(postgresMock)
+			got, err := purgeRepoMock.PurgeClosedHistory(tc.args.ctx, tc.args.jobId, tc.args.attempt, tc.args.cutoff, tc.args.limit, nil)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPurgeSegment(t *testing.T) {
+	type args struct {
+		ctx       context.Context
+		segmentId int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      bool
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), segmentId: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectExec("DELETE FROM segments_variant WHERE segment_id = \\(SELECT id FROM segments " +
+					"WHERE id = \\$1 AND deleted_at IS NOT NULL\\)").
+					WithArgs(args.segmentId).
+					WillReturnResult(pgxmock.NewResult("DELETE", 2))
+
+				m.ExpectExec("DELETE FROM segments_alias WHERE segment_id = \\(SELECT id FROM segments " +
+					"WHERE id = \\$1 AND deleted_at IS NOT NULL\\)").
+					WithArgs(args.segmentId).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+				m.ExpectExec("DELETE FROM segments WHERE id = \\$1 AND deleted_at IS NOT NULL").
+					WithArgs(args.segmentId).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "Membership_left",
+			args: args{ctx: context.Background(), segmentId: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectExec("DELETE FROM segments_variant").
+					WithArgs(args.segmentId).
+					WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+				m.ExpectExec("DELETE FROM segments_alias").
+					WithArgs(args.segmentId).
+					WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+				m.ExpectExec("DELETE FROM segments").
+					WithArgs(args.segmentId).
+					WillReturnError(pgx.ErrTxClosed)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			purgeRepoMock := pgdb.NewPurgeRepo(postgresMock)
+			err := purgeRepoMock.PurgeSegment(tc.args.ctx, tc.args.segmentId)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -2,17 +2,17 @@ package pgdb
 
 import (
 	"avito-internship/internal/entity"
-	"avito-internship/internal/utils"
 	"avito-internship/pkg/database/postgresdb"
 	"context"
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"strconv"
+	"strings"
 )
 
-const (
-	operationAdd    = "add"
-	operationRemove = "remove"
-)
+// reportFetchSize количество строк, выбираемых из серверного курсора за один запрос
+const reportFetchSize = 1000
 
 type ReportRepo struct {
 	*postgresdb.Postgres
@@ -22,71 +22,134 @@ func NewReportRepo(pg *postgresdb.Postgres) *ReportRepo {
 	return &ReportRepo{pg}
 }
 
-func (r *ReportRepo) GetSegmentHistoryFromUser(ctx context.Context, month int, year int) ([]entity.ReportUserHistory, error) {
-	sql, args, _ := r.Builder.
-		Select("us.user_id", "us.added_at", "s.name", "us.left_at").
-		From("users_segment AS us").
-		Join("segments AS s ON s.id = us.segment_id").
-		Where(sq.Eq{"extract(month from us.added_at)": month}).
-		Where(sq.Eq{"extract(year from us.added_at)": year}).
-		ToSql()
+func (r *ReportRepo) StreamSegmentHistory(ctx context.Context, req entity.ReportRequest, fn func(entity.ReportUserHistory) error) error {
+	sql, args := r.segmentHistoryQuery(req)
 
-	rows, err := r.Pool.Query(ctx, sql, args...)
+	tx, err := r.Pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("ReportRepo.StreamSegmentHistory - r.Pool.BeginTx: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
+	_, err = tx.Exec(ctx, "DECLARE report_history NO SCROLL CURSOR FOR "+sql+" ORDER BY date, user_id", args...)
+	if err != nil {
+		return fmt.Errorf("ReportRepo.StreamSegmentHistory - DECLARE: %w", err)
 	}
-	defer rows.Close()
 
-	pgColumns := rows.FieldDescriptions()
-	values := make([]interface{}, len(pgColumns))
-	for i := range pgColumns {
-		values[i] = new(interface{})
+	for {
+		fetched, err := r.fetchSegmentHistory(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+		if fetched < reportFetchSize {
+			break
+		}
 	}
 
-	var results []entity.ReportUserHistory
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("ReportRepo.StreamSegmentHistory - tx.Commit: %w", err)
+	}
 
-	for rows.Next() {
-		if err = rows.Scan(values...); err != nil {
-			return nil, err
+	return nil
+}
+
+func (r *ReportRepo) CountSegmentHistory(ctx context.Context, req entity.ReportRequest) (int, error) {
+	sql, args := r.segmentHistoryQuery(req)
+
+	var count int
+	err := r.Pool.QueryRow(ctx, "SELECT count(*) FROM ("+sql+") AS history", args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("ReportRepo.CountSegmentHistory - r.Pool.QueryRow: %w", err)
+	}
+
+	return count, nil
+}
+
+// segmentHistoryQuery возвращает запрос истории операций: добавления пользователей отбираются по added_at,
+// исключения - по left_at, обе части объединяются и фильтруются по сегментам и пользователям.
+// Запланированные добавления и исключения (в том числе по TTL) ещё могут быть отменены,
+// поэтому период ограничивается текущим моментом.
+func (r *ReportRepo) segmentHistoryQuery(req entity.ReportRequest) (string, []interface{}) {
+	builder := r.Builder.PlaceholderFormat(sq.Question)
+	filter := func(b sq.SelectBuilder) sq.SelectBuilder {
+		b = b.From("users_segment AS us").
+			Join("segments AS s ON s.id = us.segment_id")
+		if len(req.Segments) > 0 {
+			b = b.Where("s.name = ANY(?)", req.Segments)
+		}
+		if len(req.UserIds) > 0 {
+			b = b.Where("us.user_id = ANY(?)", req.UserIds)
 		}
 
-		historyAdd := entity.ReportUserHistory{}
-		historyRemove := entity.ReportUserHistory{}
-		removeExist := false
-		for i, col := range pgColumns {
-			val := *values[i].(*interface{})
-
-			if val != nil {
-				switch col.Name {
-				case "added_at":
-					timestamp, err := utils.PgTimestampConverter(val)
-					if err != nil {
-						return nil, err
-					}
-					historyAdd.Date = timestamp
-					historyAdd.Operation = operationAdd
-				case "user_id":
-					historyAdd.UserId = fmt.Sprint(val)
-				case "name":
-					historyAdd.Segment = val.(string)
-				case "left_at":
-					timestamp, err := utils.PgTimestampConverter(val)
-					if err != nil {
-						return nil, err
-					}
-					historyRemove = historyAdd
-					historyRemove.Date = timestamp
-					historyRemove.Operation = operationRemove
-					removeExist = true
-				}
-			}
+		return b
+	}
+
+	var (
+		parts []string
+		args  []interface{}
+	)
+
+	if req.Operation != entity.ReportOperationRemove {
+		addSql, addArgs, _ := filter(builder.
+			Select("us.user_id", "s.name", "COALESCE(us.variant, '')",
+				fmt.Sprintf("'%s' AS operation", entity.ReportOperationAdd), "'' AS reason", "us.added_at AS date",
+				segmentNameAt("us.added_at"))).
+			Where(sq.GtOrEq{"us.added_at": req.From}).
+			Where("us.added_at < least(?::timestamptz, now())", req.To).
+			ToSql()
+		parts = append(parts, addSql)
+		args = append(args, addArgs...)
+	}
+
+	if req.Operation != entity.ReportOperationAdd {
+		removeSql, removeArgs, _ := filter(builder.
+			Select("us.user_id", "s.name", "COALESCE(us.variant, '')",
+				fmt.Sprintf("'%s' AS operation", entity.ReportOperationRemove), "COALESCE(us.removal_reason, '') AS reason", "us.left_at AS date",
+				segmentNameAt("us.left_at"))).
+			Where(sq.GtOrEq{"us.left_at": req.From}).
+			Where("us.left_at < least(?::timestamptz, now())", req.To).
+			ToSql()
+		parts = append(parts, removeSql)
+		args = append(args, removeArgs...)
+	}
+
+	sql, _ := sq.Dollar.ReplacePlaceholders(strings.Join(parts, " UNION ALL "))
+
+	return sql, args
+}
+
+// fetchSegmentHistory выбирает очередную порцию строк из курсора и передаёт их в fn,
+// возвращает количество выбранных строк
+func (r *ReportRepo) fetchSegmentHistory(ctx context.Context, tx pgx.Tx, fn func(entity.ReportUserHistory) error) (int, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM report_history", reportFetchSize))
+	if err != nil {
+		return 0, fmt.Errorf("ReportRepo.fetchSegmentHistory - FETCH: %w", err)
+	}
+	defer rows.Close()
+
+	fetched := 0
+	for rows.Next() {
+		var (
+			userId int
+			record entity.ReportUserHistory
+		)
+		err = rows.Scan(&userId, &record.Segment, &record.Variant, &record.Operation, &record.Reason, &record.Date,
+			&record.SegmentAtEvent)
+		if err != nil {
+			return 0, fmt.Errorf("ReportRepo.fetchSegmentHistory - rows.Scan: %w", err)
 		}
-		results = append(results, historyAdd)
-		if removeExist {
-			results = append(results, historyRemove)
+		record.UserId = strconv.Itoa(userId)
+		fetched++
+
+		if err = fn(record); err != nil {
+			return 0, err
 		}
 	}
 
-	return results, nil
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("ReportRepo.fetchSegmentHistory - rows.Err: %w", err)
+	}
+
+	return fetched, nil
 }
@@ -0,0 +1,300 @@
+package pgdb
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	"errors"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type ReportJobRepo struct {
+	*postgresdb.Postgres
+}
+
+func NewReportJobRepo(pg *postgresdb.Postgres) *ReportJobRepo {
+	return &ReportJobRepo{pg}
+}
+
+func (r *ReportJobRepo) CreateReportJob(ctx context.Context, jobType string, month int, year int) (int64, error) {
+	sql, args, _ := r.Builder.
+		Insert("report_jobs").
+		Columns("type", "month", "year").
+		Values(jobType, month, year).
+		Suffix("RETURNING id").
+		ToSql()
+
+	var id int64
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *ReportJobRepo) GetReportJob(ctx context.Context, id int64) (entity.ReportJob, error) {
+	sql, args, _ := r.Builder.
+		Select("id", "type", "month", "year", "status", "progress", "attempts",
+			"COALESCE(link, '')", "COALESCE(error, '')", "created_at", "finished_at").
+		From("report_jobs").
+		Where("id = ?", id).
+		ToSql()
+
+	var job entity.ReportJob
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&job.Id, &job.Type, &job.Month, &job.Year, &job.Status,
+		&job.Progress, &job.Attempts, &job.Link, &job.Error, &job.CreatedAt, &job.FinishedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.ReportJob{}, apperror.ErrNoReportJob
+		}
+
+		return entity.ReportJob{}, err
+	}
+
+	return job, nil
+}
+
+func (r *ReportJobRepo) StreamReportJobFile(ctx context.Context, id int64, fn func([]byte) error) error {
+	tx, err := r.Pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Select("type", "status").
+		From("report_jobs").
+		Where("id = ?", id).
+		ToSql()
+
+	var jobType, status string
+	err = tx.QueryRow(ctx, sql, args...).Scan(&jobType, &status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apperror.ErrNoReportJob
+		}
+
+		return err
+	}
+
+	if jobType != entity.ReportJobTypeFile || status != entity.ReportJobStatusDone {
+		return apperror.ErrReportNotReady
+	}
+
+	sql, args, _ = r.Builder.
+		Select("data").
+		From("report_jobs_file").
+		Where("job_id = ?", id).
+		OrderBy("part").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		if err = rows.Scan(&data); err != nil {
+			return err
+		}
+
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *ReportJobRepo) SaveReportJobFilePart(ctx context.Context, id int64, attempt int, part int, data []byte) error {
+	// Блокировка задания не даёт перехватить его, пока записывается часть: часть либо записывается
+	// до повторного захвата и удаляется при нём, либо отклоняется
+	sql, args, _ := r.Builder.
+		Insert("report_jobs_file").
+		Columns("job_id", "part", "data").
+		Select(sq.
+			Select("id").
+			Column("?::integer", part).
+			Column("?::bytea", data).
+			From("report_jobs").
+			Where("id = ?", id).
+			Where("status = ?", entity.ReportJobStatusRunning).
+			Where("attempts = ?", attempt).
+			Suffix("FOR SHARE")).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrReportJobLost
+	}
+
+	return nil
+}
+
+func (r *ReportJobRepo) ClaimReportJob(ctx context.Context, lease time.Duration) (entity.ReportJob, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return entity.ReportJob{}, fmt.Errorf("ReportJobRepo.ClaimReportJob - r.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Update("report_jobs").
+		Set("status", entity.ReportJobStatusRunning).
+		Set("locked_until", sq.Expr(fmt.Sprintf("now() + INTERVAL '%d seconds'", int(lease.Seconds())))).
+		Set("attempts", sq.Expr("attempts + 1")).
+		Set("started_at", sq.Expr("COALESCE(started_at, now())")).
+		Where(sq.Expr("id = (?)", sq.
+			Select("id").
+			From("report_jobs").
+			Where(sq.Or{
+				sq.Eq{"status": entity.ReportJobStatusPending},
+				sq.And{
+					sq.Eq{"status": entity.ReportJobStatusRunning},
+					sq.Lt{"locked_until": "now()"},
+				},
+			}).
+			OrderBy("id").
+			Limit(1).
+			Suffix("FOR UPDATE SKIP LOCKED"))).
+		Suffix("RETURNING id, type, month, year, status, progress, attempts, created_at").
+		ToSql()
+
+	var job entity.ReportJob
+	err = tx.QueryRow(ctx, sql, args...).Scan(&job.Id, &job.Type, &job.Month, &job.Year, &job.Status,
+		&job.Progress, &job.Attempts, &job.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.ReportJob{}, apperror.ErrNoReportJob
+		}
+
+		return entity.ReportJob{}, fmt.Errorf("ReportJobRepo.ClaimReportJob - UPDATE: %w", err)
+	}
+
+	// Части файла, записанные прерванными попытками, удаляются при захвате
+	sql, args, _ = r.Builder.
+		Delete("report_jobs_file").
+		Where("job_id = ?", job.Id).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return entity.ReportJob{}, fmt.Errorf("ReportJobRepo.ClaimReportJob - DELETE report_jobs_file: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return entity.ReportJob{}, fmt.Errorf("ReportJobRepo.ClaimReportJob - tx.Commit: %w", err)
+	}
+
+	return job, nil
+}
+
+func (r *ReportJobRepo) UpdateReportJobProgress(ctx context.Context, id int64, attempt int, progress float32, lease time.Duration) error {
+	sql, args, _ := r.Builder.
+		Update("report_jobs").
+		Set("progress", progress).
+		Set("locked_until", sq.Expr(fmt.Sprintf("now() + INTERVAL '%d seconds'", int(lease.Seconds())))).
+		Where("id = ?", id).
+		Where("status = ?", entity.ReportJobStatusRunning).
+		Where("attempts = ?", attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrReportJobLost
+	}
+
+	return nil
+}
+
+func (r *ReportJobRepo) CompleteReportJob(ctx context.Context, id int64, attempt int, link string) error {
+	sql, args, _ := r.Builder.
+		Update("report_jobs").
+		Set("status", entity.ReportJobStatusDone).
+		Set("progress", 1).
+		Set("link", nullIfEmpty(link)).
+		Set("locked_until", nil).
+		Set("finished_at", "now()").
+		Where("id = ?", id).
+		Where("status = ?", entity.ReportJobStatusRunning).
+		Where("attempts = ?", attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrReportJobLost
+	}
+
+	return nil
+}
+
+func (r *ReportJobRepo) FailReportJob(ctx context.Context, id int64, attempt int, reason string) error {
+	sql, args, _ := r.Builder.
+		Update("report_jobs").
+		Set("status", entity.ReportJobStatusFailed).
+		Set("error", reason).
+		Set("locked_until", nil).
+		Set("finished_at", "now()").
+		Where("id = ?", id).
+		Where("status = ?", entity.ReportJobStatusRunning).
+		Where("attempts = ?", attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrReportJobLost
+	}
+
+	return nil
+}
+
+func (r *ReportJobRepo) ReleaseReportJob(ctx context.Context, id int64, attempt int) error {
+	sql, args, _ := r.Builder.
+		Update("report_jobs").
+		Set("status", entity.ReportJobStatusPending).
+		Set("progress", 0).
+		Set("locked_until", nil).
+		Where("id = ?", id).
+		Where("status = ?", entity.ReportJobStatusRunning).
+		Where("attempts = ?", attempt).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrReportJobLost
+	}
+
+	return nil
+}
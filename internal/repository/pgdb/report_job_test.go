@@ -0,0 +1,301 @@
+package pgdb_test
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/repository/pgdb"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClaimReportJob(t *testing.T) {
+	createdAt := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+
+	type args struct {
+		ctx   context.Context
+		lease time.Duration
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         entity.ReportJob
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), lease: 5 * time.Minute},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id", "type", "month", "year", "status", "progress", "attempts", "created_at"}).
+					AddRow(int64(1), "file", 8, 2023, "running", float32(0), 1, createdAt)
+				m.ExpectBegin()
+				m.ExpectQuery("UPDATE report_jobs SET status = \\$1, locked_until = now\\(\\) \\+ INTERVAL '300 seconds', "+
+					"attempts = attempts \\+ 1, started_at = COALESCE\\(started_at, now\\(\\)\\) WHERE id = \\(SELECT id FROM report_jobs (.+) FOR UPDATE SKIP LOCKED\\)").
+					WithArgs("running", "pending", "running", "now()").
+					WillReturnRows(rows)
+				m.ExpectExec("DELETE FROM report_jobs_file WHERE job_id = \\$1").
+					WithArgs(int64(1)).
+					WillReturnResult(pgxmock.NewResult("DELETE", 2))
+				m.ExpectCommit()
+				m.ExpectRollback()
+			},
+			want: entity.ReportJob{
+				Id:        1,
+				Type:      entity.ReportJobTypeFile,
+				Month:     8,
+				Year:      2023,
+				Status:    entity.ReportJobStatusRunning,
+				Attempts:  1,
+				CreatedAt: createdAt,
+			},
+		},
+		{
+			name: "No_jobs",
+			args: args{ctx: context.Background(), lease: 5 * time.Minute},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("UPDATE report_jobs").
+					WithArgs("running", "pending", "running", "now()").
+					WillReturnError(pgx.ErrNoRows)
+				m.ExpectRollback()
+			},
+			wantErr: apperror.ErrNoReportJob,
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(), lease: 5 * time.Minute},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectQuery("UPDATE report_jobs").
+					WithArgs("running", "pending", "running", "now()").
+					WillReturnError(pgx.ErrTxClosed)
+				m.ExpectRollback()
+			},
+			wantErr: pgx.ErrTxClosed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			reportJobRepoMock := pgdb.NewReportJobRepo(postgresMock)
+			got, err := reportJobRepoMock.ClaimReportJob(tc.args.ctx, tc.args.lease)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestStreamReportJobFile(t *testing.T) {
+	type args struct {
+		ctx context.Context
+		id  int64
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         []byte
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), id: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectQuery("SELECT type, status FROM report_jobs WHERE id = \\$1").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"type", "status"}).AddRow("file", "done"))
+				rows := pgxmock.NewRows([]string{"data"}).
+					AddRow([]byte("user_id,segment\n")).
+					AddRow([]byte("1000,AVITO_TEST\n"))
+				m.ExpectQuery("SELECT data FROM report_jobs_file WHERE job_id = \\$1 ORDER BY part").
+					WithArgs(args.id).
+					WillReturnRows(rows)
+				m.ExpectCommit()
+				m.ExpectRollback()
+			},
+			want: []byte("user_id,segment\n1000,AVITO_TEST\n"),
+		},
+		{
+			name: "Not_ready",
+			args: args{ctx: context.Background(), id: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectQuery("SELECT type, status FROM report_jobs").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"type", "status"}).AddRow("file", "running"))
+				m.ExpectRollback()
+			},
+			wantErr: apperror.ErrReportNotReady,
+		},
+		{
+			name: "No_job",
+			args: args{ctx: context.Background(), id: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectQuery("SELECT type, status FROM report_jobs").
+					WithArgs(args.id).
+					WillReturnError(pgx.ErrNoRows)
+				m.ExpectRollback()
+			},
+			wantErr: apperror.ErrNoReportJob,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			reportJobRepoMock := pgdb.NewReportJobRepo(postgresMock)
+			var got []byte
+			err := reportJobRepoMock.StreamReportJobFile(tc.args.ctx, tc.args.id, func(data []byte) error {
+				got = append(got, data...)
+
+				return nil
+			})
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSaveReportJobFilePart(t *testing.T) {
+	type args struct {
+		ctx     context.Context
+		id      int64
+		attempt int
+		part    int
+		data    []byte
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), id: 1, attempt: 2, part: 0, data: []byte("1000,AVITO_TEST\n")},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectExec("INSERT INTO report_jobs_file \\(job_id,part,data\\) SELECT id, \\$1::integer, \\$2::bytea "+
+					"FROM report_jobs WHERE id = \\$3 AND status = \\$4 AND attempts = \\$5 FOR SHARE").
+					WithArgs(args.part, args.data, args.id, "running", args.attempt).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+			},
+		},
+		{
+			name: "Job_lost",
+			args: args{ctx: context.Background(), id: 1, attempt: 2, part: 3, data: []byte("1000,AVITO_TEST\n")},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectExec("INSERT INTO report_jobs_file").
+					WithArgs(args.part, args.data, args.id, "running", args.attempt).
+					WillReturnResult(pgxmock.NewResult("INSERT", 0))
+			},
+			wantErr: apperror.ErrReportJobLost,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			reportJobRepoMock := pgdb.NewReportJobRepo(postgresMock)
+			err := reportJobRepoMock.SaveReportJobFilePart(tc.args.ctx, tc.args.id, tc.args.attempt, tc.args.part, tc.args.data)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCompleteReportJob(t *testing.T) {
+	type args struct {
+		ctx     context.Context
+		id      int64
+		attempt int
+		link    string
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), id: 1, attempt: 2},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectExec("UPDATE report_jobs SET status = \\$1, progress = \\$2, link = \\$3, locked_until = \\$4, finished_at = \\$5 "+
+					"WHERE id = \\$6 AND status = \\$7 AND attempts = \\$8").
+					WithArgs("done", 1, nil, nil, "now()", args.id, "running", args.attempt).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+			},
+		},
+		{
+			name: "Job_lost",
+			args: args{ctx: context.Background(), id: 1, attempt: 2},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectExec("UPDATE report_jobs").
+					WithArgs("done", 1, nil, nil, "now()", args.id, "running", args.attempt).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+			},
+			wantErr: apperror.ErrReportJobLost,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			reportJobRepoMock := pgdb.NewReportJobRepo(postgresMock)
+			err := reportJobRepoMock.CompleteReportJob(tc.args.ctx, tc.args.id, tc.args.attempt, tc.args.link)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -6,16 +6,24 @@ import (
 	"avito-internship/pkg/database/postgresdb"
 	"context"
 	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
 	"github.com/pashagolub/pgxmock/v2"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
-func TestGetSegmentHistoryFromUser(t *testing.T) {
+func TestStreamSegmentHistory(t *testing.T) {
+	addedAt := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+	leftAt := time.Date(2023, 9, 2, 10, 0, 0, 0, time.UTC)
+	columns := []string{"user_id", "name", "variant", "operation", "reason", "date", "segment_at_event"}
+
+	from := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+
 	type args struct {
-		ctx   context.Context
-		month int
-		year  int
+		ctx context.Context
+		req entity.ReportRequest
 	}
 
 	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
@@ -25,25 +33,92 @@ func TestGetSegmentHistoryFromUser(t *testing.T) {
 		args         args
 		mockBehavior MockBehavior
 		want         []entity.ReportUserHistory
-		wantErr      bool
+		wantErr      error
 	}{
 		{
-			name: "OK",
+			name: "OK_empty",
 			args: args{ctx: context.Background(),
-				month: 9,
-				year:  2023,
+				req: entity.ReportRequest{From: from, To: to},
 			},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				rows := pgxmock.NewRows([]string{
-					"user_id", "added_at",
-					"name", "left_at",
-				})
-				m.ExpectQuery("SELECT").
-					WithArgs(args.month, args.year).
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectExec("DECLARE report_history NO SCROLL CURSOR FOR SELECT (.+) UNION ALL SELECT (.+) ORDER BY date, user_id").
+					WithArgs(from, to, from, to).
+					WillReturnResult(pgxmock.NewResult("DECLARE CURSOR", 0))
+				m.ExpectQuery("FETCH FORWARD 1000 FROM report_history").
+					WillReturnRows(pgxmock.NewRows(columns))
+				m.ExpectCommit()
+			},
+			want: nil,
+		},
+		{
+			name: "OK_expired",
+			args: args{ctx: context.Background(),
+				req: entity.ReportRequest{From: from, To: to},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectExec("DECLARE report_history").
+					WithArgs(from, to, from, to).
+					WillReturnResult(pgxmock.NewResult("DECLARE CURSOR", 0))
+				rows := pgxmock.NewRows(columns).
+					AddRow(1000, "AVITO_VOICE_MESSAGES", "", "add", "", addedAt, "AVITO_VOICE").
+					AddRow(1000, "AVITO_VOICE_MESSAGES", "", "remove", "expired", leftAt, "AVITO_VOICE_MESSAGES")
+				m.ExpectQuery("FETCH FORWARD 1000 FROM report_history").
 					WillReturnRows(rows)
+				m.ExpectCommit()
+			},
+			want: []entity.ReportUserHistory{
+				{UserId: "1000", Segment: "AVITO_VOICE_MESSAGES", Operation: "add", Date: addedAt,
+					SegmentAtEvent: "AVITO_VOICE"},
+				{UserId: "1000", Segment: "AVITO_VOICE_MESSAGES", Operation: "remove", Reason: "expired", Date: leftAt,
+					SegmentAtEvent: "AVITO_VOICE_MESSAGES"},
+			},
+		},
+		{
+			name: "OK_remove_with_filters",
+			args: args{ctx: context.Background(),
+				req: entity.ReportRequest{
+					From:      from,
+					To:        to,
+					Segments:  []string{"AVITO_VOICE_MESSAGES"},
+					UserIds:   []int{1000},
+					Operation: entity.ReportOperationRemove,
+				},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectExec("DECLARE report_history NO SCROLL CURSOR FOR SELECT (.+) 'remove' AS operation(.+) "+
+					"WHERE s.name = ANY\\(\\$1\\) AND us.user_id = ANY\\(\\$2\\) AND us.left_at >= \\$3 AND us.left_at < least\\(\\$4::timestamptz, now\\(\\)\\) ORDER BY date, user_id").
+					WithArgs(args.req.Segments, args.req.UserIds, from, to).
+					WillReturnResult(pgxmock.NewResult("DECLARE CURSOR", 0))
+				rows := pgxmock.NewRows(columns).
+					AddRow(1000, "AVITO_VOICE_MESSAGES", "", "remove", "manual", leftAt, "AVITO_VOICE_MESSAGES")
+				m.ExpectQuery("FETCH FORWARD 1000 FROM report_history").
+					WillReturnRows(rows)
+				m.ExpectCommit()
+			},
+			want: []entity.ReportUserHistory{
+				{UserId: "1000", Segment: "AVITO_VOICE_MESSAGES", Operation: "remove", Reason: "manual", Date: leftAt,
+					SegmentAtEvent: "AVITO_VOICE_MESSAGES"},
+			},
+		},
+		{
+			name: "Fetch_error",
+			args: args{ctx: context.Background(),
+				req: entity.ReportRequest{From: from, To: to},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+				m.ExpectExec("DECLARE report_history").
+					WithArgs(from, to, from, to).
+					WillReturnResult(pgxmock.NewResult("DECLARE CURSOR", 0))
+				m.ExpectQuery("FETCH FORWARD 1000 FROM report_history").
+					WillReturnError(pgx.ErrTxClosed)
+				m.ExpectRollback()
 			},
-			wantErr: false,
 			want:    nil,
+			wantErr: pgx.ErrTxClosed,
 		},
 	}
 	for _, tc := range testCases {
@@ -57,15 +132,17 @@ func TestGetSegmentHistoryFromUser(t *testing.T) {
 				Pool:    poolMock,
 			}
 			reportRepoMock := pgdb.NewReportRepo(postgresMock)
-			got, err := reportRepoMock.GetSegmentHistoryFromUser(tc.args.ctx, tc.args.month, tc.args.year)
 
-			if tc.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-			}
+			var got []entity.ReportUserHistory
+			err := reportRepoMock.StreamSegmentHistory(tc.args.ctx, tc.args.req, func(record entity.ReportUserHistory) error {
+				got = append(got, record)
+
+				return nil
+			})
 
+			assert.ErrorIs(t, err, tc.wantErr)
 			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
 		})
 	}
 }
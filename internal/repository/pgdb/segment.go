@@ -1,14 +1,31 @@
 package pgdb
 
 import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
 	"avito-internship/pkg/database/postgresdb"
 	"context"
 	"errors"
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
+	"math"
+	"strings"
+	"time"
 )
 
+const (
+	// bucketCount количество бакетов, на которые делятся пользователи при процентной раскатке
+	bucketCount = 10000
+
+	sourceRollout = "rollout"
+	sourceImport  = "import"
+	sourceRestore = "restore"
+)
+
+// likeEscaper экранирует спецсимволы шаблона LIKE, чтобы префикс искался буквально
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 type SegmentRepo struct {
 	*postgresdb.Postgres
 }
@@ -17,22 +34,80 @@ func NewSegmentRepo(pg *postgresdb.Postgres) *SegmentRepo {
 	return &SegmentRepo{pg}
 }
 
-func (r *SegmentRepo) CreateSegment(ctx context.Context, segment string) (int, error) {
+func (r *SegmentRepo) CreateSegment(ctx context.Context, segment entity.Segment) (int, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	taken, err := aliasTaken(ctx, r.Builder, tx, segment.Name, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, apperror.ErrSegmentNameTaken
+	}
+
 	sql, args, _ := r.Builder.
 		Insert("segments").
-		Columns("name").
-		Values(segment).
+		Columns("name", "rule", "layer_id", "payload", "starts_at", "ends_at",
+			"description", "owner_team", "tags", "ticket_url", "created_by", "updated_by").
+		Values(segment.Name, nullIfEmpty(segment.Rule), nullIfZero(segment.LayerId), nullIfEmpty(string(segment.Payload)),
+			sq.Expr("COALESCE(?::timestamptz, now())", segment.StartsAt), segment.EndsAt,
+			nullIfEmpty(segment.Description), nullIfEmpty(segment.OwnerTeam), sq.Expr("COALESCE(?::text[], '{}')", segment.Tags),
+			nullIfEmpty(segment.TicketUrl), nullIfEmpty(segment.CreatedBy), nullIfEmpty(segment.CreatedBy)).
 		Suffix("ON CONFLICT DO NOTHING").
 		Suffix("RETURNING id").
 		ToSql()
 
 	var segmentId int
-	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&segmentId)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&segmentId)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, nil
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, err
+		}
+
+		// Название занято: существующий сегмент не меняется, а удалённый нужно восстановить
+		sql, args, _ = r.Builder.
+			Select("deleted_at IS NOT NULL AND deleted_at <= now()").
+			From("segments").
+			Where("name = ?", segment.Name).
+			ToSql()
+
+		var deleted bool
+		err = tx.QueryRow(ctx, sql, args...).Scan(&deleted)
+		if err != nil {
+			return 0, err
+		}
+		if deleted {
+			return 0, apperror.ErrSegmentDeleted
 		}
 
+		return 0, nil
+	}
+
+	if len(segment.Variants) > 0 {
+		err = insertSegmentVariants(ctx, r.Builder, tx, segmentId, segment.Variants)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if segment.Percent != 0.0 {
+		err = setSegmentPercent(ctx, r.Builder, tx, segment.Name, segment.Percent)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = enqueueSegmentEvent(ctx, r.Builder, tx, entity.EventSegmentCreated, segment.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
 		return 0, err
 	}
 
@@ -49,12 +124,25 @@ func (r *SegmentRepo) DeleteSegment(ctx context.Context, segment string) error {
 	sql, args, _ := r.Builder.
 		Update("segments").
 		Set("deleted_at", "now()").
-		Where("name = ?", segment).
-		Suffix("RETURNING id").
+		Where(segmentNamed(segment)).
+		Where(sq.Or{
+			sq.Eq{"deleted_at": nil},
+			sq.Gt{"deleted_at": "now()"},
+		}).
+		Suffix("RETURNING id, name").
 		ToSql()
 
 	var segmentId int
-	err = tx.QueryRow(ctx, sql, args...).Scan(&segmentId)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&segmentId, &segment)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apperror.ErrNoSegment
+		}
+
+		return err
+	}
+
+	err = cancelScheduledMemberships(ctx, r.Builder, tx, sq.Eq{"segment_id": segmentId})
 	if err != nil {
 		return err
 	}
@@ -62,14 +150,33 @@ func (r *SegmentRepo) DeleteSegment(ctx context.Context, segment string) error {
 	sql, args, _ = r.Builder.
 		Update("users_segment").
 		Set("left_at", "now()").
+		Set("finalized_at", "now()").
+		Set("removal_reason", entity.RemovalReasonSegmentDeleted).
 		Where("segment_id = ?", segmentId).
 		Where(sq.Or{
 			sq.Eq{"left_at": nil},
 			sq.Gt{"left_at": "now()"},
 		}).
+		Suffix("RETURNING id").
 		ToSql()
 
-	_, err = tx.Exec(ctx, sql, args...)
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	membershipIds, err := scanIds(rows)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueMembershipEvents(ctx, r.Builder, tx, entity.EventMembershipRemoved,
+		entity.RemovalReasonSegmentDeleted, membershipIds)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueSegmentEvent(ctx, r.Builder, tx, entity.EventSegmentDeleted, segment)
 	if err != nil {
 		return err
 	}
@@ -82,47 +189,886 @@ func (r *SegmentRepo) DeleteSegment(ctx context.Context, segment string) error {
 	return nil
 }
 
-func (r *SegmentRepo) CheckExistSegment(ctx context.Context, segment string) (bool, error) {
+func (r *SegmentRepo) RestoreSegment(ctx context.Context, segment string, reenroll bool, actor string) (int, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
 	sql, args, _ := r.Builder.
+		Update("segments AS s").
+		Set("deleted_at", nil).
+		Set("updated_at", "now()").
+		Set("updated_by", nullIfEmpty(actor)).
+		From("segments AS old").
+		Where("old.id = s.id").
+		Where("s.name = ?", segment).
+		Where(sq.LtOrEq{"s.deleted_at": "now()"}).
+		Suffix("RETURNING s.id, old.deleted_at").
+		ToSql()
+
+	var (
+		segmentId int
+		deletedAt time.Time
+	)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&segmentId, &deletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, apperror.ErrNoDeletedSegment
+		}
+
+		return 0, err
+	}
+
+	// Сегмент заблокирован обновлением, поэтому пачка удаления истории не может выполняться одновременно.
+	// Восстановление посреди задания оставило бы сегмент с частью истории, поэтому оно отклоняется,
+	// пока есть незавершённое задание на удаление сегмента или retention, захватывающее время его удаления.
+	sql, args, _ = r.Builder.
 		Select("1").
 		Prefix("SELECT EXISTS (").
+		From("purge_jobs").
+		Where(sq.Eq{"status": []string{entity.PurgeJobStatusPending, entity.PurgeJobStatusRunning}}).
+		Where(sq.Or{
+			sq.Eq{"segment_id": segmentId},
+			sq.And{
+				sq.Eq{"type": entity.PurgeJobTypeRetention},
+				sq.Gt{"cutoff": deletedAt},
+			},
+		}).
+		Suffix(")").
+		ToSql()
+
+	var purging bool
+	err = tx.QueryRow(ctx, sql, args...).Scan(&purging)
+	if err != nil {
+		return 0, err
+	}
+
+	if purging {
+		return 0, apperror.ErrSegmentPurging
+	}
+
+	var membershipIds []int64
+	if reenroll {
+		// Возвращаются пользователи, исключённые удалением сегмента (их left_at совпадает с временем удаления),
+		// кроме псевдонимов удалённых пользователей и тех, кто уже состоит в другом сегменте того же слоя.
+		// Запланированное членство восстанавливается с прежним временем начала, ttl не восстанавливается.
+		sql, args, _ = r.Builder.
+			Insert("users_segment").
+			Columns("user_id", "segment_id", "source", "variant", "added_at", "added_by").
+			Select(sq.
+				Select("DISTINCT ON (us.user_id) us.user_id", "us.segment_id").
+				Column("?::varchar", sourceRestore).
+				Columns("us.variant", "GREATEST(now(), us.added_at)").
+				Column("?::varchar", nullIfEmpty(actor)).
+				From("users_segment AS us").
+				Join("segments AS s ON s.id = us.segment_id").
+				Where("us.segment_id = ?", segmentId).
+				Where("us.user_id > 0").
+				Where(sq.Eq{"us.removal_reason": entity.RemovalReasonSegmentDeleted}).
+				Where(sq.Eq{"us.left_at": deletedAt}).
+				Where("NOT EXISTS (SELECT 1 FROM users_segment AS other "+
+					"JOIN segments AS os ON os.id = other.segment_id "+
+					"WHERE other.user_id = us.user_id AND os.layer_id = s.layer_id AND os.id <> s.id "+
+					"AND (other.left_at IS NULL OR other.left_at > now()))").
+				OrderBy("us.user_id", "us.added_at DESC")).
+			Suffix("RETURNING id").
+			ToSql()
+
+		rows, err := tx.Query(ctx, sql, args...)
+		if err != nil {
+			return 0, err
+		}
+
+		membershipIds, err = scanIds(rows)
+		if err != nil {
+			return 0, err
+		}
+
+		err = enqueueMembershipEvents(ctx, r.Builder, tx, entity.EventMembershipAdded, "", membershipIds)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = enqueueSegmentEvent(ctx, r.Builder, tx, entity.EventSegmentRestored, segment)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(membershipIds), nil
+}
+
+func (r *SegmentRepo) RenameSegment(ctx context.Context, segment string, newName string, aliasUntil time.Time, actor string) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Select("id").
 		From("segments").
 		Where("name = ?", segment).
 		Where(sq.Or{
 			sq.Eq{"deleted_at": nil},
 			sq.Gt{"deleted_at": "now()"},
 		}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	var segmentId int
+	err = tx.QueryRow(ctx, sql, args...).Scan(&segmentId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apperror.ErrNoSegment
+		}
+
+		return err
+	}
+
+	// Новое название не должно быть занято другим сегментом (в том числе удалённым) или его псевдонимом,
+	// собственный псевдоним сегмента можно вернуть как название
+	sql, args, _ = r.Builder.
+		Select("1").
+		Prefix("SELECT EXISTS (").
+		From("segments").
+		Where("name = ?", newName).
 		Suffix(")").
 		ToSql()
 
 	var exist bool
-	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&exist)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&exist)
+	if err != nil {
+		return err
+	}
 
+	taken, err := aliasTaken(ctx, r.Builder, tx, newName, segmentId)
 	if err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			return false, err
+		return err
+	}
+	if exist || taken {
+		return apperror.ErrSegmentNameTaken
+	}
+
+	sql, args, _ = r.Builder.
+		Update("segments").
+		Set("name", newName).
+		Set("updated_at", "now()").
+		Set("updated_by", nullIfEmpty(actor)).
+		Where("id = ?", segmentId).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	sql, args, _ = r.Builder.
+		Update("segments_alias").
+		Set("alias_until", "now()").
+		Where("segment_id = ?", segmentId).
+		Where("name = ?", newName).
+		Where(sq.Gt{"alias_until": "now()"}).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	sql, args, _ = r.Builder.
+		Insert("segments_alias").
+		Columns("segment_id", "name", "alias_until").
+		Values(segmentId, segment, aliasUntil).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	// Прежнее название передаётся в reason события
+	sql, args, _ = r.Builder.
+		Insert("events").
+		Columns("type", "segment", "reason").
+		Values(entity.EventSegmentRenamed, newName, segment).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SegmentRepo) UpdateSegment(ctx context.Context, req entity.SegmentUpdateRequest) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Любое изменение сегмента сохраняет время и инициатора изменения
+	b := r.Builder.
+		Update("segments").
+		Set("updated_at", "now()").
+		Set("updated_by", nullIfEmpty(req.Actor)).
+		Where(segmentNamed(req.Segment)).
+		Where(sq.Or{
+			sq.Eq{"deleted_at": nil},
+			sq.Gt{"deleted_at": "now()"},
+		})
+	if req.Description != nil {
+		b = b.Set("description", nullIfEmpty(*req.Description))
+	}
+	if req.OwnerTeam != nil {
+		b = b.Set("owner_team", nullIfEmpty(*req.OwnerTeam))
+	}
+	if req.Tags != nil {
+		b = b.Set("tags", req.Tags)
+	}
+	if req.TicketUrl != nil {
+		b = b.Set("ticket_url", nullIfEmpty(*req.TicketUrl))
+	}
+	if req.Rule != nil {
+		b = b.Set("rule", nullIfEmpty(*req.Rule))
+	}
+	if req.Payload != nil {
+		// JSON null удаляет данные сегмента
+		b = b.Set("payload", sq.Expr("NULLIF(?::jsonb, 'null'::jsonb)", string(req.Payload)))
+	}
+
+	sql, args, _ := b.Suffix("RETURNING name, layer_id IS NOT NULL").ToSql()
+
+	var (
+		name    string
+		layered bool
+	)
+	err = tx.QueryRow(ctx, sql, args...).Scan(&name, &layered)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apperror.ErrNoSegment
 		}
+
+		return err
 	}
 
-	return exist, nil
+	// Пользователи, подходящие под правило, не проверяются на членство в других сегментах слоя
+	if layered && req.Rule != nil && *req.Rule != "" {
+		return apperror.ErrRuleInLayer
+	}
+
+	if req.Percent != nil {
+		err = setSegmentPercent(ctx, r.Builder, tx, name, *req.Percent)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
-func (r *SegmentRepo) RandomUserToSegment(ctx context.Context, segmentId int, percent float32) error {
-	subQuery := fmt.Sprintf("id, %v", segmentId)
+func (r *SegmentRepo) GetSegments(ctx context.Context, req entity.SegmentListRequest, limit int) ([]entity.SegmentInfo, error) {
+	b := r.Builder.
+		Select("s.name", "COALESCE(s.description, '')", "COALESCE(s.owner_team, '')", "s.tags",
+			"COALESCE(s.ticket_url, '')", "COALESCE(s.rule, '')", "COALESCE(l.name, '')", "s.percent").
+		Column(fmt.Sprintf("CASE WHEN s.deleted_at <= now() THEN '%s' WHEN s.starts_at > now() THEN '%s' "+
+			"WHEN s.ends_at <= now() THEN '%s' ELSE '%s' END",
+			entity.SegmentStatusDeleted, entity.SegmentStatusScheduled, entity.SegmentStatusEnded, entity.SegmentStatusActive)).
+		Column("(SELECT count(*) FROM users_segment AS us WHERE us.segment_id = s.id "+
+			"AND us.added_at <= now() AND (us.left_at IS NULL OR us.left_at > now()))").
+		Columns("s.starts_at", "s.ends_at", "s.created_at", "COALESCE(s.created_by, '')",
+			"s.updated_at", "COALESCE(s.updated_by, '')", "s.deleted_at").
+		From("segments AS s").
+		LeftJoin("layers AS l ON l.id = s.layer_id")
+
+	if req.Prefix != "" {
+		b = b.Where("s.name LIKE ?", likeEscaper.Replace(req.Prefix)+"%")
+	}
+	if len(req.Tags) > 0 {
+		b = b.Where("s.tags @> ?::text[]", req.Tags)
+	}
+	if req.Cursor != "" {
+		b = b.Where(sq.Gt{"s.name": req.Cursor})
+	}
+
+	notDeleted := sq.Or{
+		sq.Eq{"s.deleted_at": nil},
+		sq.Gt{"s.deleted_at": "now()"},
+	}
+	switch req.Status {
+	case entity.SegmentStatusActive:
+		b = b.Where(activeSegment("s."))
+	case entity.SegmentStatusScheduled:
+		b = b.Where(notDeleted).Where(sq.Gt{"s.starts_at": "now()"})
+	case entity.SegmentStatusEnded:
+		b = b.Where(notDeleted).Where(sq.LtOrEq{"s.ends_at": "now()"})
+	case entity.SegmentStatusDeleted:
+		b = b.Where(sq.LtOrEq{"s.deleted_at": "now()"})
+	}
+
+	sql, args, _ := b.OrderBy("s.name").Limit(uint64(limit)).ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []entity.SegmentInfo
+	for rows.Next() {
+		var segment entity.SegmentInfo
+		err = rows.Scan(&segment.Name, &segment.Description, &segment.OwnerTeam, &segment.Tags, &segment.TicketUrl,
+			&segment.Rule, &segment.Layer, &segment.Percent, &segment.Status, &segment.Members,
+			&segment.StartsAt, &segment.EndsAt, &segment.CreatedAt, &segment.CreatedBy,
+			&segment.UpdatedAt, &segment.UpdatedBy, &segment.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+func (r *SegmentRepo) GetActiveRuleSegments(ctx context.Context) ([]entity.Segment, error) {
+	sql, args, _ := r.Builder.
+		Select("id", "name", "rule", "payload").
+		From("segments").
+		Where(sq.NotEq{"rule": nil}).
+		Where(activeSegment("")).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []entity.Segment
+	for rows.Next() {
+		var segment entity.Segment
+		err = rows.Scan(&segment.Id, &segment.Name, &segment.Rule, (*[]byte)(&segment.Payload))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+func (r *SegmentRepo) GetUserVariants(ctx context.Context, userId int, segmentIds []int) (map[int]string, error) {
+	sql, args, _ := r.Builder.
+		Select("id").
+		Column(sq.Expr("COALESCE(segment_variant(id, ?), '')", userId)).
+		From("segments").
+		Where(sq.Eq{"id": segmentIds}).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make(map[int]string, len(segmentIds))
+	for rows.Next() {
+		var (
+			segmentId int
+			variant   string
+		)
+		err = rows.Scan(&segmentId, &variant)
+		if err != nil {
+			return nil, err
+		}
+		variants[segmentId] = variant
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+func (r *SegmentRepo) GetUsersVariants(ctx context.Context, userIds []int, segmentIds []int) (map[int]map[int]string, error) {
+	sql, args, _ := r.Builder.
+		Select("u.id", "s.id", "COALESCE(segment_variant(s.id, u.id), '')").
+		From("segments AS s").
+		JoinClause("CROSS JOIN unnest(?::integer[]) AS u(id)", userIds).
+		Where("s.id = ANY(?)", segmentIds).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make(map[int]map[int]string, len(userIds))
+	for rows.Next() {
+		var (
+			userId    int
+			segmentId int
+			variant   string
+		)
+		err = rows.Scan(&userId, &segmentId, &variant)
+		if err != nil {
+			return nil, err
+		}
+		if variants[userId] == nil {
+			variants[userId] = make(map[int]string, len(segmentIds))
+		}
+		variants[userId][segmentId] = variant
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+// setSegmentPercent изменяет процент пользователей в сегменте и добавляет или исключает пользователей
+// новых или освободившихся бакетов. Вызывается в транзакции создания или изменения сегмента.
+func setSegmentPercent(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx, segment string, percent float32) error {
+	sql, args, _ := builder.
+		Select("id", "percent", "salt", "layer_id").
+		From("segments").
+		Where("name = ?", segment).
+		Where(sq.Or{
+			sq.Eq{"deleted_at": nil},
+			sq.Gt{"deleted_at": "now()"},
+		}).
+		Where(sq.Or{
+			sq.Eq{"ends_at": nil},
+			sq.Gt{"ends_at": "now()"},
+		}).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	var (
+		segmentId  int
+		oldPercent float32
+		salt       string
+		layerId    *int
+	)
+	err := tx.QueryRow(ctx, sql, args...).Scan(&segmentId, &oldPercent, &salt, &layerId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apperror.ErrNoSegment
+		}
+
+		return err
+	}
+
+	sql, args, _ = builder.
+		Update("segments").
+		Set("percent", percent).
+		Where("id = ?", segmentId).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	oldBuckets, newBuckets := percentToBuckets(oldPercent), percentToBuckets(percent)
+	switch {
+	case newBuckets > oldBuckets:
+		// Добавляются только пользователи из новых бакетов, уже попавшие в сегмент остаются в нём.
+		// Для запланированного сегмента членство начинается с его активации.
+		// Псевдонимы удалённых пользователей (отрицательные id) не добавляются.
+		usersQuery := sq.Select(fmt.Sprintf("u.id, %d, '%s', segment_variant(%d, u.id), "+
+			"GREATEST(now(), (SELECT starts_at FROM segments WHERE id = %d))",
+			segmentId, sourceRollout, segmentId, segmentId)).
+			From("users AS u").
+			Where("u.id > 0").
+			Where("segment_bucket(?, u.id) >= ?", salt, oldBuckets).
+			Where("segment_bucket(?, u.id) < ?", salt, newBuckets).
+			Where(sq.Expr("NOT EXISTS (?)", sq.
+				Select("1").
+				From("users_segment AS us").
+				Where("us.user_id = u.id").
+				Where("us.segment_id = ?", segmentId).
+				Where(sq.Or{
+					sq.Eq{"us.left_at": nil},
+					sq.Gt{"us.left_at": "now()"},
+				})))
+		if layerId != nil {
+			// Пользователи, уже состоящие в другом сегменте слоя, пропускаются
+			usersQuery = usersQuery.Where(sq.Expr("NOT EXISTS (?)", sq.
+				Select("1").
+				From("users_segment AS us").
+				Join("segments AS s ON s.id = us.segment_id").
+				Where("us.user_id = u.id").
+				Where("s.layer_id = ?", *layerId).
+				Where("s.id <> ?", segmentId).
+				Where(sq.Or{
+					sq.Eq{"us.left_at": nil},
+					sq.Gt{"us.left_at": "now()"},
+				})))
+		}
+
+		sql, args, _ = builder.
+			Insert("users_segment").
+			Columns("user_id", "segment_id", "source", "variant", "added_at").
+			Select(usersQuery).
+			Suffix("RETURNING id").
+			ToSql()
+
+		rows, err := tx.Query(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		membershipIds, err := scanIds(rows)
+		if err != nil {
+			return err
+		}
+
+		return enqueueMembershipEvents(ctx, builder, tx, entity.EventMembershipAdded, "", membershipIds)
+	case newBuckets < oldBuckets:
+		// Исключаются только пользователи из верхних бакетов, добавленные через раскатку
+		rollout := sq.And{
+			sq.Eq{"segment_id": segmentId},
+			sq.Eq{"source": sourceRollout},
+			sq.Expr("segment_bucket(?, user_id) >= ?", salt, newBuckets),
+		}
+		err = cancelScheduledMemberships(ctx, builder, tx, rollout)
+		if err != nil {
+			return err
+		}
+
+		sql, args, _ = builder.
+			Update("users_segment").
+			Set("left_at", "now()").
+			Set("finalized_at", "now()").
+			Set("removal_reason", entity.RemovalReasonRollout).
+			Where(rollout).
+			Where(sq.Or{
+				sq.Eq{"left_at": nil},
+				sq.Gt{"left_at": "now()"},
+			}).
+			Suffix("RETURNING id").
+			ToSql()
+
+		rows, err := tx.Query(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		membershipIds, err := scanIds(rows)
+		if err != nil {
+			return err
+		}
+
+		return enqueueMembershipEvents(ctx, builder, tx, entity.EventMembershipRemoved,
+			entity.RemovalReasonRollout, membershipIds)
+	}
+
+	return nil
+}
+
+// insertSegmentVariants сохраняет варианты эксперимента сегмента. Вызывается в транзакции создания сегмента.
+func insertSegmentVariants(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx, segmentId int, variants []entity.Variant) error {
+	sqlQuery := builder.
+		Insert("segments_variant").
+		Columns("segment_id", "name", "weight")
+	for _, variant := range variants {
+		sqlQuery = sqlQuery.Values(segmentId, variant.Name, variant.Weight)
+	}
+
+	sql, args, _ := sqlQuery.ToSql()
+	_, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SegmentRepo) ExpireSegments(ctx context.Context) (int64, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
 	sql, args, _ := r.Builder.
+		Update("users_segment AS us").
+		Set("left_at", sq.Expr("s.ends_at")).
+		Set("finalized_at", "now()").
+		Set("removal_reason", entity.RemovalReasonSegmentEnded).
+		From("segments AS s").
+		Where("s.id = us.segment_id").
+		Where(sq.LtOrEq{"s.ends_at": "now()"}).
+		Where(sq.Or{
+			sq.Eq{"us.left_at": nil},
+			sq.Expr("us.left_at > s.ends_at"),
+		}).
+		Suffix("RETURNING us.id").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	membershipIds, err := scanIds(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	err = enqueueMembershipEvents(ctx, r.Builder, tx, entity.EventMembershipRemoved,
+		entity.RemovalReasonSegmentEnded, membershipIds)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(membershipIds)), nil
+}
+
+func (r *SegmentRepo) GetSegmentId(ctx context.Context, segment string) (int, error) {
+	sql, args, _ := r.Builder.
+		Select("id").
+		From("segments").
+		Where(segmentNamed(segment)).
+		ToSql()
+
+	var segmentId int
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&segmentId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, apperror.ErrNoSegment
+		}
+
+		return 0, err
+	}
+
+	return segmentId, nil
+}
+
+func (r *SegmentRepo) GetSegmentMembersAt(ctx context.Context, segmentId int, at time.Time, membership string, afterUserId int, limit int) ([]entity.SegmentMember, error) {
+	sql, args, _ := segmentMembersAt(r.Builder.Select("us.user_id", "COALESCE(us.variant, '')", "us.added_at", "us.left_at"),
+		segmentId, at, membership).
+		Where(sq.Gt{"us.user_id": afterUserId}).
+		OrderBy("us.user_id").
+		Limit(uint64(limit)).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []entity.SegmentMember
+	for rows.Next() {
+		var member entity.SegmentMember
+		err = rows.Scan(&member.UserId, &member.Variant, &member.AddedAt, &member.LeftAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (r *SegmentRepo) CountSegmentMembersAt(ctx context.Context, segmentId int, at time.Time, membership string) (int, error) {
+	sql, args, _ := segmentMembersAt(r.Builder.Select("count(*)"), segmentId, at, membership).ToSql()
+
+	var count int
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// segmentMembersAt дополняет запрос условиями членства пользователей в сегменте в момент at:
+// added_at <= at < left_at, сегмент активен в этот момент, и [опционально] типом членства
+func segmentMembersAt(b sq.SelectBuilder, segmentId int, at time.Time, membership string) sq.SelectBuilder {
+	b = b.
+		From("users_segment AS us").
+		Join("segments AS s ON s.id = us.segment_id").
+		Where("us.segment_id = ?", segmentId).
+		Where(sq.LtOrEq{"us.added_at": at}).
+		Where(sq.Or{
+			sq.Eq{"us.left_at": nil},
+			sq.Gt{"us.left_at": at},
+		}).
+		Where(activeSegmentAt("s.", at))
+
+	switch membership {
+	case entity.SegmentMembershipTtl:
+		b = b.Where(sq.NotEq{"us.left_at": nil})
+	case entity.SegmentMembershipPermanent:
+		b = b.Where(sq.Eq{"us.left_at": nil})
+	}
+
+	return b
+}
+
+// enrollNewUsers добавляет впервые появившихся пользователей во все активные и запланированные сегменты
+// с процентной раскаткой, если бакет пользователя попадает в процент сегмента.
+// Вызывается в транзакции добавления пользователей.
+// Из нескольких подходящих сегментов одного слоя пользователь попадает только в созданный раньше.
+// События добавления записываются в журнал событий в той же транзакции.
+func enrollNewUsers(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx, ids []int) error {
+	sql, args, _ := builder.
 		Insert("users_segment").
-		Columns("user_id", "segment_id").
+		Columns("user_id", "segment_id", "source", "variant", "added_at").
 		Select(
-			sq.Select(subQuery).
-				From("users").
-				Where(sq.Expr("random() < ?", percent))).
+			sq.Select(fmt.Sprintf("u.id, s.id, '%s', segment_variant(s.id, u.id), GREATEST(now(), s.starts_at)", sourceRollout)).
+				Options("DISTINCT ON (u.id, COALESCE(s.layer_id, -s.id))").
+				From("users AS u").
+				Join(fmt.Sprintf("segments AS s ON segment_bucket(s.salt, u.id) < round(s.percent * %d)", bucketCount)).
+				Where("u.id = ANY(?)", ids).
+				Where("u.id > 0").
+				Where(sq.Gt{"s.percent": 0}).
+				Where(sq.Or{
+					sq.Eq{"s.deleted_at": nil},
+					sq.Gt{"s.deleted_at": "now()"},
+				}).
+				Where(sq.Or{
+					sq.Eq{"s.ends_at": nil},
+					sq.Gt{"s.ends_at": "now()"},
+				}).
+				OrderBy("u.id", "COALESCE(s.layer_id, -s.id)", "s.id")).
+		Suffix("RETURNING id").
 		ToSql()
 
-	_, err := r.Pool.Exec(ctx, sql, args...)
+	rows, err := tx.Query(ctx, sql, args...)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	membershipIds, err := scanIds(rows)
+	if err != nil {
+		return err
+	}
+
+	return enqueueMembershipEvents(ctx, builder, tx, entity.EventMembershipAdded, "", membershipIds)
+}
+
+// activeSegment условие активности сегмента: сегмент не удалён и текущее время попадает в окно [starts_at, ends_at).
+// alias задаёт префикс колонок, например "s."
+func activeSegment(alias string) sq.And {
+	return activeSegmentAt(alias, "now()")
+}
+
+// activeSegmentAt условие активности сегмента в момент at: сегмент не был удалён к этому моменту
+// и момент попадает в окно [starts_at, ends_at)
+func activeSegmentAt(alias string, at any) sq.And {
+	return sq.And{
+		sq.Or{
+			sq.Eq{alias + "deleted_at": nil},
+			sq.Gt{alias + "deleted_at": at},
+		},
+		sq.LtOrEq{alias + "starts_at": at},
+		sq.Or{
+			sq.Eq{alias + "ends_at": nil},
+			sq.Gt{alias + "ends_at": at},
+		},
+	}
+}
+
+// segmentNamed условие выбора сегмента по названию или по прежнему названию, пока оно действует как псевдоним
+func segmentNamed(segment string) sq.Or {
+	return sq.Or{
+		sq.Eq{"name": segment},
+		sq.Expr("id = (SELECT segment_id FROM segments_alias WHERE name = ? AND alias_until > now() "+
+			"ORDER BY renamed_at DESC LIMIT 1)", segment),
+	}
+}
+
+// segmentNameAt выражение названия сегмента s в момент, заданный выражением date:
+// первое прежнее название, сменённое после этого момента, или текущее название
+func segmentNameAt(date string) string {
+	return fmt.Sprintf("COALESCE((SELECT a.name FROM segments_alias AS a WHERE a.segment_id = s.id "+
+		"AND a.renamed_at > %s ORDER BY a.renamed_at LIMIT 1), s.name)", date)
+}
+
+// aliasTaken проверяет, является ли название действующим псевдонимом переименованного сегмента,
+// псевдонимы сегмента segmentId не учитываются (0 - учитываются все)
+func aliasTaken(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx, name string, segmentId int) (bool, error) {
+	sql, args, _ := builder.
+		Select("1").
+		Prefix("SELECT EXISTS (").
+		From("segments_alias").
+		Where("name = ?", name).
+		Where(sq.Gt{"alias_until": "now()"}).
+		Where(sq.NotEq{"segment_id": segmentId}).
+		Suffix(")").
+		ToSql()
+
+	var taken bool
+	err := tx.QueryRow(ctx, sql, args...).Scan(&taken)
+	if err != nil {
+		return false, err
+	}
+
+	return taken, nil
+}
+
+// percentToBuckets переводит долю пользователей (0.0-1.0) в количество бакетов сегмента
+func percentToBuckets(percent float32) int {
+	return int(math.Round(float64(percent) * bucketCount))
+}
+
+// nullIfEmpty возвращает nil для пустой строки, чтобы в бд записывался NULL
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
+
+// nullIfZero возвращает nil для нулевого id, чтобы в бд записывался NULL
+func nullIfZero(id int) any {
+	if id == 0 {
+		return nil
+	}
+
+	return id
 }
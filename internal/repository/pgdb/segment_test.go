@@ -1,20 +1,27 @@
 package pgdb_test
 
 import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
 	"avito-internship/internal/repository/pgdb"
 	"avito-internship/pkg/database/postgresdb"
 	"context"
+	"encoding/json"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 	"github.com/pashagolub/pgxmock/v2"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestCreateSegment(t *testing.T) {
+	startsAt := time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC)
+	endsAt := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+
 	type args struct {
 		ctx     context.Context
-		segment string
+		segment entity.Segment
 	}
 
 	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
@@ -29,28 +36,249 @@ func TestCreateSegment(t *testing.T) {
 		{
 			name: "OK",
 			args: args{ctx: context.Background(),
-				segment: "Test_Segment",
+				segment: entity.Segment{Name: "Test_Segment"},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments_alias WHERE name = \\$1 AND alias_until > \\$2 AND segment_id <> \\$3 \\)").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(1)
+				m.ExpectQuery("INSERT INTO segments").
+					WithArgs(args.segment.Name, nil, nil, nil, args.segment.StartsAt, args.segment.EndsAt,
+						nil, nil, args.segment.Tags, nil, nil, nil).WillReturnRows(rows)
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.created", args.segment.Name).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+			want:    1,
+		},
+		{
+			name: "OK_with_rule",
+			args: args{ctx: context.Background(),
+				segment: entity.Segment{Name: "Test_Segment", Rule: `platform == "ios"`},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments_alias WHERE name = \\$1 AND alias_until > \\$2 AND segment_id <> \\$3 \\)").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(1)
+				m.ExpectQuery("INSERT INTO segments").
+					WithArgs(args.segment.Name, args.segment.Rule, nil, nil, args.segment.StartsAt, args.segment.EndsAt,
+						nil, nil, args.segment.Tags, nil, nil, nil).WillReturnRows(rows)
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.created", args.segment.Name).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+			want:    1,
+		},
+		{
+			name: "OK_scheduled",
+			args: args{ctx: context.Background(),
+				segment: entity.Segment{Name: "Test_Segment", StartsAt: &startsAt, EndsAt: &endsAt},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments_alias WHERE name = \\$1 AND alias_until > \\$2 AND segment_id <> \\$3 \\)").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(1)
+				m.ExpectQuery("INSERT INTO segments").
+					WithArgs(args.segment.Name, nil, nil, nil, args.segment.StartsAt, args.segment.EndsAt,
+						nil, nil, args.segment.Tags, nil, nil, nil).WillReturnRows(rows)
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.created", args.segment.Name).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+			want:    1,
+		},
+		{
+			name: "OK_with_metadata",
+			args: args{ctx: context.Background(),
+				segment: entity.Segment{Name: "Test_Segment", Description: "Voice messages", OwnerTeam: "messenger",
+					Tags: []string{"voice"}, TicketUrl: "https://jira.avito.ru/browse/MSG-1", CreatedBy: "support@avito.ru"},
 			},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments_alias WHERE name = \\$1 AND alias_until > \\$2 AND segment_id <> \\$3 \\)").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
 				rows := pgxmock.NewRows([]string{"id"}).AddRow(1)
-				m.ExpectQuery("INSERT").
-					WithArgs(args.segment).WillReturnRows(rows)
+				m.ExpectQuery("INSERT INTO segments").
+					WithArgs(args.segment.Name, nil, nil, nil, args.segment.StartsAt, args.segment.EndsAt,
+						args.segment.Description, args.segment.OwnerTeam, args.segment.Tags, args.segment.TicketUrl,
+						args.segment.CreatedBy, args.segment.CreatedBy).WillReturnRows(rows)
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.created", args.segment.Name).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+			want:    1,
+		},
+		{
+			name: "OK_with_variants_and_percent",
+			args: args{ctx: context.Background(),
+				segment: entity.Segment{Name: "Test_Segment", Percent: 0.3,
+					Variants: []entity.Variant{{Name: "control", Weight: 50}, {Name: "test", Weight: 50}}},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectQuery("INSERT INTO segments").
+					WithArgs(args.segment.Name, nil, nil, nil, args.segment.StartsAt, args.segment.EndsAt,
+						nil, nil, args.segment.Tags, nil, nil, nil).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
+
+				m.ExpectExec("INSERT INTO segments_variant").
+					WithArgs(1, "control", 50, 1, "test", 50).
+					WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+				m.ExpectQuery("SELECT id, percent, salt, layer_id FROM segments").
+					WithArgs(args.segment.Name, "now()", "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id", "percent", "salt", "layer_id"}).AddRow(1, float32(0), "salt", nil))
+
+				m.ExpectExec("UPDATE segments").
+					WithArgs(args.segment.Percent, 1).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+				m.ExpectQuery("INSERT INTO users_segment (.+) RETURNING id").
+					WithArgs("salt", 0, "salt", 3000, 1, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(10)).AddRow(int64(11)))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.added", nil, []int64{10, 11}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.created", args.segment.Name).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
 			},
 			wantErr: false,
 			want:    1,
 		},
+		{
+			name: "Rollout_error",
+			args: args{ctx: context.Background(),
+				segment: entity.Segment{Name: "Test_Segment", Percent: 0.3},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectQuery("INSERT INTO segments").
+					WithArgs(args.segment.Name, nil, nil, nil, args.segment.StartsAt, args.segment.EndsAt,
+						nil, nil, args.segment.Tags, nil, nil, nil).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
+
+				m.ExpectQuery("SELECT id, percent, salt, layer_id FROM segments").
+					WithArgs(args.segment.Name, "now()", "now()").
+					WillReturnError(pgx.ErrTxClosed)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+			want:    0,
+		},
+		{
+			name: "Alias_taken",
+			args: args{ctx: context.Background(),
+				segment: entity.Segment{Name: "Test_Segment"},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+			want:    0,
+		},
 		{
 			name: "Segment_exist",
 			args: args{ctx: context.Background(),
-				segment: "Test_Segment",
+				segment: entity.Segment{Name: "Test_Segment"},
 			},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				m.ExpectQuery("INSERT").
-					WithArgs(args.segment).WillReturnError(pgx.ErrNoRows)
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments_alias WHERE name = \\$1 AND alias_until > \\$2 AND segment_id <> \\$3 \\)").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectQuery("INSERT INTO segments").
+					WithArgs(args.segment.Name, nil, nil, nil, args.segment.StartsAt, args.segment.EndsAt,
+						nil, nil, args.segment.Tags, nil, nil, nil).WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectQuery("SELECT deleted_at IS NOT NULL AND deleted_at <= now\\(\\) FROM segments WHERE name = \\$1").
+					WithArgs(args.segment.Name).
+					WillReturnRows(pgxmock.NewRows([]string{"deleted"}).AddRow(false))
+
+				m.ExpectRollback()
 			},
 			wantErr: false,
 			want:    0,
 		},
+		{
+			name: "Segment_deleted",
+			args: args{ctx: context.Background(),
+				segment: entity.Segment{Name: "Test_Segment"},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments_alias WHERE name = \\$1 AND alias_until > \\$2 AND segment_id <> \\$3 \\)").
+					WithArgs(args.segment.Name, "now()", 0).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectQuery("INSERT INTO segments").
+					WithArgs(args.segment.Name, nil, nil, nil, args.segment.StartsAt, args.segment.EndsAt,
+						nil, nil, args.segment.Tags, nil, nil, nil).WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectQuery("SELECT deleted_at IS NOT NULL").
+					WithArgs(args.segment.Name).
+					WillReturnRows(pgxmock.NewRows([]string{"deleted"}).AddRow(true))
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+			want:    0,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -72,6 +300,7 @@ func TestCreateSegment(t *testing.T) {
 			}
 
 			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
 		})
 	}
 }
@@ -98,19 +327,83 @@ func TestDeleteSegment(t *testing.T) {
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
 				m.ExpectBegin()
 
-				rows := pgxmock.NewRows([]string{"id"}).AddRow(1)
+				rows := pgxmock.NewRows([]string{"id", "name"}).AddRow(1, args.segment)
 				m.ExpectQuery("UPDATE").
-					WithArgs("now()", args.segment).WillReturnRows(rows)
+					WithArgs("now()", args.segment, args.segment, "now()").WillReturnRows(rows)
 
-				m.ExpectExec("UPDATE").
-					WithArgs("now()", 1, "now()").
-					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				m.ExpectQuery("DELETE FROM users_segment WHERE added_at > \\$1 AND segment_id = \\$2 RETURNING id").
+					WithArgs("now()", 1).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(11)))
+
+				m.ExpectExec("DELETE FROM events WHERE membership_id = ANY\\(\\$1\\) AND dispatched_at IS NULL").
+					WithArgs([]int64{11}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+				membershipRows := pgxmock.NewRows([]string{"id"}).AddRow(int64(10))
+				m.ExpectQuery("UPDATE users_segment").
+					WithArgs("now()", "now()", "segment_deleted", 1, "now()").
+					WillReturnRows(membershipRows)
+
+				m.ExpectExec("DELETE FROM events WHERE dispatched_at IS NULL AND membership_id IN").
+					WithArgs([]int64{10}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.removed", "segment_deleted", []int64{10}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.deleted", args.segment).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
 
 				m.ExpectCommit()
 
 			},
 			wantErr: false,
 		},
+		{
+			name: "OK_alias",
+			args: args{ctx: context.Background(),
+				segment: "Old_Segment",
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				rows := pgxmock.NewRows([]string{"id", "name"}).AddRow(1, "Test_Segment")
+				m.ExpectQuery("UPDATE segments SET deleted_at = \\$1 WHERE \\(name = \\$2 OR id = \\(SELECT segment_id FROM segments_alias").
+					WithArgs("now()", args.segment, args.segment, "now()").WillReturnRows(rows)
+
+				m.ExpectQuery("DELETE FROM users_segment").
+					WithArgs("now()", 1).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}))
+
+				m.ExpectQuery("UPDATE users_segment").
+					WithArgs("now()", "now()", "segment_deleted", 1, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.deleted", "Test_Segment").
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "Already_deleted",
+			args: args{ctx: context.Background(),
+				segment: "Test_Segment",
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE").
+					WithArgs("now()", args.segment, args.segment, "now()").WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -134,10 +427,14 @@ func TestDeleteSegment(t *testing.T) {
 	}
 }
 
-func TestCheckExistSegment(t *testing.T) {
+func TestRestoreSegment(t *testing.T) {
+	deletedAt := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+
 	type args struct {
-		ctx     context.Context
-		segment string
+		ctx      context.Context
+		segment  string
+		reenroll bool
+		actor    string
 	}
 
 	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
@@ -146,36 +443,95 @@ func TestCheckExistSegment(t *testing.T) {
 		name         string
 		args         args
 		mockBehavior MockBehavior
-		want         bool
-		wantErr      bool
+		want         int
+		wantErr      error
 	}{
 		{
-			name: "OK_false",
-			args: args{ctx: context.Background(),
-				segment: "Test_Segment",
+			name: "OK_reenroll",
+			args: args{ctx: context.Background(), segment: "Test_Segment", reenroll: true, actor: "support@avito.ru"},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments AS s SET deleted_at = \\$1, updated_at = \\$2, updated_by = \\$3 "+
+					"FROM segments AS old WHERE old.id = s.id AND s.name = \\$4 AND s.deleted_at <= \\$5 "+
+					"RETURNING s.id, old.deleted_at").
+					WithArgs(nil, "now()", args.actor, args.segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id", "deleted_at"}).AddRow(1, deletedAt))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM purge_jobs WHERE status IN \\(\\$1,\\$2\\) "+
+					"AND \\(segment_id = \\$3 OR \\(type = \\$4 AND cutoff > \\$5\\)\\) \\)").
+					WithArgs("pending", "running", 1, "retention", deletedAt).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectQuery("INSERT INTO users_segment (.+) SELECT DISTINCT ON \\(us.user_id\\) (.+) AND us.user_id > 0").
+					WithArgs("restore", args.actor, 1, "segment_deleted", deletedAt).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(20)).AddRow(int64(21)))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.added", nil, []int64{20, 21}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.restored", args.segment).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
 			},
+			want: 2,
+		},
+		{
+			name: "OK_without_reenroll",
+			args: args{ctx: context.Background(), segment: "Test_Segment"},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				rows := pgxmock.NewRows([]string{"exists"}).AddRow(false)
-				m.ExpectQuery("SELECT").
-					WithArgs(args.segment, "now()").WillReturnRows(rows)
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments").
+					WithArgs(nil, "now()", nil, args.segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id", "deleted_at"}).AddRow(1, deletedAt))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM purge_jobs").
+					WithArgs("pending", "running", 1, "retention", deletedAt).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("segment.restored", args.segment).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
 			},
-			wantErr: false,
-			want:    false,
+			want: 0,
 		},
 		{
-			name: "OK_true",
-			args: args{ctx: context.Background(),
-				segment: "Test_Segment",
+			name: "Segment_not_deleted",
+			args: args{ctx: context.Background(), segment: "Test_Segment"},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments").
+					WithArgs(nil, "now()", nil, args.segment, "now()").
+					WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectRollback()
 			},
+			wantErr: apperror.ErrNoDeletedSegment,
+		},
+		{
+			name: "Segment_purging",
+			args: args{ctx: context.Background(), segment: "Test_Segment", reenroll: true},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				m.QueryRow(args.ctx, "INSERT INTO segments (name) VALUES ($1)", args.segment)
+				m.ExpectBegin()
 
-				rows := pgxmock.NewRows([]string{"exists"}).AddRow(true)
-				m.ExpectQuery("SELECT").
-					WithArgs(args.segment, "now()").WillReturnRows(rows)
+				m.ExpectQuery("UPDATE segments").
+					WithArgs(nil, "now()", nil, args.segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id", "deleted_at"}).AddRow(1, deletedAt))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM purge_jobs").
+					WithArgs("pending", "running", 1, "retention", deletedAt).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+
+				m.ExpectRollback()
 			},
-			wantErr: false,
-			want:    true,
+			wantErr: apperror.ErrSegmentPurging,
 		},
 	}
 	for _, tc := range testCases {
@@ -189,24 +545,24 @@ func TestCheckExistSegment(t *testing.T) {
 				Pool:    poolMock,
 			}
 			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
-			got, err := segmentRepoMock.CheckExistSegment(tc.args.ctx, tc.args.segment)
-
-			if tc.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-			}
+			got, err := segmentRepoMock.RestoreSegment(tc.args.ctx, tc.args.segment, tc.args.reenroll, tc.args.actor)
 
+			assert.ErrorIs(t, err, tc.wantErr)
 			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
 		})
 	}
 }
 
-func TestRandomUserToSegment(t *testing.T) {
+func TestRenameSegment(t *testing.T) {
+	aliasUntil := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	type args struct {
-		ctx       context.Context
-		segmentId int
-		percent   float32
+		ctx        context.Context
+		segment    string
+		newName    string
+		aliasUntil time.Time
+		actor      string
 	}
 
 	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
@@ -215,20 +571,82 @@ func TestRandomUserToSegment(t *testing.T) {
 		name         string
 		args         args
 		mockBehavior MockBehavior
-		wantErr      bool
+		wantErr      error
 	}{
 		{
 			name: "OK",
-			args: args{ctx: context.Background(),
-				segmentId: 1,
-				percent:   0.5,
+			args: args{ctx: context.Background(), segment: "AVITO_VOICE_MESSAGES", newName: "VOICE_MESSAGES_V2",
+				aliasUntil: aliasUntil, actor: "support@avito.ru"},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT id FROM segments WHERE name = \\$1 (.+) FOR UPDATE").
+					WithArgs(args.segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments WHERE name = \\$1 \\)").
+					WithArgs(args.newName).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments_alias").
+					WithArgs(args.newName, "now()", 1).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectExec("UPDATE segments SET name = \\$1, updated_at = \\$2, updated_by = \\$3 WHERE id = \\$4").
+					WithArgs(args.newName, "now()", args.actor, 1).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+				m.ExpectExec("UPDATE segments_alias SET alias_until = \\$1 WHERE segment_id = \\$2 AND name = \\$3").
+					WithArgs("now()", 1, args.newName, "now()").
+					WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+				m.ExpectExec("INSERT INTO segments_alias \\(segment_id,name,alias_until\\)").
+					WithArgs(1, args.segment, args.aliasUntil).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectExec("INSERT INTO events \\(type,segment,reason\\)").
+					WithArgs("segment.renamed", args.newName, args.segment).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+		},
+		{
+			name: "Name_taken",
+			args: args{ctx: context.Background(), segment: "AVITO_VOICE_MESSAGES", newName: "AVITO_PERFORMANCE_VAS",
+				aliasUntil: aliasUntil},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT id FROM segments").
+					WithArgs(args.segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments WHERE").
+					WithArgs(args.newName).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments_alias").
+					WithArgs(args.newName, "now()", 1).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				m.ExpectRollback()
 			},
+			wantErr: apperror.ErrSegmentNameTaken,
+		},
+		{
+			name: "No_segment",
+			args: args{ctx: context.Background(), segment: "UNKNOWN", newName: "VOICE_MESSAGES_V2", aliasUntil: aliasUntil},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				rows := pgxmock.NewResult("INSERT", 0)
-				m.ExpectExec("INSERT").
-					WithArgs(args.percent).WillReturnResult(rows)
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT id FROM segments").
+					WithArgs(args.segment, "now()").
+					WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectRollback()
 			},
-			wantErr: false,
+			wantErr: apperror.ErrNoSegment,
 		},
 	}
 	for _, tc := range testCases {
@@ -242,13 +660,758 @@ func TestRandomUserToSegment(t *testing.T) {
 				Pool:    poolMock,
 			}
 			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
-			err := segmentRepoMock.RandomUserToSegment(tc.args.ctx, tc.args.segmentId, tc.args.percent)
+			err := segmentRepoMock.RenameSegment(tc.args.ctx, tc.args.segment, tc.args.newName, tc.args.aliasUntil, tc.args.actor)
 
-			if tc.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-			}
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateSegment(t *testing.T) {
+	rule := `platform == "ios"`
+	noRule := ""
+	description := "Голосовые сообщения в чатах"
+
+	type args struct {
+		ctx context.Context
+		req entity.SegmentUpdateRequest
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      bool
+	}{
+		{
+			name: "OK_percent_increase",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Percent: &[]float32{0.3}[0]},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments SET updated_at = \\$1, updated_by = \\$2 WHERE \\(name = \\$3 OR id = \\(SELECT segment_id FROM segments_alias (.+) RETURNING name, layer_id IS NOT NULL").
+					WithArgs("now()", nil, args.req.Segment, args.req.Segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"name", "layered"}).AddRow(args.req.Segment, false))
+
+				rows := pgxmock.NewRows([]string{"id", "percent", "salt", "layer_id"}).AddRow(1, float32(0.1), "salt", nil)
+				m.ExpectQuery("SELECT").
+					WithArgs(args.req.Segment, "now()", "now()").WillReturnRows(rows)
+
+				m.ExpectExec("UPDATE segments").
+					WithArgs(*args.req.Percent, 1).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+				m.ExpectQuery("INSERT INTO users_segment (.+) FROM users AS u WHERE u.id > 0 AND (.+) RETURNING id").
+					WithArgs("salt", 1000, "salt", 3000, 1, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(10)))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.added", nil, []int64{10}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "OK_percent_increase_in_layer",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Percent: &[]float32{0.3}[0]},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments SET updated_at = \\$1, updated_by = \\$2 WHERE \\(name = \\$3 OR id = \\(SELECT segment_id FROM segments_alias (.+) RETURNING name, layer_id IS NOT NULL").
+					WithArgs("now()", nil, args.req.Segment, args.req.Segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"name", "layered"}).AddRow(args.req.Segment, false))
+
+				layerId := 2
+				rows := pgxmock.NewRows([]string{"id", "percent", "salt", "layer_id"}).AddRow(1, float32(0), "salt", &layerId)
+				m.ExpectQuery("SELECT").
+					WithArgs(args.req.Segment, "now()", "now()").WillReturnRows(rows)
+
+				m.ExpectExec("UPDATE segments").
+					WithArgs(*args.req.Percent, 1).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+				m.ExpectQuery("INSERT INTO users_segment .+ s.layer_id").
+					WithArgs("salt", 0, "salt", 3000, 1, "now()", layerId, 1, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "OK_percent_decrease",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Percent: &[]float32{0.1}[0]},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments SET updated_at = \\$1, updated_by = \\$2 WHERE \\(name = \\$3 OR id = \\(SELECT segment_id FROM segments_alias (.+) RETURNING name, layer_id IS NOT NULL").
+					WithArgs("now()", nil, args.req.Segment, args.req.Segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"name", "layered"}).AddRow(args.req.Segment, false))
+
+				rows := pgxmock.NewRows([]string{"id", "percent", "salt", "layer_id"}).AddRow(1, float32(0.3), "salt", nil)
+				m.ExpectQuery("SELECT").
+					WithArgs(args.req.Segment, "now()", "now()").WillReturnRows(rows)
+
+				m.ExpectExec("UPDATE segments").
+					WithArgs(*args.req.Percent, 1).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+				m.ExpectQuery("DELETE FROM users_segment WHERE added_at > \\$1 AND \\(segment_id = \\$2 AND source = \\$3 "+
+					"AND segment_bucket\\(\\$4, user_id\\) >= \\$5\\) RETURNING id").
+					WithArgs("now()", 1, "rollout", "salt", 1000).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}))
+
+				m.ExpectQuery("UPDATE users_segment (.+) RETURNING id").
+					WithArgs("now()", "now()", "rollout", 1, "rollout", "salt", 1000, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(10)))
+
+				m.ExpectExec("DELETE FROM events WHERE dispatched_at IS NULL AND membership_id IN").
+					WithArgs([]int64{10}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.removed", "rollout", []int64{10}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "Error_no_segment",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Percent: &[]float32{0.1}[0]},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments").
+					WithArgs("now()", nil, args.req.Segment, args.req.Segment, "now()").
+					WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+		{
+			name: "OK_rule",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Rule: &rule},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments SET updated_at = \\$1, updated_by = \\$2, rule = \\$3 WHERE \\(name = \\$4 OR id = \\(SELECT segment_id FROM segments_alias (.+) RETURNING name, layer_id IS NOT NULL").
+					WithArgs("now()", nil, rule, args.req.Segment, args.req.Segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"name", "layered"}).AddRow(args.req.Segment, false))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "OK_remove_rule_in_layer",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Rule: &noRule},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments").
+					WithArgs("now()", nil, nil, args.req.Segment, args.req.Segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"name", "layered"}).AddRow(args.req.Segment, true))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "Error_rule_in_layer",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Rule: &rule},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments").
+					WithArgs("now()", nil, rule, args.req.Segment, args.req.Segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"name", "layered"}).AddRow(args.req.Segment, true))
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+		{
+			name: "Error_no_segment_rule",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Rule: &rule},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments").
+					WithArgs("now()", nil, rule, args.req.Segment, args.req.Segment, "now()").
+					WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+		{
+			name: "OK_all_fields",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{
+					Segment:     "Test_Segment",
+					Percent:     &[]float32{0.1}[0],
+					Rule:        &noRule,
+					Payload:     json.RawMessage(`{"limit":10}`),
+					Description: &description,
+					Actor:       "support@avito.ru",
+				},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments SET updated_at = \\$1, updated_by = \\$2, description = \\$3, rule = \\$4, "+
+					"payload = NULLIF\\(\\$5::jsonb, 'null'::jsonb\\) WHERE \\(name = \\$6 OR id = \\(SELECT segment_id FROM segments_alias (.+) RETURNING name, layer_id IS NOT NULL").
+					WithArgs("now()", args.req.Actor, description, nil, `{"limit":10}`, args.req.Segment, args.req.Segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"name", "layered"}).AddRow(args.req.Segment, false))
+
+				rows := pgxmock.NewRows([]string{"id", "percent", "salt", "layer_id"}).AddRow(1, float32(0.1), "salt", nil)
+				m.ExpectQuery("SELECT").
+					WithArgs(args.req.Segment, "now()", "now()").WillReturnRows(rows)
+
+				m.ExpectExec("UPDATE segments").
+					WithArgs(*args.req.Percent, 1).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "Error_percent_rolls_back_rule",
+			args: args{ctx: context.Background(),
+				req: entity.SegmentUpdateRequest{Segment: "Test_Segment", Percent: &[]float32{0.3}[0], Rule: &rule},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE segments SET updated_at = \\$1, updated_by = \\$2, rule = \\$3").
+					WithArgs("now()", nil, rule, args.req.Segment, args.req.Segment, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"name", "layered"}).AddRow(args.req.Segment, false))
+
+				m.ExpectQuery("SELECT").
+					WithArgs(args.req.Segment, "now()", "now()").WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
+			err := segmentRepoMock.UpdateSegment(tc.args.ctx, tc.args.req)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetActiveRuleSegments(t *testing.T) {
+	type args struct {
+		ctx context.Context
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         []entity.Segment
+		wantErr      bool
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background()},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id", "name", "rule", "payload"}).
+					AddRow(1, "Test_Segment", `platform == "ios"`, []byte(`{"banner":"v2"}`))
+				m.ExpectQuery("SELECT").
+					WithArgs("now()", "now()", "now()").WillReturnRows(rows)
+			},
+			wantErr: false,
+			want: []entity.Segment{{
+				Id:      1,
+				Name:    "Test_Segment",
+				Rule:    `platform == "ios"`,
+				Payload: json.RawMessage(`{"banner":"v2"}`),
+			}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
+			got, err := segmentRepoMock.GetActiveRuleSegments(tc.args.ctx)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestExpireSegments(t *testing.T) {
+	type args struct {
+		ctx context.Context
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int64
+		wantErr      bool
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background()},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(int64(10)).AddRow(int64(11)).AddRow(int64(12))
+				m.ExpectQuery("UPDATE users_segment AS us SET left_at = s.ends_at").
+					WithArgs("now()", "segment_ended", "now()").
+					WillReturnRows(rows)
+
+				m.ExpectExec("DELETE FROM events WHERE dispatched_at IS NULL AND membership_id IN").
+					WithArgs([]int64{10, 11, 12}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.removed", "segment_ended", []int64{10, 11, 12}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 3))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+			want:    3,
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background()},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("UPDATE").
+					WithArgs("now()", "segment_ended", "now()").
+					WillReturnError(pgx.ErrTxClosed)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+			want:    0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
+			got, err := segmentRepoMock.ExpireSegments(tc.args.ctx)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetUsersVariants(t *testing.T) {
+	type args struct {
+		ctx        context.Context
+		userIds    []int
+		segmentIds []int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      bool
+		want         map[int]map[int]string
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(),
+				userIds:    []int{1, 2},
+				segmentIds: []int{5, 6},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"user_id", "segment_id", "variant"}).
+					AddRow(1, 5, "control").
+					AddRow(1, 6, "").
+					AddRow(2, 5, "treatment").
+					AddRow(2, 6, "")
+				m.ExpectQuery("SELECT (.+) FROM segments AS s CROSS JOIN unnest\\(\\$1::integer\\[\\]\\) AS u\\(id\\) WHERE s.id = ANY\\(\\$2\\)").
+					WithArgs(args.userIds, args.segmentIds).WillReturnRows(rows)
+			},
+			wantErr: false,
+			want: map[int]map[int]string{
+				1: {5: "control", 6: ""},
+				2: {5: "treatment", 6: ""},
+			},
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(),
+				userIds:    []int{1},
+				segmentIds: []int{5},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("SELECT").
+					WithArgs(args.userIds, args.segmentIds).WillReturnError(pgx.ErrTxClosed)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
+			got, err := segmentRepoMock.GetUsersVariants(tc.args.ctx, tc.args.userIds, tc.args.segmentIds)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetSegmentMembersAt(t *testing.T) {
+	at := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	addedAt := time.Date(2023, 8, 1, 10, 0, 0, 0, time.UTC)
+	leftAt := time.Date(2023, 9, 2, 10, 0, 0, 0, time.UTC)
+
+	type args struct {
+		ctx         context.Context
+		segmentId   int
+		at          time.Time
+		membership  string
+		afterUserId int
+		limit       int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         []entity.SegmentMember
+		wantErr      bool
+	}{
+		{
+			name: "OK_first_page",
+			args: args{ctx: context.Background(),
+				segmentId: 1,
+				at:        at,
+				limit:     101,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"user_id", "variant", "added_at", "left_at"}).
+					AddRow(1000, "control", addedAt, &leftAt).
+					AddRow(1001, "", addedAt, nil)
+				m.ExpectQuery("SELECT us.user_id, COALESCE\\(us.variant, ''\\), us.added_at, us.left_at FROM users_segment AS us "+
+					"JOIN segments AS s ON s.id = us.segment_id WHERE us.segment_id = \\$1 AND us.added_at <= \\$2 "+
+					"AND \\(us.left_at IS NULL OR us.left_at > \\$3\\) "+
+					"AND \\(\\(s.deleted_at IS NULL OR s.deleted_at > \\$4\\) AND s.starts_at <= \\$5 AND \\(s.ends_at IS NULL OR s.ends_at > \\$6\\)\\) "+
+					"AND us.user_id > \\$7 ORDER BY us.user_id LIMIT 101").
+					WithArgs(args.segmentId, at, at, at, at, at, 0).
+					WillReturnRows(rows)
+			},
+			want: []entity.SegmentMember{
+				{UserId: 1000, Variant: "control", AddedAt: addedAt, LeftAt: &leftAt},
+				{UserId: 1001, AddedAt: addedAt},
+			},
+		},
+		{
+			name: "OK_ttl_next_page",
+			args: args{ctx: context.Background(),
+				segmentId:   1,
+				at:          at,
+				membership:  entity.SegmentMembershipTtl,
+				afterUserId: 1000,
+				limit:       2,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("AND us.left_at IS NOT NULL AND us.user_id > \\$7 ORDER BY us.user_id LIMIT 2").
+					WithArgs(args.segmentId, at, at, at, at, at, args.afterUserId).
+					WillReturnRows(pgxmock.NewRows([]string{"user_id", "variant", "added_at", "left_at"}))
+			},
+			want: nil,
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(),
+				segmentId: 1,
+				at:        at,
+				limit:     101,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("SELECT").
+					WithArgs(args.segmentId, at, at, at, at, at, 0).
+					WillReturnError(pgx.ErrTxClosed)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
+			got, err := segmentRepoMock.GetSegmentMembersAt(tc.args.ctx, tc.args.segmentId, tc.args.at,
+				tc.args.membership, tc.args.afterUserId, tc.args.limit)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetSegmentId(t *testing.T) {
+	type args struct {
+		ctx     context.Context
+		segment string
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), segment: "AVITO_VOICE_MESSAGES"},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("SELECT id FROM segments WHERE \\(name = \\$1 OR id = \\(SELECT segment_id FROM segments_alias").
+					WithArgs(args.segment, args.segment).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
+			},
+			want: 1,
+		},
+		{
+			name: "No_segment",
+			args: args{ctx: context.Background(), segment: "UNKNOWN"},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("SELECT id FROM segments").
+					WithArgs(args.segment, args.segment).
+					WillReturnError(pgx.ErrNoRows)
+			},
+			wantErr: apperror.ErrNoSegment,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
+			got, err := segmentRepoMock.GetSegmentId(tc.args.ctx, tc.args.segment)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetSegments(t *testing.T) {
+	startsAt := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	deletedAt := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	type args struct {
+		ctx   context.Context
+		req   entity.SegmentListRequest
+		limit int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	columns := []string{"name", "description", "owner_team", "tags", "ticket_url", "rule", "layer", "percent",
+		"status", "members", "starts_at", "ends_at", "created_at", "created_by", "updated_at", "updated_by", "deleted_at"}
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         []entity.SegmentInfo
+		wantErr      bool
+	}{
+		{
+			name: "OK_prefix_tags_active",
+			args: args{ctx: context.Background(),
+				req:   entity.SegmentListRequest{Prefix: "AVITO_", Tags: []string{"voice"}, Status: entity.SegmentStatusActive, Cursor: "AVITO_A"},
+				limit: 101,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows(columns).
+					AddRow("AVITO_VOICE_MESSAGES", "Voice messages", "messenger", []string{"voice"}, "", "", "", float32(0.5),
+						"active", 1500, startsAt, nil, startsAt, "support@avito.ru", startsAt, "", nil)
+				m.ExpectQuery("FROM segments AS s LEFT JOIN layers AS l ON l.id = s.layer_id "+
+					"WHERE s.name LIKE \\$1 AND s.tags @> \\$2::text\\[\\] AND s.name > \\$3 "+
+					"AND \\(\\(s.deleted_at IS NULL OR s.deleted_at > \\$4\\) AND s.starts_at <= \\$5 AND \\(s.ends_at IS NULL OR s.ends_at > \\$6\\)\\) "+
+					"ORDER BY s.name LIMIT 101").
+					WithArgs(`AVITO\_%`, args.req.Tags, args.req.Cursor, "now()", "now()", "now()").
+					WillReturnRows(rows)
+			},
+			want: []entity.SegmentInfo{
+				{Name: "AVITO_VOICE_MESSAGES", Description: "Voice messages", OwnerTeam: "messenger", Tags: []string{"voice"},
+					Percent: 0.5, Status: "active", Members: 1500, StartsAt: startsAt, CreatedAt: startsAt,
+					CreatedBy: "support@avito.ru", UpdatedAt: startsAt},
+			},
+		},
+		{
+			name: "OK_deleted",
+			args: args{ctx: context.Background(),
+				req:   entity.SegmentListRequest{Status: entity.SegmentStatusDeleted},
+				limit: 2,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows(columns).
+					AddRow("AVITO_OLD", "", "", []string{}, "", "", "", float32(0),
+						"deleted", 0, startsAt, nil, startsAt, "", deletedAt, "", &deletedAt)
+				m.ExpectQuery("WHERE s.deleted_at <= \\$1 ORDER BY s.name LIMIT 2").
+					WithArgs("now()").
+					WillReturnRows(rows)
+			},
+			want: []entity.SegmentInfo{
+				{Name: "AVITO_OLD", Tags: []string{}, Status: "deleted", StartsAt: startsAt, CreatedAt: startsAt,
+					UpdatedAt: deletedAt, DeletedAt: &deletedAt},
+			},
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(),
+				req:   entity.SegmentListRequest{},
+				limit: 101,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("SELECT").
+					WillReturnError(pgx.ErrTxClosed)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			segmentRepoMock := pgdb.NewSegmentRepo(postgresMock)
+			got, err := segmentRepoMock.GetSegments(tc.args.ctx, tc.args.req, tc.args.limit)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
 		})
 	}
 }
@@ -2,6 +2,7 @@ package pgdb
 
 import (
 	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
 	"avito-internship/internal/utils"
 	"avito-internship/pkg/database/postgresdb"
 	"context"
@@ -9,6 +10,8 @@ import (
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
+	"strconv"
+	"time"
 )
 
 type UserRepo struct {
@@ -19,7 +22,7 @@ func NewUserRepo(pg *postgresdb.Postgres) *UserRepo {
 	return &UserRepo{pg}
 }
 
-func (r *UserRepo) AddSegmentToUser(ctx context.Context, id int, segments []int, ttl int) error {
+func (r *UserRepo) AddSegmentToUser(ctx context.Context, id int, segments []int, startAt, endAt *time.Time, actor string) error {
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
 		return err
@@ -33,23 +36,53 @@ func (r *UserRepo) AddSegmentToUser(ctx context.Context, id int, segments []int,
 		Suffix("ON CONFLICT DO NOTHING").
 		ToSql()
 
-	_, err = tx.Exec(ctx, sql, args...)
+	tag, err := tx.Exec(ctx, sql, args...)
 	if err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
 			return err
 		}
 	}
 
-	sql, args, _ = r.Builder.
+	if tag.RowsAffected() > 0 {
+		err = enrollNewUsers(ctx, r.Builder, tx, []int{id})
+		if err != nil {
+			return err
+		}
+	}
+
+	var start, end any = "now()", nil
+	if startAt != nil {
+		start = *startAt
+	}
+	if endAt != nil {
+		end = *endAt
+	}
+
+	// Проверка выполняется после добавления нового пользователя в сегменты по проценту,
+	// чтобы учесть сегменты слоёв, в которые он попал автоматически
+	conflict, err := layerConflict(ctx, r.Builder, tx, id, segments, start, end)
+	if err != nil {
+		return err
+	}
+	if conflict {
+		return apperror.ErrSegmentConflict
+	}
+
+	// Пропускаются сегменты, членство в которых пересекается с запрошенным периодом
+	sqlQuery := r.Builder.
 		Select("segment_id").
 		From("users_segment").
 		Where("user_id = ?", id).
 		Where(sq.Eq{"segment_id": segments}).
 		Where(sq.Or{
 			sq.Eq{"left_at": nil},
-			sq.Gt{"left_at": "now()"},
-		}).
-		ToSql()
+			sq.Gt{"left_at": start},
+		})
+	if endAt != nil {
+		sqlQuery = sqlQuery.Where(sq.Lt{"added_at": end})
+	}
+
+	sql, args, _ = sqlQuery.ToSql()
 
 	rows, err := tx.Query(ctx, sql, args...)
 	if err != nil {
@@ -72,23 +105,30 @@ func (r *UserRepo) AddSegmentToUser(ctx context.Context, id int, segments []int,
 	}
 
 	idToInsert := utils.UniqueValues(segments)
+	if len(idToInsert) == 0 {
+		return tx.Commit(ctx)
+	}
 
-	sqlQuery := r.Builder.Insert("users_segment")
-	if ttl > 0 {
-		ttlInSql := sq.Expr(fmt.Sprintf("now() + INTERVAL '%d hours'", ttl))
-		sqlQuery = sqlQuery.Columns("user_id", "segment_id", "left_at")
-		for _, segmentId := range idToInsert {
-			sqlQuery = sqlQuery.Values(id, segmentId, ttlInSql)
-		}
-	} else {
-		sqlQuery = sqlQuery.Columns("user_id", "segment_id")
-		for _, segmentId := range idToInsert {
-			sqlQuery = sqlQuery.Values(id, segmentId)
-		}
+	insertQuery := r.Builder.
+		Insert("users_segment").
+		Columns("user_id", "segment_id", "variant", "added_at", "left_at", "added_by")
+	for _, segmentId := range idToInsert {
+		insertQuery = insertQuery.Values(id, segmentId, sq.Expr("segment_variant(?, ?)", segmentId, id), start, end,
+			nullIfEmpty(actor))
 	}
 
-	sql, args, _ = sqlQuery.ToSql()
-	_, err = tx.Exec(ctx, sql, args...)
+	sql, args, _ = insertQuery.Suffix("RETURNING id").ToSql()
+	insertedRows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	membershipIds, err := scanIds(insertedRows)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueMembershipEvents(ctx, r.Builder, tx, entity.EventMembershipAdded, "", membershipIds)
 	if err != nil {
 		return err
 	}
@@ -101,19 +141,53 @@ func (r *UserRepo) AddSegmentToUser(ctx context.Context, id int, segments []int,
 	return nil
 }
 
-func (r *UserRepo) RemoveSegmentFromUser(ctx context.Context, id int, segments []int) error {
+func (r *UserRepo) RemoveSegmentFromUser(ctx context.Context, id int, segments []int, actor string) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	err = cancelScheduledMemberships(ctx, r.Builder, tx, sq.And{
+		sq.Eq{"user_id": id},
+		sq.Eq{"segment_id": segments},
+	})
+	if err != nil {
+		return err
+	}
+
 	sql, args, _ := r.Builder.
 		Update("users_segment").
 		Set("left_at", "now()").
+		Set("finalized_at", "now()").
+		Set("removal_reason", entity.RemovalReasonManual).
+		Set("removed_by", nullIfEmpty(actor)).
 		Where(sq.Or{
 			sq.Eq{"left_at": nil},
 			sq.Gt{"left_at": "now()"},
 		}).
 		Where("user_id = ?", id).
 		Where(sq.Eq{"segment_id": segments}).
+		Suffix("RETURNING id").
 		ToSql()
 
-	_, err := r.Pool.Exec(ctx, sql, args...)
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	membershipIds, err := scanIds(rows)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueMembershipEvents(ctx, r.Builder, tx, entity.EventMembershipRemoved,
+		entity.RemovalReasonManual, membershipIds)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
@@ -121,16 +195,134 @@ func (r *UserRepo) RemoveSegmentFromUser(ctx context.Context, id int, segments [
 	return nil
 }
 
-func (r *UserRepo) GetActiveSegmentFromUser(ctx context.Context, id int) ([]string, error) {
+// cancelScheduledMemberships удаляет ещё не начавшееся членство, выбранное условием where, без записи
+// в историю вместе с неопубликованными событиями добавления. Вызывается в транзакции исключения пользователей
+// перед закрытием начавшегося членства, иначе у отменённого членства left_at оказался бы раньше added_at.
+func cancelScheduledMemberships(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx, where sq.Sqlizer) error {
+	sql, args, _ := builder.
+		Delete("users_segment").
+		Where(sq.Gt{"added_at": "now()"}).
+		Where(where).
+		Suffix("RETURNING id").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	cancelledIds, err := scanIds(rows)
+	if err != nil {
+		return err
+	}
+
+	return cancelMembershipEvents(ctx, builder, tx, cancelledIds)
+}
+
+func (r *UserRepo) FinalizeExpiredMemberships(ctx context.Context) (int64, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Update("users_segment AS us").
+		Set("finalized_at", "now()").
+		Set("removal_reason", entity.RemovalReasonExpired).
+		From("segments AS s").
+		Where("s.id = us.segment_id").
+		Where(sq.Eq{"us.finalized_at": nil}).
+		Where(sq.NotEq{"us.left_at": nil}).
+		Where(sq.LtOrEq{"us.left_at": "now()"}).
+		Suffix("RETURNING us.id").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	membershipIds, err := scanIds(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	err = enqueueMembershipEvents(ctx, r.Builder, tx, entity.EventMembershipRemoved,
+		entity.RemovalReasonExpired, membershipIds)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(membershipIds)), nil
+}
+
+func (r *UserRepo) GetActiveSegmentFromUser(ctx context.Context, id int) ([]entity.UserSegment, error) {
+	return r.getSegmentsFromUser(ctx, id, "now()")
+}
+
+func (r *UserRepo) GetSegmentsFromUserAt(ctx context.Context, id int, at time.Time) ([]entity.UserSegment, error) {
+	return r.getSegmentsFromUser(ctx, id, at)
+}
+
+// getSegmentsFromUser возвращает сегменты, в которых пользователь состоял в момент at:
+// added_at <= at < left_at, и сегмент был активен в этот момент
+func (r *UserRepo) getSegmentsFromUser(ctx context.Context, id int, at any) ([]entity.UserSegment, error) {
 	sql, args, _ := r.Builder.
-		Select("s.name").
+		Select("s.id", "s.name", "COALESCE(us.variant, '')", "s.payload").
 		From("segments AS s").
 		Join("users_segment AS us ON s.id = us.segment_id").
 		Where(sq.Or{
 			sq.Eq{"us.left_at": nil},
-			sq.Gt{"us.left_at": "now()"},
+			sq.Gt{"us.left_at": at},
 		}).
+		Where(sq.LtOrEq{"us.added_at": at}).
+		Where(activeSegmentAt("s.", at)).
 		Where(sq.Eq{"us.user_id": id}).
+		OrderBy("s.id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []entity.UserSegment
+	for rows.Next() {
+		var segment entity.UserSegment
+		err = rows.Scan(&segment.SegmentId, &segment.Segment, &segment.Variant, (*[]byte)(&segment.Payload))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
+
+func (r *UserRepo) GetActiveSegmentsFromUsers(ctx context.Context, ids []int) (map[int][]entity.UserSegment, error) {
+	sql, args, _ := r.Builder.
+		Select("us.user_id", "s.id", "s.name", "COALESCE(us.variant, '')", "s.payload").
+		From("segments AS s").
+		Join("users_segment AS us ON s.id = us.segment_id").
+		Where(sq.Or{
+			sq.Eq{"us.left_at": nil},
+			sq.Gt{"us.left_at": "now()"},
+		}).
+		Where(sq.LtOrEq{"us.added_at": "now()"}).
+		Where(activeSegment("s.")).
+		Where("us.user_id = ANY(?)", ids).
+		OrderBy("us.user_id", "s.id").
 		ToSql()
 
 	rows, err := r.Pool.Query(ctx, sql, args...)
@@ -139,21 +331,24 @@ func (r *UserRepo) GetActiveSegmentFromUser(ctx context.Context, id int) ([]stri
 	}
 	defer rows.Close()
 
-	var segmentNames []string
+	segments := make(map[int][]entity.UserSegment)
 	for rows.Next() {
-		var segment string
-		err = rows.Scan(&segment)
+		var (
+			userId  int
+			segment entity.UserSegment
+		)
+		err = rows.Scan(&userId, &segment.SegmentId, &segment.Segment, &segment.Variant, (*[]byte)(&segment.Payload))
 		if err != nil {
 			return nil, err
 		}
-		segmentNames = append(segmentNames, segment)
+		segments[userId] = append(segments[userId], segment)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return segmentNames, nil
+	return segments, nil
 }
 
 func (r *UserRepo) CheckExistUser(ctx context.Context, id int) error {
@@ -168,25 +363,153 @@ func (r *UserRepo) CheckExistUser(ctx context.Context, id int) error {
 	var exist bool
 	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&exist)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return apperror.ErrNoUser
-		}
-
 		return err
 	}
 
+	if !exist {
+		return apperror.ErrNoUser
+	}
+
 	return nil
 }
 
-func (r *UserRepo) GetActiveSegmentsIdByName(ctx context.Context, segments []string) ([]int, error) {
+func (r *UserRepo) GetActiveSegmentsIdByName(ctx context.Context, segments []string) ([]int, []entity.SegmentAlias, []string, error) {
 	sql, args, _ := r.Builder.
-		Select("id").
-		From("segments").
-		Where(sq.Eq{"name": segments}).
+		Select("s.id", "s.name", "COALESCE(a.name, '')", "a.alias_until").
+		From("segments AS s").
+		LeftJoin("segments_alias AS a ON a.segment_id = s.id AND a.name = ANY(?) AND a.alias_until > ?", segments, "now()").
 		Where(sq.Or{
-			sq.Eq{"deleted_at": nil},
-			sq.Gt{"deleted_at": "now()"},
+			sq.Eq{"s.name": segments},
+			sq.NotEq{"a.id": nil},
 		}).
+		Where(activeSegment("s.")).
+		OrderBy("s.id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+
+	var (
+		segmentsIds []int
+		aliases     []entity.SegmentAlias
+		found       = make(map[string]bool, len(segments))
+	)
+	for rows.Next() {
+		var (
+			segmentId  int
+			alias      entity.SegmentAlias
+			aliasUntil *time.Time
+		)
+		err = rows.Scan(&segmentId, &alias.Segment, &alias.Alias, &aliasUntil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		found[alias.Segment] = true
+		found[alias.Alias] = true
+
+		// Сегмент, найденный и по названию, и по псевдонимам, возвращается один раз
+		if len(segmentsIds) == 0 || segmentsIds[len(segmentsIds)-1] != segmentId {
+			segmentsIds = append(segmentsIds, segmentId)
+		}
+		if aliasUntil != nil {
+			alias.AliasUntil = *aliasUntil
+			aliases = append(aliases, alias)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Повторы в запросе и сегмент, названный и по названию, и по псевдониму, не считаются ненайденными
+	var missing []string
+	for _, segment := range segments {
+		if !found[segment] {
+			found[segment] = true
+			missing = append(missing, segment)
+		}
+	}
+
+	return segmentsIds, aliases, missing, nil
+}
+
+func (r *UserRepo) SetUserAttributes(ctx context.Context, id int, attributes map[string]any) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Insert("users").
+		Columns("id").
+		Values(id).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() > 0 {
+		err = enrollNewUsers(ctx, r.Builder, tx, []int{id})
+		if err != nil {
+			return err
+		}
+	}
+
+	sql, args, _ = r.Builder.
+		Insert("users_attributes").
+		Columns("user_id", "attributes").
+		Values(id, attributes).
+		Suffix("ON CONFLICT (user_id) DO UPDATE SET " +
+			"attributes = users_attributes.attributes || EXCLUDED.attributes, updated_at = now()").
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *UserRepo) GetUserAttributes(ctx context.Context, id int) (map[string]any, error) {
+	sql, args, _ := r.Builder.
+		Select("attributes").
+		From("users_attributes").
+		Where("user_id = ?", id).
+		ToSql()
+
+	attributes := map[string]any{}
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&attributes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return map[string]any{}, nil
+		}
+
+		return nil, err
+	}
+
+	return attributes, nil
+}
+
+func (r *UserRepo) GetUsersAttributes(ctx context.Context, ids []int) (map[int]map[string]any, error) {
+	// Наличие пользователя в результате означает, что он известен сервису
+	sql, args, _ := r.Builder.
+		Select("u.id", "COALESCE(a.attributes, '{}')").
+		From("users AS u").
+		LeftJoin("users_attributes AS a ON a.user_id = u.id").
+		Where("u.id = ANY(?)", ids).
 		ToSql()
 
 	rows, err := r.Pool.Query(ctx, sql, args...)
@@ -195,15 +518,656 @@ func (r *UserRepo) GetActiveSegmentsIdByName(ctx context.Context, segments []str
 	}
 	defer rows.Close()
 
-	var segmentsIds []int
+	attributes := make(map[int]map[string]any, len(ids))
 	for rows.Next() {
-		var segmentId int
-		err = rows.Scan(&segmentId)
+		var (
+			userId         int
+			userAttributes map[string]any
+		)
+		err = rows.Scan(&userId, &userAttributes)
 		if err != nil {
 			return nil, err
 		}
-		segmentsIds = append(segmentsIds, segmentId)
+		attributes[userId] = userAttributes
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attributes, nil
+}
+
+func (r *UserRepo) ImportSegmentsToUsers(ctx context.Context, importRows []entity.UserImportRow, dryRun bool) (int, []entity.UserImportRejectedRow, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Строки загружаются во временную таблицу, которая удаляется при завершении транзакции
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE import_memberships
+(
+    line       INTEGER NOT NULL,
+    user_id    INTEGER NOT NULL,
+    segment    VARCHAR NOT NULL,
+    ttl        INTEGER NOT NULL,
+    segment_id INTEGER DEFAULT NULL,
+    layer_id   INTEGER DEFAULT NULL,
+    reason     VARCHAR DEFAULT NULL
+) ON COMMIT DROP`)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"import_memberships"},
+		[]string{"line", "user_id", "segment", "ttl"},
+		pgx.CopyFromSlice(len(importRows), func(i int) ([]any, error) {
+			row := importRows[i]
+			return []any{row.Line, row.UserId, row.Segment, row.Ttl}, nil
+		}),
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sql, args, _ := r.Builder.
+		Update("import_memberships AS i").
+		Set("segment_id", sq.Expr("s.id")).
+		Set("layer_id", sq.Expr("s.layer_id")).
+		From("segments AS s").
+		Where("s.name = i.segment").
+		Where(activeSegment("s.")).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sql, args, _ = r.Builder.
+		Update("import_memberships").
+		Set("reason", entity.ImportReasonUnknownSegment).
+		Where(sq.Eq{"segment_id": nil}).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sql, args, _ = r.Builder.
+		Update("import_memberships AS i").
+		Set("reason", entity.ImportReasonDuplicate).
+		Where(sq.Eq{"i.reason": nil}).
+		Where(sq.Expr("EXISTS (?)", sq.
+			Select("1").
+			From("import_memberships AS d").
+			Where("d.user_id = i.user_id").
+			Where("d.segment_id = i.segment_id").
+			Where("d.line < i.line"))).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Новые пользователи попадают в сегменты с процентной раскаткой так же, как при добавлении через /user/add
+	sql, args, _ = r.Builder.
+		Insert("users").
+		Columns("id").
+		Select(sq.
+			Select("DISTINCT user_id").
+			From("import_memberships").
+			Where(sq.Eq{"reason": nil})).
+		Suffix("ON CONFLICT DO NOTHING RETURNING id").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	newUserIds, err := scanIds(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(newUserIds) > 0 {
+		ids := make([]int, 0, len(newUserIds))
+		for _, id := range newUserIds {
+			ids = append(ids, int(id))
+		}
+
+		err = enrollNewUsers(ctx, r.Builder, tx, ids)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	sql, args, _ = r.Builder.
+		Update("import_memberships AS i").
+		Set("reason", entity.ImportReasonAlreadyInSegment).
+		Where(sq.Eq{"i.reason": nil}).
+		Where(sq.Expr("EXISTS (?)", sq.
+			Select("1").
+			From("users_segment AS us").
+			Where("us.user_id = i.user_id").
+			Where("us.segment_id = i.segment_id").
+			Where(sq.Or{
+				sq.Eq{"us.left_at": nil},
+				sq.Gt{"us.left_at": "now()"},
+			}))).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Конфликт слоя: пользователь уже состоит в другом сегменте слоя
+	// или в файле выше есть строка с другим сегментом того же слоя для этого пользователя
+	sql, args, _ = r.Builder.
+		Update("import_memberships AS i").
+		Set("reason", entity.ImportReasonLayerConflict).
+		Where(sq.Eq{"i.reason": nil}).
+		Where(sq.NotEq{"i.layer_id": nil}).
+		Where(sq.Or{
+			sq.Expr("EXISTS (?)", sq.
+				Select("1").
+				From("users_segment AS us").
+				Join("segments AS s ON s.id = us.segment_id").
+				Where("us.user_id = i.user_id").
+				Where("s.layer_id = i.layer_id").
+				Where("s.id <> i.segment_id").
+				Where(sq.Or{
+					sq.Eq{"us.left_at": nil},
+					sq.Gt{"us.left_at": "now()"},
+				})),
+			sq.Expr("EXISTS (?)", sq.
+				Select("1").
+				From("import_memberships AS o").
+				Where("o.user_id = i.user_id").
+				Where("o.layer_id = i.layer_id").
+				Where("o.segment_id <> i.segment_id").
+				Where("o.line < i.line").
+				Where(sq.Eq{"o.reason": nil})),
+		}).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sql, args, _ = r.Builder.
+		Insert("users_segment").
+		Columns("user_id", "segment_id", "source", "variant", "added_at", "left_at").
+		Select(sq.
+			Select("user_id", "segment_id").
+			Column("?::varchar", sourceImport).
+			Column("segment_variant(segment_id, user_id)").
+			Column("now()").
+			Column("CASE WHEN ttl > 0 THEN now() + make_interval(hours => ttl) END").
+			From("import_memberships").
+			Where(sq.Eq{"reason": nil}).
+			OrderBy("line")).
+		Suffix("RETURNING id").
+		ToSql()
+
+	rows, err = tx.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	membershipIds, err := scanIds(rows)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = enqueueMembershipEvents(ctx, r.Builder, tx, entity.EventMembershipAdded, "", membershipIds)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sql, args, _ = r.Builder.
+		Select("line", "user_id", "segment", "reason").
+		From("import_memberships").
+		Where(sq.NotEq{"reason": nil}).
+		OrderBy("line").
+		ToSql()
+
+	rows, err = tx.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var rejected []entity.UserImportRejectedRow
+	for rows.Next() {
+		var row entity.UserImportRejectedRow
+		err = rows.Scan(&row.Line, &row.UserId, &row.Segment, &row.Reason)
+		if err != nil {
+			return 0, nil, err
+		}
+		rejected = append(rejected, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	// При пробном запуске изменения откатываются, но количество принятых строк остаётся точным
+	if dryRun {
+		return len(membershipIds), rejected, nil
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return len(membershipIds), rejected, nil
+}
+
+func (r *UserRepo) GetUserHistory(ctx context.Context, req entity.UserHistoryRequest, after *entity.UserHistoryCursor, limit int) ([]entity.ReportUserHistory, error) {
+	builder := r.Builder.PlaceholderFormat(sq.Question)
+	part := func(columns ...string) sq.SelectBuilder {
+		b := builder.
+			Select(append([]string{"us.id", "s.name", "COALESCE(us.variant, '')"}, columns...)...).
+			From("users_segment AS us").
+			Join("segments AS s ON s.id = us.segment_id").
+			Where("us.user_id = ?", req.UserId)
+		if len(req.Segments) > 0 {
+			b = b.Where("s.name = ANY(?)", req.Segments)
+		}
+
+		return b
+	}
+
+	addSql, addArgs, _ := part(fmt.Sprintf("'%s' AS operation", entity.ReportOperationAdd), "''",
+		"us.source", "COALESCE(us.added_by, '')", "us.added_at AS date", segmentNameAt("us.added_at")).
+		ToSql()
+	removeSql, removeArgs, _ := part(fmt.Sprintf("'%s'", entity.ReportOperationRemove), "COALESCE(us.removal_reason, '')",
+		"us.source", "COALESCE(us.removed_by, '')", "us.left_at", segmentNameAt("us.left_at")).
+		Where(sq.NotEq{"us.left_at": nil}).
+		ToSql()
+
+	// Добавления и исключения отбираются по собственной дате операции
+	historyQuery := builder.
+		Select("*").
+		From(fmt.Sprintf("(%s UNION ALL %s) AS history", addSql, removeSql)).
+		OrderBy("date", "id", "operation").
+		Limit(uint64(limit))
+	if !req.From.IsZero() {
+		historyQuery = historyQuery.Where(sq.GtOrEq{"date": req.From})
+	}
+	if !req.To.IsZero() {
+		historyQuery = historyQuery.Where(sq.Lt{"date": req.To})
+	}
+	if after != nil {
+		historyQuery = historyQuery.Where("(date, id, operation) > (?, ?, ?)", after.Date, after.MembershipId, after.Operation)
+	}
+
+	sql, args, _ := historyQuery.ToSql()
+	sql, _ = sq.Dollar.ReplacePlaceholders(sql)
+	args = append(append(addArgs, removeArgs...), args...)
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []entity.ReportUserHistory
+	for rows.Next() {
+		record := entity.ReportUserHistory{UserId: strconv.Itoa(req.UserId)}
+		err = rows.Scan(&record.MembershipId, &record.Segment, &record.Variant, &record.Operation,
+			&record.Reason, &record.Source, &record.Actor, &record.Date, &record.SegmentAtEvent)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (r *UserRepo) EraseUser(ctx context.Context, id int, actor string,
+	affected func(dates []time.Time) ([]int, []string, error)) (entity.UserErasureReceipt, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Блокировка пользователя не даёт добавить ему членство, а блокировка его записей членства - изменить их,
+	// поэтому даты операций не меняются до конца удаления
+	sql, args, _ := r.Builder.
+		Select("id").
+		From("users").
+		Where("id = ?", id).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	err = tx.QueryRow(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.UserErasureReceipt{}, apperror.ErrNoUser
+		}
+
+		return entity.UserErasureReceipt{}, err
+	}
+
+	dates, err := r.historyDates(ctx, tx, id)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	months, reports, err := affected(dates)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	pseudonym, err := r.createPseudonym(ctx, tx)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	// Ещё не начавшееся членство отменяется вместе с неопубликованными событиями,
+	// действующее завершается с причиной user_erased
+	sql, args, _ = r.Builder.
+		Delete("users_segment").
+		Where(sq.Gt{"added_at": "now()"}).
+		Where("user_id = ?", id).
+		Suffix("RETURNING id").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	cancelledIds, err := scanIds(rows)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	err = cancelMembershipEvents(ctx, r.Builder, tx, cancelledIds)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	sql, args, _ = r.Builder.
+		Update("users_segment").
+		Set("left_at", "now()").
+		Set("finalized_at", "now()").
+		Set("removal_reason", entity.RemovalReasonUserErased).
+		Set("removed_by", nullIfEmpty(actor)).
+		Where(sq.Or{
+			sq.Eq{"left_at": nil},
+			sq.Gt{"left_at": "now()"},
+		}).
+		Where("user_id = ?", id).
+		Suffix("RETURNING id").
+		ToSql()
+
+	rows, err = tx.Query(ctx, sql, args...)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	closedIds, err := scanIds(rows)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	receipt := entity.UserErasureReceipt{Reports: reports, ReportJobs: []int64{}, PurgeArchives: []int64{}}
+	if receipt.Reports == nil {
+		receipt.Reports = []string{}
+	}
+
+	sql, args, _ = r.Builder.
+		Update("events").
+		Set("user_id", pseudonym).
+		Where("user_id = ?", id).
+		ToSql()
+
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+	receipt.Events = int(tag.RowsAffected())
+
+	sql, args, _ = r.Builder.
+		Update("users_segment").
+		Set("user_id", pseudonym).
+		Where("user_id = ?", id).
+		ToSql()
+
+	tag, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+	receipt.Memberships = int(tag.RowsAffected())
+
+	// События завершения членства записываются после переноса членства на псевдоним, поэтому журнал событий,
+	// поток и вебхуки не получают исходный id
+	err = enqueueMembershipEvents(ctx, r.Builder, tx, entity.EventMembershipRemoved, entity.RemovalReasonUserErased, closedIds)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	sql, args, _ = r.Builder.
+		Delete("users_attributes").
+		Where("user_id = ?", id).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	sql, args, _ = r.Builder.
+		Delete("users").
+		Where("id = ?", id).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	// Файлы заданий на отчеты за месяцы, в которых у пользователя были операции, содержат исходный id, как и
+	// части файлов выполняющихся и прерванных заданий: части удаляются, а задания возвращаются в очередь
+	// и перестраиваются по истории под псевдонимом. Выполняющееся задание перестаёт принадлежать воркеру,
+	// и запись его частей и завершение отклоняются (attempts не сбрасывается, см. ReportJobRepo)
+	if len(months) > 0 {
+		sql, args, _ = r.Builder.
+			Update("report_jobs").
+			Set("status", entity.ReportJobStatusPending).
+			Set("progress", 0).
+			Set("locked_until", nil).
+			Set("finished_at", nil).
+			Where(sq.Eq{"status": []string{
+				entity.ReportJobStatusPending, entity.ReportJobStatusRunning, entity.ReportJobStatusDone,
+			}}).
+			Where("type = ?", entity.ReportJobTypeFile).
+			Where("year * 100 + month = ANY(?)", months).
+			Suffix("RETURNING id").
+			ToSql()
+
+		rows, err := tx.Query(ctx, sql, args...)
+		if err != nil {
+			return entity.UserErasureReceipt{}, err
+		}
+
+		jobIds, err := scanIds(rows)
+		if err != nil {
+			return entity.UserErasureReceipt{}, err
+		}
+		receipt.ReportJobs = append(receipt.ReportJobs, jobIds...)
+
+		if len(jobIds) > 0 {
+			sql, args, _ = r.Builder.
+				Delete("report_jobs_file").
+				Where("job_id = ANY(?)", jobIds).
+				ToSql()
+
+			_, err = tx.Exec(ctx, sql, args...)
+			if err != nil {
+				return entity.UserErasureReceipt{}, err
+			}
+		}
+	}
+
+	// Архивы удалённой истории нельзя перестроить, поэтому id пользователя (первая колонка csv)
+	// заменяется в них псевдонимом
+	userRow := fmt.Sprintf(`(^|\n)%d,`, id)
+	sql, args, _ = r.Builder.
+		Select("DISTINCT job_id").
+		PrefixExpr(sq.Expr("WITH archives AS (?)", sq.
+			Update("purge_jobs_file").
+			Set("data", sq.Expr("convert_to(regexp_replace(convert_from(data, 'UTF8'), ?, ?, 'g'), 'UTF8')",
+				userRow, fmt.Sprintf(`\1%d,`, pseudonym))).
+			Where("convert_from(data, 'UTF8') ~ ?", userRow).
+			Suffix("RETURNING job_id"))).
+		From("archives").
+		OrderBy("job_id").
+		ToSql()
+
+	rows, err = tx.Query(ctx, sql, args...)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	archiveIds, err := scanIds(rows)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+	receipt.PurgeArchives = append(receipt.PurgeArchives, archiveIds...)
+
+	sql, args, _ = r.Builder.
+		Insert("users_erasure").
+		Columns("pseudonym", "memberships", "events", "reports", "report_jobs", "purge_archives", "requested_by").
+		Values(pseudonym, receipt.Memberships, receipt.Events, receipt.Reports, receipt.ReportJobs, receipt.PurgeArchives,
+			nullIfEmpty(actor)).
+		Suffix("RETURNING id, erased_at").
+		ToSql()
+
+	err = tx.QueryRow(ctx, sql, args...).Scan(&receipt.ReceiptId, &receipt.ErasedAt)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return entity.UserErasureReceipt{}, err
+	}
+
+	return receipt, nil
+}
+
+// historyDates возвращает упорядоченные даты операций пользователя (добавлений и исключений) без повторов,
+// записи членства пользователя блокируются до конца транзакции
+func (r *UserRepo) historyDates(ctx context.Context, tx pgx.Tx, id int) ([]time.Time, error) {
+	sql, args, _ := r.Builder.
+		Select("DISTINCT date").
+		FromSelect(sq.
+			Select("added_at", "left_at").
+			From("users_segment").
+			Where("user_id = ?", id).
+			Suffix("FOR UPDATE"), "us").
+		CrossJoin("unnest(ARRAY[us.added_at, us.left_at]) AS date").
+		Where(sq.NotEq{"date": nil}).
+		OrderBy("date").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dates []time.Time
+	for rows.Next() {
+		var date time.Time
+		if err = rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		dates = append(dates, date)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return dates, nil
+}
+
+// createPseudonym создаёт пользователя-псевдоним с отрицательным id из последовательности erased_users_seq,
+// id, уже занятые пользователями, пропускаются
+func (r *UserRepo) createPseudonym(ctx context.Context, tx pgx.Tx) (int, error) {
+	sql, args, _ := r.Builder.
+		Insert("users").
+		Columns("id").
+		Values(sq.Expr("-nextval('erased_users_seq')")).
+		Suffix("ON CONFLICT DO NOTHING RETURNING id").
+		ToSql()
+
+	for {
+		var pseudonym int
+		err := tx.QueryRow(ctx, sql, args...).Scan(&pseudonym)
+		if err == nil {
+			return pseudonym, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, err
+		}
+	}
+}
+
+// layerConflict проверяет, что среди сегментов есть два сегмента одного слоя или членство пользователя в другом
+// сегменте слоя одного из сегментов пересекается с периодом [start, end), end == nil - бессрочно.
+// Вызывается в транзакции добавления пользователя в сегменты.
+func layerConflict(ctx context.Context, builder sq.StatementBuilderType, tx pgx.Tx, id int, segments []int, start, end any) (bool, error) {
+	membershipQuery := sq.
+		Select("1").
+		From("users_segment AS us").
+		Where("us.user_id = ?", id).
+		Where("us.segment_id = other.id").
+		Where(sq.Or{
+			sq.Eq{"us.left_at": nil},
+			sq.Gt{"us.left_at": start},
+		})
+	if end != nil {
+		membershipQuery = membershipQuery.Where(sq.Lt{"us.added_at": end})
+	}
+
+	sql, args, _ := builder.
+		Select("1").
+		Prefix("SELECT EXISTS (").
+		From("segments AS s").
+		Join("segments AS other ON other.layer_id = s.layer_id AND other.id <> s.id").
+		Where(sq.Eq{"s.id": segments}).
+		Where(sq.Or{
+			sq.Eq{"other.id": segments},
+			sq.Expr("EXISTS (?)", membershipQuery),
+		}).
+		Suffix(")").
+		ToSql()
+
+	var conflict bool
+	err := tx.QueryRow(ctx, sql, args...).Scan(&conflict)
+	if err != nil {
+		return false, err
 	}
 
-	return segmentsIds, nil
+	return conflict, nil
 }
@@ -1,21 +1,209 @@
 package pgdb_test
 
 import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
 	"avito-internship/internal/repository/pgdb"
 	"avito-internship/pkg/database/postgresdb"
 	"context"
+	"encoding/json"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
 	"github.com/pashagolub/pgxmock/v2"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
+func TestAddSegmentToUser(t *testing.T) {
+	startAt := time.Date(2026, 11, 1, 0, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	endAt := time.Date(2026, 11, 15, 0, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+
+	type args struct {
+		ctx      context.Context
+		id       int
+		segments []int
+		startAt  *time.Time
+		endAt    *time.Time
+		actor    string
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      bool
+	}{
+		{
+			name: "OK_new_user",
+			args: args{ctx: context.Background(),
+				id:       1,
+				segments: []int{1},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectExec("INSERT INTO users").
+					WithArgs(args.id).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectQuery("INSERT INTO users_segment (.+) RETURNING id").
+					WithArgs([]int{args.id}, 0, "now()", "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(5)))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.added", nil, []int64{5}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments AS s JOIN segments AS other").
+					WithArgs(args.segments[0], args.segments[0], args.id, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				rows := pgxmock.NewRows([]string{"segment_id"})
+				m.ExpectQuery("SELECT").
+					WithArgs(args.id, args.segments[0], "now()").
+					WillReturnRows(rows)
+
+				insertedRows := pgxmock.NewRows([]string{"id"}).AddRow(int64(10))
+				m.ExpectQuery("INSERT INTO users_segment").
+					WithArgs(args.id, args.segments[0], args.segments[0], args.id, "now()", nil, nil).
+					WillReturnRows(insertedRows)
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.added", nil, []int64{10}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "OK_scheduled",
+			args: args{ctx: context.Background(),
+				id:       1,
+				segments: []int{1},
+				startAt:  &startAt,
+				endAt:    &endAt,
+				actor:    "support@avito.ru",
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectExec("INSERT INTO users").
+					WithArgs(args.id).
+					WillReturnResult(pgxmock.NewResult("INSERT", 0))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments AS s JOIN segments AS other (.+) "+
+					"AND \\(us.left_at IS NULL OR us.left_at > \\$4\\) AND us.added_at < \\$5").
+					WithArgs(args.segments[0], args.segments[0], args.id, startAt, endAt).
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				rows := pgxmock.NewRows([]string{"segment_id"})
+				m.ExpectQuery("SELECT").
+					WithArgs(args.id, args.segments[0], startAt, endAt).
+					WillReturnRows(rows)
+
+				insertedRows := pgxmock.NewRows([]string{"id"}).AddRow(int64(10))
+				m.ExpectQuery("INSERT INTO users_segment").
+					WithArgs(args.id, args.segments[0], args.segments[0], args.id, startAt, endAt, args.actor).
+					WillReturnRows(insertedRows)
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.added", nil, []int64{10}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "OK_existing_user",
+			args: args{ctx: context.Background(),
+				id:       1,
+				segments: []int{1},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectExec("INSERT INTO users").
+					WithArgs(args.id).
+					WillReturnResult(pgxmock.NewResult("INSERT", 0))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments AS s JOIN segments AS other").
+					WithArgs(args.segments[0], args.segments[0], args.id, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(false))
+
+				rows := pgxmock.NewRows([]string{"segment_id"}).AddRow(args.segments[0])
+				m.ExpectQuery("SELECT").
+					WithArgs(args.id, args.segments[0], "now()").
+					WillReturnRows(rows)
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "Layer_conflict_after_enrollment",
+			args: args{ctx: context.Background(),
+				id:       1,
+				segments: []int{2},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectExec("INSERT INTO users").
+					WithArgs(args.id).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectQuery("INSERT INTO users_segment (.+) RETURNING id").
+					WithArgs([]int{args.id}, 0, "now()", "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(5)))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.added", nil, []int64{5}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM segments AS s JOIN segments AS other").
+					WithArgs(args.segments[0], args.segments[0], args.id, "now()").
+					WillReturnRows(pgxmock.NewRows([]string{"exists"}).AddRow(true))
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			userRepoMock := pgdb.NewUserRepo(postgresMock)
+			err := userRepoMock.AddSegmentToUser(tc.args.ctx, tc.args.id, tc.args.segments, tc.args.startAt, tc.args.endAt, tc.args.actor)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
 func TestRemoveSegmentFromUser(t *testing.T) {
 	type args struct {
 		ctx      context.Context
 		id       int
 		segments []int
+		actor    string
 	}
 
 	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
@@ -31,11 +219,33 @@ func TestRemoveSegmentFromUser(t *testing.T) {
 			args: args{ctx: context.Background(),
 				id:       1,
 				segments: []int{1, 2},
+				actor:    "support@avito.ru",
 			},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				m.ExpectExec("UPDATE").
-					WithArgs("now()", "now()", args.id, args.segments[0], args.segments[1]).
-					WillReturnResult(pgxmock.NewResult("UPDATE", 2))
+				m.ExpectBegin()
+
+				m.ExpectQuery("DELETE FROM users_segment (.+) RETURNING id").
+					WithArgs("now()", args.id, args.segments[0], args.segments[1]).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(9)))
+
+				m.ExpectExec("DELETE FROM events WHERE membership_id = ANY\\(\\$1\\) AND dispatched_at IS NULL").
+					WithArgs([]int64{9}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(int64(10)).AddRow(int64(11))
+				m.ExpectQuery("UPDATE").
+					WithArgs("now()", "now()", "manual", args.actor, "now()", args.id, args.segments[0], args.segments[1]).
+					WillReturnRows(rows)
+
+				m.ExpectExec("DELETE FROM events WHERE dispatched_at IS NULL AND membership_id IN").
+					WithArgs([]int64{10, 11}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.removed", "manual", []int64{10, 11}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+				m.ExpectCommit()
 			},
 			wantErr: false,
 		},
@@ -51,7 +261,7 @@ func TestRemoveSegmentFromUser(t *testing.T) {
 				Pool:    poolMock,
 			}
 			userRepoMock := pgdb.NewUserRepo(postgresMock)
-			err := userRepoMock.RemoveSegmentFromUser(tc.args.ctx, tc.args.id, tc.args.segments)
+			err := userRepoMock.RemoveSegmentFromUser(tc.args.ctx, tc.args.id, tc.args.segments, tc.args.actor)
 
 			if tc.wantErr {
 				assert.Error(t, err)
@@ -75,7 +285,7 @@ func TestGetActiveSegmentFromUser(t *testing.T) {
 		args         args
 		mockBehavior MockBehavior
 		wantErr      bool
-		want         []string
+		want         []entity.UserSegment
 	}{
 		{
 			name: "OK",
@@ -83,12 +293,17 @@ func TestGetActiveSegmentFromUser(t *testing.T) {
 				id: 1,
 			},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				rows := pgxmock.NewRows([]string{"name"}).AddRow("test_segment_1").AddRow("test_segment_2")
+				rows := pgxmock.NewRows([]string{"id", "name", "variant", "payload"}).
+					AddRow(1, "test_segment_1", "", nil).
+					AddRow(2, "test_segment_2", "control", []byte(`{"discount":30}`))
 				m.ExpectQuery("SELECT").
-					WithArgs("now()", args.id).WillReturnRows(rows)
+					WithArgs("now()", "now()", "now()", "now()", "now()", args.id).WillReturnRows(rows)
 			},
 			wantErr: false,
-			want:    []string{"test_segment_1", "test_segment_2"},
+			want: []entity.UserSegment{
+				{SegmentId: 1, Segment: "test_segment_1"},
+				{SegmentId: 2, Segment: "test_segment_2", Variant: "control", Payload: json.RawMessage(`{"discount":30}`)},
+			},
 		},
 	}
 	for _, tc := range testCases {
@@ -115,10 +330,10 @@ func TestGetActiveSegmentFromUser(t *testing.T) {
 	}
 }
 
-func TestCheckExistUser(t *testing.T) {
+func TestGetActiveSegmentsFromUsers(t *testing.T) {
 	type args struct {
 		ctx context.Context
-		id  int
+		ids []int
 	}
 
 	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
@@ -128,28 +343,110 @@ func TestCheckExistUser(t *testing.T) {
 		args         args
 		mockBehavior MockBehavior
 		wantErr      bool
+		want         map[int][]entity.UserSegment
 	}{
 		{
 			name: "OK",
 			args: args{ctx: context.Background(),
-				id: 1,
+				ids: []int{1, 2, 3},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"user_id", "id", "name", "variant", "payload"}).
+					AddRow(1, 1, "test_segment_1", "", nil).
+					AddRow(1, 2, "test_segment_2", "control", []byte(`{"discount":30}`)).
+					AddRow(3, 1, "test_segment_1", "", nil)
+				m.ExpectQuery("SELECT (.+) WHERE (.+) AND us.user_id = ANY\\(\\$6\\)").
+					WithArgs("now()", "now()", "now()", "now()", "now()", args.ids).WillReturnRows(rows)
+			},
+			wantErr: false,
+			want: map[int][]entity.UserSegment{
+				1: {
+					{SegmentId: 1, Segment: "test_segment_1"},
+					{SegmentId: 2, Segment: "test_segment_2", Variant: "control", Payload: json.RawMessage(`{"discount":30}`)},
+				},
+				3: {
+					{SegmentId: 1, Segment: "test_segment_1"},
+				},
+			},
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(),
+				ids: []int{1},
 			},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				rows := pgxmock.NewRows([]string{"exists"}).AddRow(true)
 				m.ExpectQuery("SELECT").
-					WithArgs(args.id).WillReturnRows(rows)
+					WithArgs("now()", "now()", "now()", "now()", "now()", args.ids).WillReturnError(pgx.ErrTxClosed)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			userRepoMock := pgdb.NewUserRepo(postgresMock)
+			got, err := userRepoMock.GetActiveSegmentsFromUsers(tc.args.ctx, tc.args.ids)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestGetUsersAttributes(t *testing.T) {
+	type args struct {
+		ctx context.Context
+		ids []int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      bool
+		want         map[int]map[string]any
+	}{
+		{
+			name: "OK_unknown_user_absent",
+			args: args{ctx: context.Background(),
+				ids: []int{1, 2, 3},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id", "attributes"}).
+					AddRow(1, map[string]any{"platform": "ios"}).
+					AddRow(2, map[string]any{})
+				m.ExpectQuery("SELECT u.id, COALESCE\\(a.attributes, '\\{\\}'\\) FROM users AS u " +
+					"LEFT JOIN users_attributes AS a ON a.user_id = u.id WHERE u.id = ANY\\(\\$1\\)").
+					WithArgs(args.ids).WillReturnRows(rows)
 			},
 			wantErr: false,
+			want: map[int]map[string]any{
+				1: {"platform": "ios"},
+				2: {},
+			},
 		},
 		{
-			name: "Error_does_not_exist)",
+			name: "DB_error",
 			args: args{ctx: context.Background(),
-				id: 1,
+				ids: []int{1},
 			},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
 				m.ExpectQuery("SELECT").
-					WithArgs(args.id).
-					WillReturnError(pgx.ErrNoRows)
+					WithArgs(args.ids).WillReturnError(pgx.ErrTxClosed)
 			},
 			wantErr: true,
 		},
@@ -165,18 +462,81 @@ func TestCheckExistUser(t *testing.T) {
 				Pool:    poolMock,
 			}
 			userRepoMock := pgdb.NewUserRepo(postgresMock)
-			err := userRepoMock.CheckExistUser(tc.args.ctx, tc.args.id)
+			got, err := userRepoMock.GetUsersAttributes(tc.args.ctx, tc.args.ids)
 
 			if tc.wantErr {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
+				assert.Equal(t, tc.want, got)
+			}
+
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCheckExistUser(t *testing.T) {
+	type args struct {
+		ctx context.Context
+		id  int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(),
+				id: 1,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"exists"}).AddRow(true)
+				m.ExpectQuery("SELECT").
+					WithArgs(args.id).WillReturnRows(rows)
+			},
+			wantErr: nil,
+		},
+		{
+			name: "Error_does_not_exist",
+			args: args{ctx: context.Background(),
+				id: 1,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"exists"}).AddRow(false)
+				m.ExpectQuery("SELECT EXISTS \\( SELECT 1 FROM users WHERE id = \\$1 \\)").
+					WithArgs(args.id).WillReturnRows(rows)
+			},
+			wantErr: apperror.ErrNoUser,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
 			}
+			userRepoMock := pgdb.NewUserRepo(postgresMock)
+			err := userRepoMock.CheckExistUser(tc.args.ctx, tc.args.id)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
 		})
 	}
 }
 
 func TestGetActiveSegmentsIdByName(t *testing.T) {
+	aliasUntil := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	type args struct {
 		ctx      context.Context
 		segments []string
@@ -190,6 +550,8 @@ func TestGetActiveSegmentsIdByName(t *testing.T) {
 		mockBehavior MockBehavior
 		wantErr      bool
 		want         []int
+		wantAliases  []entity.SegmentAlias
+		wantMissing  []string
 	}{
 		{
 			name: "OK",
@@ -197,14 +559,68 @@ func TestGetActiveSegmentsIdByName(t *testing.T) {
 				segments: []string{"test_segment_1"},
 			},
 			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
-				rows := pgxmock.NewRows([]string{"id"}).AddRow(1)
-				m.ExpectQuery("SELECT").
-					WithArgs(args.segments[0], "now()").
+				rows := pgxmock.NewRows([]string{"id", "name", "alias", "alias_until"}).AddRow(1, "test_segment_1", "", nil)
+				m.ExpectQuery("SELECT s.id, s.name, COALESCE\\(a.name, ''\\), a.alias_until FROM segments AS s "+
+					"LEFT JOIN segments_alias AS a ON a.segment_id = s.id AND a.name = ANY\\(\\$1\\) AND a.alias_until > \\$2 "+
+					"WHERE \\(s.name IN \\(\\$3\\) OR a.id IS NOT NULL\\)").
+					WithArgs(args.segments, "now()", args.segments[0], "now()", "now()", "now()").
 					WillReturnRows(rows)
 			},
 			wantErr: false,
 			want:    []int{1},
 		},
+		{
+			name: "OK_alias",
+			args: args{ctx: context.Background(),
+				segments: []string{"test_segment_1", "old_segment_2"},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id", "name", "alias", "alias_until"}).
+					AddRow(1, "test_segment_1", "", nil).
+					AddRow(2, "test_segment_2", "old_segment_2", &aliasUntil)
+				m.ExpectQuery("SELECT").
+					WithArgs(args.segments, "now()", args.segments[0], args.segments[1], "now()", "now()", "now()").
+					WillReturnRows(rows)
+			},
+			wantErr: false,
+			want:    []int{1, 2},
+			wantAliases: []entity.SegmentAlias{
+				{Alias: "old_segment_2", Segment: "test_segment_2", AliasUntil: aliasUntil},
+			},
+		},
+		{
+			name: "OK_repeated_and_alias_of_same_segment",
+			args: args{ctx: context.Background(),
+				segments: []string{"test_segment_2", "old_segment_2", "test_segment_2"},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id", "name", "alias", "alias_until"}).
+					AddRow(2, "test_segment_2", "old_segment_2", &aliasUntil)
+				m.ExpectQuery("SELECT").
+					WithArgs(args.segments, "now()", args.segments[0], args.segments[1], args.segments[2], "now()", "now()", "now()").
+					WillReturnRows(rows)
+			},
+			wantErr: false,
+			want:    []int{2},
+			wantAliases: []entity.SegmentAlias{
+				{Alias: "old_segment_2", Segment: "test_segment_2", AliasUntil: aliasUntil},
+			},
+		},
+		{
+			name: "Missing",
+			args: args{ctx: context.Background(),
+				segments: []string{"test_segment_1", "unknown", "unknown"},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id", "name", "alias", "alias_until"}).AddRow(1, "test_segment_1", "", nil)
+				m.ExpectQuery("SELECT").
+					WithArgs(args.segments, "now()", args.segments[0], args.segments[1], args.segments[2], "now()", "now()", "now()").
+					WillReturnRows(rows)
+			},
+			wantErr:     false,
+			want:        []int{1},
+			wantMissing: []string{"unknown"},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -217,7 +633,546 @@ func TestGetActiveSegmentsIdByName(t *testing.T) {
 				Pool:    poolMock,
 			}
 			userRepoMock := pgdb.NewUserRepo(postgresMock)
-			got, err := userRepoMock.GetActiveSegmentsIdByName(tc.args.ctx, tc.args.segments)
+			got, aliases, missing, err := userRepoMock.GetActiveSegmentsIdByName(tc.args.ctx, tc.args.segments)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.wantAliases, aliases)
+			assert.Equal(t, tc.wantMissing, missing)
+		})
+	}
+}
+
+func TestFinalizeExpiredMemberships(t *testing.T) {
+	type args struct {
+		ctx context.Context
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int64
+		wantErr      bool
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background()},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(int64(10))
+				m.ExpectQuery("UPDATE users_segment AS us SET finalized_at").
+					WithArgs("now()", "expired", "now()").
+					WillReturnRows(rows)
+
+				m.ExpectExec("DELETE FROM events WHERE dispatched_at IS NULL AND membership_id IN").
+					WithArgs([]int64{10}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.removed", "expired", []int64{10}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+			want:    1,
+		},
+		{
+			name: "Nothing_expired",
+			args: args{ctx: context.Background()},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				rows := pgxmock.NewRows([]string{"id"})
+				m.ExpectQuery("UPDATE users_segment AS us SET finalized_at").
+					WithArgs("now()", "expired", "now()").
+					WillReturnRows(rows)
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+			want:    0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			userRepoMock := pgdb.NewUserRepo(postgresMock)
+			got, err := userRepoMock.FinalizeExpiredMemberships(tc.args.ctx)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestImportSegmentsToUsers(t *testing.T) {
+	type args struct {
+		ctx    context.Context
+		rows   []entity.UserImportRow
+		dryRun bool
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	importRows := []entity.UserImportRow{
+		{Line: 2, UserId: 1000, Segment: "AVITO_VOICE_MESSAGES", Ttl: 2},
+		{Line: 3, UserId: 1001, Segment: "AVITO_VOICE_MESSAGES"},
+		{Line: 4, UserId: 1002, Segment: "UNKNOWN"},
+	}
+
+	expectImport := func(m pgxmock.PgxPoolIface) {
+		m.ExpectBegin()
+
+		m.ExpectExec("CREATE TEMP TABLE import_memberships").
+			WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+
+		m.ExpectCopyFrom(pgx.Identifier{"import_memberships"}, []string{"line", "user_id", "segment", "ttl"}).
+			WillReturnResult(3)
+
+		m.ExpectExec("UPDATE import_memberships AS i SET segment_id = s.id, layer_id = s.layer_id FROM segments AS s").
+			WithArgs("now()", "now()", "now()").
+			WillReturnResult(pgxmock.NewResult("UPDATE", 2))
+
+		m.ExpectExec("UPDATE import_memberships SET reason = \\$1 WHERE segment_id IS NULL").
+			WithArgs(entity.ImportReasonUnknownSegment).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+		m.ExpectExec("UPDATE import_memberships AS i SET reason = \\$1 (.+) d.line < i.line").
+			WithArgs(entity.ImportReasonDuplicate).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+		newUsers := pgxmock.NewRows([]string{"id"}).AddRow(int64(1001))
+		m.ExpectQuery("INSERT INTO users \\(id\\) SELECT DISTINCT user_id FROM import_memberships").
+			WillReturnRows(newUsers)
+
+		m.ExpectQuery("INSERT INTO users_segment (.+) RETURNING id").
+			WithArgs([]int{1001}, 0, "now()", "now()").
+			WillReturnRows(pgxmock.NewRows([]string{"id"}))
+
+		m.ExpectExec("UPDATE import_memberships AS i SET reason = \\$1 (.+) FROM users_segment AS us").
+			WithArgs(entity.ImportReasonAlreadyInSegment, "now()").
+			WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+		m.ExpectExec("UPDATE import_memberships AS i SET reason = \\$1 (.+) i.layer_id IS NOT NULL").
+			WithArgs(entity.ImportReasonLayerConflict, "now()").
+			WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+		insertedRows := pgxmock.NewRows([]string{"id"}).AddRow(int64(10)).AddRow(int64(11))
+		m.ExpectQuery("INSERT INTO users_segment (.+) SELECT user_id, segment_id, \\$1::varchar").
+			WithArgs("import").
+			WillReturnRows(insertedRows)
+
+		m.ExpectExec("INSERT INTO events").
+			WithArgs("membership.added", nil, []int64{10, 11}).
+			WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+		rejectedRows := pgxmock.NewRows([]string{"line", "user_id", "segment", "reason"}).
+			AddRow(4, 1002, "UNKNOWN", entity.ImportReasonUnknownSegment)
+		m.ExpectQuery("SELECT line, user_id, segment, reason FROM import_memberships WHERE reason IS NOT NULL").
+			WillReturnRows(rejectedRows)
+	}
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      bool
+		wantAccepted int
+		wantRejected []entity.UserImportRejectedRow
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(),
+				rows: importRows,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				expectImport(m)
+				m.ExpectCommit()
+			},
+			wantErr:      false,
+			wantAccepted: 2,
+			wantRejected: []entity.UserImportRejectedRow{
+				{Line: 4, UserId: 1002, Segment: "UNKNOWN", Reason: entity.ImportReasonUnknownSegment},
+			},
+		},
+		{
+			name: "OK_dry_run",
+			args: args{ctx: context.Background(),
+				rows:   importRows,
+				dryRun: true,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				expectImport(m)
+				m.ExpectRollback()
+			},
+			wantErr:      false,
+			wantAccepted: 2,
+			wantRejected: []entity.UserImportRejectedRow{
+				{Line: 4, UserId: 1002, Segment: "UNKNOWN", Reason: entity.ImportReasonUnknownSegment},
+			},
+		},
+		{
+			name: "Copy_error",
+			args: args{ctx: context.Background(),
+				rows: importRows,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+				m.ExpectExec("CREATE TEMP TABLE import_memberships").
+					WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+				m.ExpectCopyFrom(pgx.Identifier{"import_memberships"}, []string{"line", "user_id", "segment", "ttl"}).
+					WillReturnError(pgx.ErrTxClosed)
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			userRepoMock := pgdb.NewUserRepo(postgresMock)
+			accepted, rejected, err := userRepoMock.ImportSegmentsToUsers(tc.args.ctx, tc.args.rows, tc.args.dryRun)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.wantAccepted, accepted)
+			assert.Equal(t, tc.wantRejected, rejected)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetUserHistory(t *testing.T) {
+	addedAt := time.Date(2023, 9, 1, 10, 0, 0, 0, time.UTC)
+	leftAt := time.Date(2023, 9, 2, 10, 0, 0, 0, time.UTC)
+	columns := []string{"id", "name", "variant", "operation", "reason", "source", "actor", "date", "segment_at_event"}
+
+	type args struct {
+		ctx   context.Context
+		req   entity.UserHistoryRequest
+		after *entity.UserHistoryCursor
+		limit int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         []entity.ReportUserHistory
+		wantErr      bool
+	}{
+		{
+			name: "OK_first_page",
+			args: args{ctx: context.Background(),
+				req:   entity.UserHistoryRequest{UserId: 1000},
+				limit: 51,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows(columns).
+					AddRow(int64(10), "AVITO_VOICE_MESSAGES", "", "add", "", "manual", "support@avito.ru", addedAt, "AVITO_VOICE").
+					AddRow(int64(10), "AVITO_VOICE_MESSAGES", "", "remove", "expired", "manual", "", leftAt, "AVITO_VOICE_MESSAGES")
+				m.ExpectQuery("SELECT \\* FROM \\(SELECT (.+) WHERE us.user_id = \\$1 UNION ALL SELECT (.+) "+
+					"WHERE us.user_id = \\$2 AND us.left_at IS NOT NULL\\) AS history ORDER BY date, id, operation LIMIT 51").
+					WithArgs(args.req.UserId, args.req.UserId).
+					WillReturnRows(rows)
+			},
+			want: []entity.ReportUserHistory{
+				{UserId: "1000", Segment: "AVITO_VOICE_MESSAGES", Operation: "add", Date: addedAt,
+					SegmentAtEvent: "AVITO_VOICE", MembershipId: 10, Source: "manual", Actor: "support@avito.ru"},
+				{UserId: "1000", Segment: "AVITO_VOICE_MESSAGES", Operation: "remove", Reason: "expired", Date: leftAt,
+					SegmentAtEvent: "AVITO_VOICE_MESSAGES", MembershipId: 10, Source: "manual"},
+			},
+		},
+		{
+			name: "OK_next_page_with_filters",
+			args: args{ctx: context.Background(),
+				req: entity.UserHistoryRequest{
+					UserId:   1000,
+					Segments: []string{"AVITO_VOICE_MESSAGES"},
+					From:     addedAt,
+				},
+				after: &entity.UserHistoryCursor{Date: addedAt, MembershipId: 10, Operation: "add"},
+				limit: 2,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("WHERE us.user_id = \\$1 AND s.name = ANY\\(\\$2\\) UNION ALL (.+) "+
+					"WHERE date >= \\$5 AND \\(date, id, operation\\) > \\(\\$6, \\$7, \\$8\\) ORDER BY date, id, operation LIMIT 2").
+					WithArgs(args.req.UserId, args.req.Segments, args.req.UserId, args.req.Segments,
+						addedAt, addedAt, int64(10), "add").
+					WillReturnRows(pgxmock.NewRows(columns))
+			},
+			want: nil,
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(),
+				req:   entity.UserHistoryRequest{UserId: 1000},
+				limit: 51,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectQuery("SELECT").
+					WithArgs(args.req.UserId, args.req.UserId).
+					WillReturnError(pgx.ErrTxClosed)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			userRepoMock := pgdb.NewUserRepo(postgresMock)
+			got, err := userRepoMock.GetUserHistory(tc.args.ctx, tc.args.req, tc.args.after, tc.args.limit)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	erasedAt := time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC)
+	addedAt := time.Date(2023, 9, 5, 10, 0, 0, 0, time.UTC)
+
+	type args struct {
+		ctx         context.Context
+		id          int
+		months      []int
+		reports     []string
+		affectedErr error
+		actor       string
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantDates    []time.Time
+		want         entity.UserErasureReceipt
+		wantErr      bool
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(),
+				id:      1000,
+				months:  []int{202309},
+				reports: []string{"report_2023-09-01_2023-09-30.csv"},
+				actor:   "dpo@avito.ru",
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT id FROM users WHERE id = \\$1 FOR UPDATE").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(args.id))
+
+				m.ExpectQuery("SELECT DISTINCT date FROM \\(SELECT added_at, left_at FROM users_segment WHERE user_id = \\$1 FOR UPDATE\\) AS us " +
+					"CROSS JOIN unnest\\(ARRAY\\[us.added_at, us.left_at\\]\\) AS date WHERE date IS NOT NULL ORDER BY date").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"date"}).AddRow(addedAt))
+
+				m.ExpectQuery("INSERT INTO users \\(id\\) VALUES \\(-nextval\\('erased_users_seq'\\)\\) ON CONFLICT DO NOTHING").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}))
+				m.ExpectQuery("INSERT INTO users").
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(-2))
+
+				m.ExpectQuery("DELETE FROM users_segment (.+) RETURNING id").
+					WithArgs("now()", args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(11)))
+
+				m.ExpectExec("DELETE FROM events WHERE membership_id = ANY\\(\\$1\\) AND dispatched_at IS NULL").
+					WithArgs([]int64{11}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+				m.ExpectQuery("UPDATE users_segment SET left_at (.+) RETURNING id").
+					WithArgs("now()", "now()", entity.RemovalReasonUserErased, args.actor, "now()", args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(12)))
+
+				m.ExpectExec("UPDATE events SET user_id").
+					WithArgs(-2, args.id).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 4))
+
+				m.ExpectExec("UPDATE users_segment SET user_id").
+					WithArgs(-2, args.id).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 3))
+
+				m.ExpectExec("DELETE FROM events WHERE dispatched_at IS NULL AND membership_id IN").
+					WithArgs([]int64{12}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+				m.ExpectExec("INSERT INTO events").
+					WithArgs("membership.removed", "user_erased", []int64{12}).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+				m.ExpectExec("DELETE FROM users_attributes").
+					WithArgs(args.id).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+				m.ExpectExec("DELETE FROM users").
+					WithArgs(args.id).
+					WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+				m.ExpectQuery("UPDATE report_jobs SET status = \\$1, progress = \\$2, locked_until = \\$3, finished_at = \\$4 "+
+					"WHERE status IN \\(\\$5,\\$6,\\$7\\) AND type = \\$8 AND year \\* 100 \\+ month = ANY\\(\\$9\\) RETURNING id").
+					WithArgs(entity.ReportJobStatusPending, 0, nil, nil, entity.ReportJobStatusPending, entity.ReportJobStatusRunning,
+						entity.ReportJobStatusDone, entity.ReportJobTypeFile, args.months).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(7)))
+
+				m.ExpectExec("DELETE FROM report_jobs_file WHERE job_id = ANY\\(\\$1\\)").
+					WithArgs([]int64{7}).
+					WillReturnResult(pgxmock.NewResult("DELETE", 2))
+
+				m.ExpectQuery("WITH archives AS \\(UPDATE purge_jobs_file SET data = convert_to\\(regexp_replace\\(convert_from\\(data, 'UTF8'\\), "+
+					"\\$1, \\$2, 'g'\\), 'UTF8'\\) (.+) RETURNING job_id\\) SELECT DISTINCT job_id FROM archives ORDER BY job_id").
+					WithArgs(`(^|\n)1000,`, `\1-2,`, `(^|\n)1000,`).
+					WillReturnRows(pgxmock.NewRows([]string{"job_id"}).AddRow(int64(5)))
+
+				m.ExpectQuery("INSERT INTO users_erasure").
+					WithArgs(-2, 3, 4, args.reports, []int64{7}, []int64{5}, args.actor).
+					WillReturnRows(pgxmock.NewRows([]string{"id", "erased_at"}).AddRow(int64(1), erasedAt))
+
+				m.ExpectCommit()
+			},
+			wantDates: []time.Time{addedAt},
+			want: entity.UserErasureReceipt{
+				ReceiptId:     1,
+				Memberships:   3,
+				Events:        4,
+				Reports:       []string{"report_2023-09-01_2023-09-30.csv"},
+				ReportJobs:    []int64{7},
+				PurgeArchives: []int64{5},
+				ErasedAt:      erasedAt,
+			},
+		},
+		{
+			name: "No_user",
+			args: args{ctx: context.Background(),
+				id: 1000,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT id FROM users").
+					WithArgs(args.id).
+					WillReturnError(pgx.ErrNoRows)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+		{
+			name: "Drive_error",
+			args: args{ctx: context.Background(),
+				id:          1000,
+				affectedErr: apperror.ErrGDriveNotAvailable,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT id FROM users").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(args.id))
+
+				m.ExpectQuery("SELECT DISTINCT date").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"date"}).AddRow(addedAt))
+
+				m.ExpectRollback()
+			},
+			wantDates: []time.Time{addedAt},
+			wantErr:   true,
+		},
+		{
+			name: "DB_error",
+			args: args{ctx: context.Background(),
+				id: 1000,
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectQuery("SELECT id FROM users").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(args.id))
+
+				m.ExpectQuery("SELECT DISTINCT date").
+					WithArgs(args.id).
+					WillReturnRows(pgxmock.NewRows([]string{"date"}))
+
+				m.ExpectQuery("INSERT INTO users").
+					WillReturnError(pgx.ErrTxClosed)
+
+				m.ExpectRollback()
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			userRepoMock := pgdb.NewUserRepo(postgresMock)
+			var dates []time.Time
+			got, err := userRepoMock.EraseUser(tc.args.ctx, tc.args.id, tc.args.actor, func(d []time.Time) ([]int, []string, error) {
+				dates = d
+
+				return tc.args.months, tc.args.reports, tc.args.affectedErr
+			})
 
 			if tc.wantErr {
 				assert.Error(t, err)
@@ -225,7 +1180,9 @@ func TestGetActiveSegmentsIdByName(t *testing.T) {
 				assert.NoError(t, err)
 			}
 
+			assert.Equal(t, tc.wantDates, dates)
 			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
 		})
 	}
 }
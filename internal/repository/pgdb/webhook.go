@@ -0,0 +1,292 @@
+package pgdb
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"time"
+)
+
+// eventsDispatchLockKey ключ advisory-блокировки распределения событий журнала
+const eventsDispatchLockKey = 7301
+
+type WebhookRepo struct {
+	*postgresdb.Postgres
+}
+
+func NewWebhookRepo(pg *postgresdb.Postgres) *WebhookRepo {
+	return &WebhookRepo{pg}
+}
+
+func (r *WebhookRepo) CreateWebhook(ctx context.Context, webhook entity.Webhook) (int, error) {
+	sql, args, _ := r.Builder.
+		Insert("webhooks").
+		Columns("url", "events", "secret").
+		Values(webhook.Url, webhook.Events, webhook.Secret).
+		Suffix("RETURNING id").
+		ToSql()
+
+	var webhookId int
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&webhookId)
+	if err != nil {
+		return 0, err
+	}
+
+	return webhookId, nil
+}
+
+func (r *WebhookRepo) DeleteWebhook(ctx context.Context, id int) error {
+	sql, args, _ := r.Builder.
+		Update("webhooks").
+		Set("deleted_at", "now()").
+		Where("id = ?", id).
+		Where(sq.Eq{"deleted_at": nil}).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrNoWebhook
+	}
+
+	return nil
+}
+
+func (r *WebhookRepo) GetWebhooks(ctx context.Context) ([]entity.Webhook, error) {
+	sql, args, _ := r.Builder.
+		Select("id", "url", "events").
+		From("webhooks").
+		Where(sq.Eq{"deleted_at": nil}).
+		OrderBy("id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []entity.Webhook
+	for rows.Next() {
+		var webhook entity.Webhook
+		err = rows.Scan(&webhook.Id, &webhook.Url, &webhook.Events)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *WebhookRepo) DispatchEvents(ctx context.Context, limit int) (int, error) {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Номера в потоке присваиваются одним распределением за раз: блокировка снимается при фиксации,
+	// поэтому следующее распределение видит все ранее присвоенные номера
+	sql, args, _ := r.Builder.
+		Select().
+		Column("pg_advisory_xact_lock(?)", eventsDispatchLockKey).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	sql, args, _ = r.Builder.
+		Select("id").
+		From("events").
+		Where(sq.Eq{"dispatched_at": nil}).
+		Where(sq.LtOrEq{"occurred_at": "now()"}).
+		OrderBy("id").
+		Limit(uint64(limit)).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		ToSql()
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	eventIds, err := scanIds(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(eventIds) == 0 {
+		return 0, nil
+	}
+
+	sql, args, _ = r.Builder.
+		Insert("webhook_deliveries").
+		Columns("webhook_id", "event_id").
+		Select(sq.
+			Select("w.id", "e.id").
+			From("events AS e").
+			Join("webhooks AS w ON e.type = ANY(w.events)").
+			Where(sq.Eq{"e.id": eventIds}).
+			Where(sq.Eq{"w.deleted_at": nil})).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	sql, args, _ = r.Builder.
+		Update("events AS e").
+		Set("dispatched_at", "now()").
+		Set("seq", sq.Expr("n.seq")).
+		FromSelect(sq.
+			Select("id").
+			Column("(SELECT COALESCE(max(seq), 0) FROM events) + row_number() OVER (ORDER BY occurred_at, id) AS seq").
+			From("events").
+			Where(sq.Eq{"id": eventIds}), "n").
+		Where("e.id = n.id").
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(eventIds), nil
+}
+
+func (r *WebhookRepo) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]entity.WebhookDelivery, error) {
+	// Доставки захватываются сдвигом next_attempt_at на время аренды,
+	// поэтому запрос к подписчику выполняется вне транзакции
+	sql, args, _ := r.Builder.
+		Update("webhook_deliveries AS d").
+		Set("next_attempt_at", sq.Expr(fmt.Sprintf("now() + INTERVAL '%d seconds'", int(lease.Seconds())))).
+		Set("attempts", sq.Expr("d.attempts + 1")).
+		From("webhooks AS w, events AS e").
+		Where("w.id = d.webhook_id").
+		Where("e.id = d.event_id").
+		Where(sq.Expr("d.id IN (?)", sq.
+			Select("id").
+			From("webhook_deliveries").
+			Where(sq.Eq{"delivered_at": nil}).
+			Where(sq.LtOrEq{"next_attempt_at": "now()"}).
+			OrderBy("next_attempt_at").
+			Limit(uint64(limit)).
+			Suffix("FOR UPDATE SKIP LOCKED"))).
+		Suffix("RETURNING d.id, d.attempts, w.url, w.secret, " +
+			"e.id, e.type, COALESCE(e.user_id, 0), e.segment, COALESCE(e.variant, ''), COALESCE(e.reason, ''), e.occurred_at").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []entity.WebhookDelivery
+	for rows.Next() {
+		var d entity.WebhookDelivery
+		err = rows.Scan(&d.Id, &d.Attempts, &d.Url, &d.Secret,
+			&d.Event.Id, &d.Event.Type, &d.Event.UserId, &d.Event.Segment, &d.Event.Variant, &d.Event.Reason, &d.Event.OccurredAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *WebhookRepo) MarkDelivered(ctx context.Context, id int64) error {
+	sql, args, _ := r.Builder.
+		Update("webhook_deliveries").
+		Set("delivered_at", "now()").
+		Set("last_error", nil).
+		Where("id = ?", id).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *WebhookRepo) RetryDelivery(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	sql, args, _ := r.Builder.
+		Update("webhook_deliveries").
+		Set("next_attempt_at", nextAttemptAt).
+		Set("last_error", lastError).
+		Where("id = ?", id).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *WebhookRepo) DeadLetterDelivery(ctx context.Context, id int64, lastError string) error {
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, _ := r.Builder.
+		Delete("webhook_deliveries").
+		Where("id = ?", id).
+		Suffix("RETURNING webhook_id, event_id, attempts").
+		ToSql()
+
+	var webhookId, eventId int64
+	var attempts int
+	err = tx.QueryRow(ctx, sql, args...).Scan(&webhookId, &eventId, &attempts)
+	if err != nil {
+		return err
+	}
+
+	sql, args, _ = r.Builder.
+		Insert("webhook_dead_letters").
+		Columns("webhook_id", "event_id", "attempts", "last_error").
+		Values(webhookId, eventId, attempts, lastError).
+		ToSql()
+
+	_, err = tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
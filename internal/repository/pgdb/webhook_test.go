@@ -0,0 +1,215 @@
+package pgdb_test
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/repository/pgdb"
+	"avito-internship/pkg/database/postgresdb"
+	"context"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pashagolub/pgxmock/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCreateWebhook(t *testing.T) {
+	type args struct {
+		ctx     context.Context
+		webhook entity.Webhook
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int
+		wantErr      bool
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(),
+				webhook: entity.Webhook{
+					Url:    "https://example.com/hooks",
+					Events: []string{entity.EventMembershipAdded},
+					Secret: "secret",
+				},
+			},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(1)
+				m.ExpectQuery("INSERT INTO webhooks").
+					WithArgs(args.webhook.Url, args.webhook.Events, args.webhook.Secret).
+					WillReturnRows(rows)
+			},
+			wantErr: false,
+			want:    1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			webhookRepoMock := pgdb.NewWebhookRepo(postgresMock)
+			got, err := webhookRepoMock.CreateWebhook(tc.args.ctx, tc.args.webhook)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	type args struct {
+		ctx context.Context
+		id  int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		wantErr      error
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), id: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectExec("UPDATE webhooks").
+					WithArgs("now()", args.id).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+			},
+			wantErr: nil,
+		},
+		{
+			name: "No_webhook",
+			args: args{ctx: context.Background(), id: 1},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectExec("UPDATE webhooks").
+					WithArgs("now()", args.id).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+			},
+			wantErr: apperror.ErrNoWebhook,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			webhookRepoMock := pgdb.NewWebhookRepo(postgresMock)
+			err := webhookRepoMock.DeleteWebhook(tc.args.ctx, tc.args.id)
+
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDispatchEvents(t *testing.T) {
+	type args struct {
+		ctx   context.Context
+		limit int
+	}
+
+	type MockBehavior func(m pgxmock.PgxPoolIface, args args)
+
+	testCases := []struct {
+		name         string
+		args         args
+		mockBehavior MockBehavior
+		want         int
+		wantErr      bool
+	}{
+		{
+			name: "OK",
+			args: args{ctx: context.Background(), limit: 100},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectExec("SELECT pg_advisory_xact_lock").
+					WithArgs(7301).
+					WillReturnResult(pgxmock.NewResult("SELECT", 1))
+
+				rows := pgxmock.NewRows([]string{"id"}).AddRow(int64(1)).AddRow(int64(2))
+				m.ExpectQuery("SELECT id FROM events").
+					WithArgs("now()").
+					WillReturnRows(rows)
+
+				m.ExpectExec("INSERT INTO webhook_deliveries").
+					WithArgs(int64(1), int64(2)).
+					WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+				m.ExpectExec("UPDATE events AS e SET dispatched_at = \\$1, seq = n.seq FROM \\(SELECT id, (.+) OVER \\(ORDER BY occurred_at, id\\) AS seq FROM events WHERE id IN \\(\\$2,\\$3\\)\\) AS n").
+					WithArgs("now()", int64(1), int64(2)).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 2))
+
+				m.ExpectCommit()
+			},
+			wantErr: false,
+			want:    2,
+		},
+		{
+			name: "No_events",
+			args: args{ctx: context.Background(), limit: 100},
+			mockBehavior: func(m pgxmock.PgxPoolIface, args args) {
+				m.ExpectBegin()
+
+				m.ExpectExec("SELECT pg_advisory_xact_lock").
+					WithArgs(7301).
+					WillReturnResult(pgxmock.NewResult("SELECT", 1))
+
+				rows := pgxmock.NewRows([]string{"id"})
+				m.ExpectQuery("SELECT id FROM events").
+					WithArgs("now()").
+					WillReturnRows(rows)
+
+				m.ExpectRollback()
+			},
+			wantErr: false,
+			want:    0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			poolMock, _ := pgxmock.NewPool()
+			defer poolMock.Close()
+			tc.mockBehavior(poolMock, tc.args)
+
+			postgresMock := &postgresdb.Postgres{
+				Builder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar),
+				Pool:    poolMock,
+			}
+			webhookRepoMock := pgdb.NewWebhookRepo(postgresMock)
+			got, err := webhookRepoMock.DispatchEvents(tc.args.ctx, tc.args.limit)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.want, got)
+			assert.NoError(t, poolMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -5,76 +5,397 @@ import (
 	"avito-internship/internal/repository/pgdb"
 	"avito-internship/pkg/database/postgresdb"
 	"context"
+	"time"
 )
 
 // SegmentRepo Методы репозитория сегментов
 type SegmentRepo interface {
-	// CreateSegment метод создания сегмента, на вход принимает название,
-	// возвращает id созданного сегмента и ошибку бд или nil
-	CreateSegment(ctx context.Context, segment string) (int, error)
+	// CreateSegment метод создания сегмента, на вход принимает название и [опционально] процент пользователей,
+	// правило таргетинга, варианты эксперимента, слой, данные, окно активности (при отсутствии начала сегмент
+	// активен сразу, при отсутствии конца — бессрочно) и описание для каталога с инициатором создания,
+	// варианты и добавление пользователей по проценту сохраняются в той же транзакции, что и сегмент,
+	// возвращает id созданного сегмента (0, если сегмент уже существует) и ошибку бд,
+	// apperror.ErrSegmentDeleted (сегмент с таким названием удалён) или nil
+	CreateSegment(ctx context.Context, segment entity.Segment) (int, error)
 
-	// DeleteSegment метод удаления сегмента, на вход принимает название,
-	// возвращает ошибку бд или nil
+	// DeleteSegment метод удаления сегмента, на вход принимает название или действующий псевдоним,
+	// возвращает ошибку бд, apperror.ErrNoSegment (сегмент не существует или уже удалён) или nil
 	DeleteSegment(ctx context.Context, segment string) error
 
-	// CheckExistSegment метод проверки сегмента на существование, на вход принимает название,
-	// возвращает true если сегмент существует, иначе false, и ошибку бд или nil
-	CheckExistSegment(ctx context.Context, segment string) (bool, error)
+	// RestoreSegment метод восстановления удалённого сегмента, на вход принимает название,
+	// признак возврата пользователей, исключённых при удалении сегмента (в историю записывается новое добавление
+	// с источником restore), и инициатора восстановления (может быть пустым),
+	// возвращает количество возвращённых пользователей и ошибку бд, apperror.ErrNoDeletedSegment,
+	// apperror.ErrSegmentPurging (если история сегмента удаляется заданием) или nil.
+	RestoreSegment(ctx context.Context, segment string, reenroll bool, actor string) (int, error)
 
-	// RandomUserToSegment метод добавления случайных N% пользователей в сегмент,
-	// на вход принимает id сегмента и необходимый процент пользователей,
-	// возвращает ошибку бд или nil
-	RandomUserToSegment(ctx context.Context, segmentId int, percent float32) error
+	// RenameSegment метод переименования сегмента, на вход принимает текущее и новое название, момент,
+	// до которого прежнее название остаётся псевдонимом сегмента, и инициатора изменения (может быть пустым),
+	// возвращает ошибку бд, apperror.ErrNoSegment, apperror.ErrSegmentNameTaken или nil.
+	// Членство и история сегмента сохраняются.
+	RenameSegment(ctx context.Context, segment string, newName string, aliasUntil time.Time, actor string) error
+
+	// UpdateSegment метод изменения сегмента, на вход принимает запрос изменения (не указанные поля не меняются,
+	// пустое правило удаляет правило, JSON null удаляет данные сегмента), все изменения применяются в одной транзакции,
+	// также сохраняет время изменения и его инициатора,
+	// возвращает ошибку бд, apperror.ErrNoSegment, apperror.ErrRuleInLayer (правило задаётся сегменту слоя) или nil.
+	// Попадание пользователя в сегмент определяется его бакетом (хэш соли сегмента и id пользователя),
+	// поэтому при увеличении процента уже добавленные пользователи остаются в сегменте,
+	// а при уменьшении исключаются только пользователи из верхних бакетов.
+	UpdateSegment(ctx context.Context, req entity.SegmentUpdateRequest) error
+
+	// GetUserVariants метод получения вариантов пользователя в сегментах без явного членства (по правилам),
+	// на вход принимает id пользователя и массив из id сегментов,
+	// возвращает map id сегмента -> вариант (пустая строка для сегментов без вариантов) и ошибку бд или nil
+	GetUserVariants(ctx context.Context, userId int, segmentIds []int) (map[int]string, error)
+
+	// GetUsersVariants метод получения вариантов нескольких пользователей в сегментах без явного членства,
+	// на вход принимает массив из id пользователей и массив из id сегментов,
+	// возвращает map id пользователя -> (id сегмента -> вариант) и ошибку бд или nil
+	GetUsersVariants(ctx context.Context, userIds []int, segmentIds []int) (map[int]map[int]string, error)
+
+	// GetSegments метод получения страницы каталога сегментов (в том числе удалённых),
+	// на вход принимает фильтры по префиксу названия, тегам и статусу, курсор (название последнего сегмента
+	// предыдущей страницы) и максимальное количество записей,
+	// возвращает сегменты с количеством пользователей, упорядоченные по названию, и ошибку бд или nil
+	GetSegments(ctx context.Context, req entity.SegmentListRequest, limit int) ([]entity.SegmentInfo, error)
+
+	// GetActiveRuleSegments метод получения активных сегментов с правилами таргетинга,
+	// возвращает массив сегментов и ошибку бд или nil
+	GetActiveRuleSegments(ctx context.Context) ([]entity.Segment, error)
+
+	// ExpireSegments метод завершения членства пользователей в сегментах, окно активности которых закончилось,
+	// время исключения пользователей устанавливается равным времени окончания сегмента,
+	// возвращает количество исключённых пользователей и ошибку бд или nil
+	ExpireSegments(ctx context.Context) (int64, error)
+
+	// GetSegmentId метод получения id сегмента (в том числе удалённого) по названию или действующему псевдониму,
+	// возвращает id сегмента и ошибку бд, apperror.ErrNoSegment или nil.
+	GetSegmentId(ctx context.Context, segment string) (int, error)
+
+	// GetSegmentMembersAt метод получения страницы пользователей, состоявших в сегменте в указанный момент
+	// (added_at <= at < left_at, сегмент не был удалён и был активен в этот момент),
+	// на вход принимает id сегмента, момент времени, [опционально] тип членства (ttl или permanent),
+	// id последнего пользователя предыдущей страницы (0 для первой страницы) и максимальное количество записей,
+	// возвращает массив пользователей, упорядоченный по id, и ошибку бд или nil.
+	GetSegmentMembersAt(ctx context.Context, segmentId int, at time.Time, membership string, afterUserId int, limit int) ([]entity.SegmentMember, error)
+
+	// CountSegmentMembersAt метод подсчета пользователей, состоявших в сегменте в указанный момент,
+	// на вход принимает id сегмента, момент времени и [опционально] тип членства,
+	// возвращает количество пользователей и ошибку бд или nil.
+	CountSegmentMembersAt(ctx context.Context, segmentId int, at time.Time, membership string) (int, error)
 }
 
 // UserRepo Методы репозитория пользователей
 type UserRepo interface {
 	// AddSegmentToUser метод добавления пользователя в сегменты,
-	// на вход принимает id пользователя, массив из id сегментов и время нахождения пользователя в указанных сегментах,
-	// возвращает ошибку бд или nil.
-	// При отсутствии ttl, конечное время не указывается.
-	// ttl задаётся в часах.
-	AddSegmentToUser(ctx context.Context, id int, segments []int, ttl int) error
+	// на вход принимает id пользователя, массив из id сегментов, время начала и время окончания
+	// нахождения пользователя в указанных сегментах и инициатора изменения (может быть пустым),
+	// возвращает ошибку бд, apperror.ErrSegmentConflict (среди сегментов есть два сегмента одного слоя
+	// или пользователь, в том числе после автоматического добавления по проценту, уже состоит в другом сегменте
+	// слоя одного из сегментов) или nil.
+	// При отсутствии времени начала пользователь добавляется сразу, при отсутствии времени окончания — бессрочно.
+	// Сегменты, членство в которых пересекается с указанным периодом, пропускаются.
+	AddSegmentToUser(ctx context.Context, id int, segments []int, startAt, endAt *time.Time, actor string) error
 
 	// RemoveSegmentFromUser метод исключения пользователя из сегментов,
-	// на вход принимает id пользователя и массив из id сегментов,
+	// на вход принимает id пользователя, массив из id сегментов и инициатора изменения (может быть пустым),
 	// возвращает ошибку бд или nil.
-	RemoveSegmentFromUser(ctx context.Context, id int, segments []int) error
+	// Запланированное, но ещё не начавшееся членство удаляется.
+	RemoveSegmentFromUser(ctx context.Context, id int, segments []int, actor string) error
+
+	// GetUserHistory метод получения истории членства пользователя в сегментах (добавления и исключения
+	// с причиной, источником и инициатором), на вход принимает запрос с фильтрами, позицию последней записи
+	// предыдущей страницы (nil для первой страницы) и максимальное количество записей,
+	// возвращает записи в порядке даты операции и ошибку бд или nil.
+	GetUserHistory(ctx context.Context, req entity.UserHistoryRequest, after *entity.UserHistoryCursor, limit int) ([]entity.ReportUserHistory, error)
 
 	// GetActiveSegmentsIdByName метод получения активных сегментов сервиса,
-	// на вход принимает массив из id сегментов,
-	// возвращает массив из id сегментов и ошибку бд или nil.
-	GetActiveSegmentsIdByName(ctx context.Context, segments []string) ([]int, error)
+	// на вход принимает массив из названий или действующих псевдонимов сегментов,
+	// возвращает массив из id сегментов (без повторов), использованные псевдонимы,
+	// не найденные среди активных сегментов названия и ошибку бд или nil.
+	GetActiveSegmentsIdByName(ctx context.Context, segments []string) ([]int, []entity.SegmentAlias, []string, error)
 
-	// GetActiveSegmentFromUser метод получения активных сегментов пользователя,
+	// FinalizeExpiredMemberships метод фиксации истёкшего по ttl членства пользователей,
+	// помечает членство с прошедшим временем исключения как завершённое с причиной expired
+	// и записывает события исключения в журнал в той же транзакции,
+	// возвращает количество завершённых записей членства и ошибку бд или nil.
+	FinalizeExpiredMemberships(ctx context.Context) (int64, error)
+
+	// GetActiveSegmentFromUser метод получения активных сегментов пользователя
+	// (запланированное членство не учитывается до его начала),
 	// на вход принимает id пользователя,
-	// возвращает массив из названий сегментов и ошибку бд или nil.
-	GetActiveSegmentFromUser(ctx context.Context, id int) ([]string, error)
+	// возвращает массив из названий сегментов с вариантами пользователя и данными сегментов, упорядоченный по id сегментов,
+	// и ошибку бд или nil.
+	GetActiveSegmentFromUser(ctx context.Context, id int) ([]entity.UserSegment, error)
+
+	// GetSegmentsFromUserAt метод получения сегментов, в которых пользователь состоял в указанный момент
+	// (added_at <= at < left_at, сегмент не был удалён и был активен в этот момент), на вход принимает id пользователя
+	// и момент времени, возвращает массив из UserSegment (данные сегмента - текущие) и ошибку бд или nil.
+	GetSegmentsFromUserAt(ctx context.Context, id int, at time.Time) ([]entity.UserSegment, error)
+
+	// GetActiveSegmentsFromUsers метод получения активных сегментов нескольких пользователей одним запросом,
+	// на вход принимает массив из id пользователей,
+	// возвращает map id пользователя -> сегменты с вариантами и данными, упорядоченные по id сегментов
+	// (пользователи без активных сегментов в map отсутствуют), и ошибку бд или nil.
+	GetActiveSegmentsFromUsers(ctx context.Context, ids []int) (map[int][]entity.UserSegment, error)
 
 	// CheckExistUser метод проверки существования пользователя,
 	// на вход принимает id пользователя,
 	// возвращает ошибку бд (в том числе и при не существовании пользователя) или nil.
 	CheckExistUser(ctx context.Context, id int) error
+
+	// SetUserAttributes метод сохранения атрибутов пользователя,
+	// на вход принимает id пользователя и атрибуты, которые объединяются с уже сохранёнными,
+	// возвращает ошибку бд или nil.
+	// При отсутствии пользователя он создаётся.
+	SetUserAttributes(ctx context.Context, id int, attributes map[string]any) error
+
+	// GetUserAttributes метод получения атрибутов пользователя,
+	// на вход принимает id пользователя,
+	// возвращает атрибуты (пустые при их отсутствии) и ошибку бд или nil.
+	GetUserAttributes(ctx context.Context, id int) (map[string]any, error)
+
+	// GetUsersAttributes метод получения атрибутов нескольких пользователей,
+	// на вход принимает массив из id пользователей,
+	// возвращает map id пользователя -> атрибуты (пустые при их отсутствии; неизвестные пользователи в map отсутствуют)
+	// и ошибку бд или nil.
+	GetUsersAttributes(ctx context.Context, ids []int) (map[int]map[string]any, error)
+
+	// ImportSegmentsToUsers метод массового добавления пользователей в сегменты,
+	// на вход принимает строки импорта и признак пробного запуска,
+	// строки загружаются через COPY во временную таблицу, проверяются и применяются в одной транзакции,
+	// возвращает количество добавленных членств, отклонённые строки с причинами и ошибку бд или nil.
+	// При пробном запуске транзакция откатывается.
+	ImportSegmentsToUsers(ctx context.Context, rows []entity.UserImportRow, dryRun bool) (int, []entity.UserImportRejectedRow, error)
+
+	// EraseUser метод удаления пользователя: запланированное членство отменяется, действующее завершается
+	// с причиной user_erased, история членства и события переносятся на псевдоним (отрицательный id),
+	// пользователь и его атрибуты удаляются, события завершения членства записываются уже с псевдонимом,
+	// в журнал удалений записывается квитанция без исходного id,
+	// на вход принимает id пользователя, инициатора удаления (может быть пустым) и функцию, которая по датам
+	// операций пользователя (упорядоченным, без повторов) возвращает месяцы операций (год * 100 + месяц)
+	// и отчеты в Google Drive для перестроения. Функция вызывается в транзакции после блокировки пользователя
+	// и его членства. Задания на отчеты типа file за эти месяцы, кроме завершённых с ошибкой, возвращаются
+	// в очередь, их файлы удаляются, а выполняющиеся задания перестают принадлежать воркеру.
+	// Возвращает квитанцию и ошибку бд, ошибку affected, apperror.ErrNoUser или nil.
+	EraseUser(ctx context.Context, id int, actor string,
+		affected func(dates []time.Time) ([]int, []string, error)) (entity.UserErasureReceipt, error)
 }
 
 // ReportRepo Методы репозитория отчета
 type ReportRepo interface {
-	// GetSegmentHistoryFromUser метод получения истории пользователей (вхождение/исключение из сегментов),
-	// на вход принимает месяц и год,
-	// возвращает массив из ReportUserHistory ошибку бд или nil.
-	GetSegmentHistoryFromUser(ctx context.Context, month int, year int) ([]entity.ReportUserHistory, error)
+	// StreamSegmentHistory метод построчного чтения истории пользователей (вхождение/исключение из сегментов)
+	// через серверный курсор, на вход принимает период [from, to) с фильтрами и функцию, вызываемую для каждой записи
+	// в порядке даты операции, возвращает ошибку бд, ошибку функции или nil.
+	// Добавления отбираются по дате добавления, исключения - по дате исключения.
+	StreamSegmentHistory(ctx context.Context, req entity.ReportRequest, fn func(entity.ReportUserHistory) error) error
+
+	// CountSegmentHistory метод подсчета записей истории пользователей за период с фильтрами,
+	// возвращает количество записей и ошибку бд или nil.
+	CountSegmentHistory(ctx context.Context, req entity.ReportRequest) (int, error)
+}
+
+// ReportJobRepo Методы репозитория заданий на построение отчетов
+type ReportJobRepo interface {
+	// CreateReportJob метод создания задания, на вход принимает тип отчета (file или link), месяц и год,
+	// возвращает id задания и ошибку бд или nil
+	CreateReportJob(ctx context.Context, jobType string, month int, year int) (int64, error)
+
+	// GetReportJob метод получения задания, на вход принимает id задания,
+	// возвращает задание и ошибку бд (в том числе и при не существовании задания) или nil
+	GetReportJob(ctx context.Context, id int64) (entity.ReportJob, error)
+
+	// StreamReportJobFile метод передачи файла отчета выполненного задания типа file в fn по частям в порядке записи,
+	// на вход принимает id задания,
+	// возвращает ошибку fn, ошибку бд, apperror.ErrNoReportJob, apperror.ErrReportNotReady или nil
+	StreamReportJobFile(ctx context.Context, id int64, fn func([]byte) error) error
+
+	// ClaimReportJob метод захвата задания воркером на время аренды,
+	// захватывается самое раннее ожидающее задание или задание с истёкшей арендой, части файла
+	// прерванных попыток удаляются, attempts захваченного задания - номер попытки воркера,
+	// возвращает задание и ошибку бд (в том числе при отсутствии заданий) или nil
+	ClaimReportJob(ctx context.Context, lease time.Duration) (entity.ReportJob, error)
+
+	// Методы ниже принимают id задания и номер попытки, полученный при захвате, и возвращают
+	// apperror.ErrReportJobLost, если задание уже не выполняется этой попыткой (аренда истекла и задание
+	// захвачено повторно или возвращено в очередь)
+
+	// SaveReportJobFilePart метод сохранения очередной части файла отчета,
+	// на вход принимает порядковый номер части и её содержимое, возвращает ошибку бд или nil
+	SaveReportJobFilePart(ctx context.Context, id int64, attempt int, part int, data []byte) error
+
+	// UpdateReportJobProgress метод сохранения прогресса задания (0.0-1.0) с продлением аренды,
+	// возвращает ошибку бд или nil
+	UpdateReportJobProgress(ctx context.Context, id int64, attempt int, progress float32, lease time.Duration) error
+
+	// CompleteReportJob метод завершения задания, на вход принимает ссылку на отчет
+	// (пустая для заданий типа file), возвращает ошибку бд или nil
+	CompleteReportJob(ctx context.Context, id int64, attempt int, link string) error
+
+	// FailReportJob метод завершения задания с ошибкой, на вход принимает причину,
+	// возвращает ошибку бд или nil
+	FailReportJob(ctx context.Context, id int64, attempt int, reason string) error
+
+	// ReleaseReportJob метод возврата задания в очередь для повторной попытки (например, при остановке сервиса),
+	// возвращает ошибку бд или nil
+	ReleaseReportJob(ctx context.Context, id int64, attempt int) error
+}
+
+// PurgeRepo Методы репозитория окончательного удаления истории
+type PurgeRepo interface {
+	// CreateSegmentPurgeJob метод создания задания на удаление сегмента со всей историей,
+	// на вход принимает название удалённого сегмента и признак сохранения архива, возвращает id задания
+	// (id незавершённого задания, если сегмент уже ожидает удаления) и ошибку бд, apperror.ErrNoDeletedSegment или nil
+	CreateSegmentPurgeJob(ctx context.Context, segment string, archive bool) (int64, error)
+
+	// CreateRetentionPurgeJob метод создания задания на удаление истории, завершённой до cutoff,
+	// на вход принимает границу и признак сохранения архива, возвращает id задания (0, если задание retention
+	// уже ожидает или выполняется) и ошибку бд или nil
+	CreateRetentionPurgeJob(ctx context.Context, cutoff time.Time, archive bool) (int64, error)
+
+	// GetPurgeJob метод получения задания, на вход принимает id задания,
+	// возвращает задание и ошибку бд, apperror.ErrNoPurgeJob или nil
+	GetPurgeJob(ctx context.Context, id int64) (entity.PurgeJob, error)
+
+	// StreamPurgeJobFile метод чтения архива выполненного задания по частям (без заголовка csv),
+	// на вход принимает id задания и функцию, вызываемую для каждой части по порядку,
+	// возвращает ошибку бд, ошибку fn, apperror.ErrNoPurgeJob, apperror.ErrPurgeArchiveNotReady или nil
+	StreamPurgeJobFile(ctx context.Context, id int64, fn func([]byte) error) error
+
+	// ClaimPurgeJob метод захвата задания воркером на время аренды,
+	// захватывается самое раннее ожидающее задание или задание с истёкшей арендой,
+	// attempts захваченного задания - номер попытки воркера,
+	// возвращает задание и ошибку бд, apperror.ErrNoPurgeJob (при отсутствии заданий) или nil
+	ClaimPurgeJob(ctx context.Context, lease time.Duration) (entity.PurgeJob, error)
+
+	// Методы ниже принимают id задания и номер попытки, полученный при захвате, и возвращают
+	// apperror.ErrPurgeJobLost, если задание уже не выполняется этой попыткой (аренда истекла и задание
+	// захвачено повторно или возвращено в очередь)
+
+	// ExtendPurgeJobLease метод продления аренды задания, возвращает ошибку бд или nil
+	ExtendPurgeJobLease(ctx context.Context, id int64, attempt int, lease time.Duration) error
+
+	// CompletePurgeJob метод завершения задания, возвращает ошибку бд или nil
+	CompletePurgeJob(ctx context.Context, id int64, attempt int) error
+
+	// FailPurgeJob метод завершения задания с ошибкой, на вход принимает причину,
+	// возвращает ошибку бд или nil
+	FailPurgeJob(ctx context.Context, id int64, attempt int, reason string) error
+
+	// ReleasePurgeJob метод возврата задания в очередь, количество удалённых записей и архив сохраняются,
+	// возвращает ошибку бд или nil
+	ReleasePurgeJob(ctx context.Context, id int64, attempt int) error
+
+	// PurgeSegmentHistory метод удаления пачки записей членства удалённого сегмента,
+	// на вход принимает id сегмента, размер пачки и функцию кодирования операций удалённых записей
+	// в часть архива (nil - без архива), часть архива и количество удалённых записей задания сохраняются
+	// в той же транзакции, возвращает количество удалённых записей (0 и для восстановленного сегмента)
+	// и ошибку бд, ошибку archive или nil
+	PurgeSegmentHistory(ctx context.Context, jobId int64, attempt int, segmentId int, limit int,
+		archive func([]entity.ReportUserHistory) ([]byte, error)) (int64, error)
+
+	// PurgeClosedHistory метод удаления пачки завершённых записей членства, исключение по которым
+	// зафиксировано до cutoff, на вход принимает границу, размер пачки и функцию кодирования
+	// операций удалённых записей в часть архива (nil - без архива), часть архива и количество удалённых
+	// записей задания сохраняются в той же транзакции, возвращает количество удалённых записей
+	// и ошибку бд, ошибку archive или nil
+	PurgeClosedHistory(ctx context.Context, jobId int64, attempt int, cutoff time.Time, limit int,
+		archive func([]entity.ReportUserHistory) ([]byte, error)) (int64, error)
+
+	// GetSegmentsDeletedBefore метод получения id сегментов, удалённых до cutoff,
+	// возвращает массив id и ошибку бд или nil
+	GetSegmentsDeletedBefore(ctx context.Context, cutoff time.Time) ([]int, error)
+
+	// PurgeSegment метод удаления удалённого сегмента вместе с вариантами и псевдонимами
+	// (история членства должна быть удалена заранее), возвращает ошибку бд или nil
+	PurgeSegment(ctx context.Context, segmentId int) error
+}
+
+// LayerRepo Методы репозитория слоёв взаимоисключающих сегментов
+type LayerRepo interface {
+	// CreateLayer метод создания слоя, на вход принимает название,
+	// возвращает ошибку бд или nil
+	CreateLayer(ctx context.Context, layer string) error
+
+	// GetLayerIdByName метод получения id слоя, на вход принимает название,
+	// возвращает id слоя и ошибку бд (в том числе и при не существовании слоя) или nil
+	GetLayerIdByName(ctx context.Context, layer string) (int, error)
+}
+
+// WebhookRepo Методы репозитория вебхуков и доставки событий
+type WebhookRepo interface {
+	// CreateWebhook метод создания подписки, на вход принимает url, типы событий и секрет для подписи,
+	// возвращает id подписки и ошибку бд или nil
+	CreateWebhook(ctx context.Context, webhook entity.Webhook) (int, error)
+
+	// DeleteWebhook метод удаления подписки, на вход принимает id подписки,
+	// возвращает ошибку бд (в том числе и при не существовании подписки) или nil
+	DeleteWebhook(ctx context.Context, id int) error
+
+	// GetWebhooks метод получения активных подписок,
+	// возвращает массив подписок (без секретов) и ошибку бд или nil
+	GetWebhooks(ctx context.Context) ([]entity.Webhook, error)
+
+	// DispatchEvents метод распределения новых событий журнала по подпискам и присвоения им номеров в потоке,
+	// на вход принимает максимальное количество событий,
+	// возвращает количество обработанных событий и ошибку бд или nil
+	DispatchEvents(ctx context.Context, limit int) (int, error)
+
+	// ClaimDeliveries метод захвата доставок, время попытки которых наступило,
+	// на вход принимает максимальное количество доставок и время, на которое доставки захватываются,
+	// возвращает доставки с увеличенным счётчиком попыток и ошибку бд или nil
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]entity.WebhookDelivery, error)
+
+	// MarkDelivered метод отметки доставки как выполненной, на вход принимает id доставки,
+	// возвращает ошибку бд или nil
+	MarkDelivered(ctx context.Context, id int64) error
+
+	// RetryDelivery метод переноса доставки на следующую попытку,
+	// на вход принимает id доставки, время следующей попытки и текст ошибки,
+	// возвращает ошибку бд или nil
+	RetryDelivery(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error
+
+	// DeadLetterDelivery метод переноса доставки в таблицу недоставленных событий,
+	// на вход принимает id доставки и текст ошибки,
+	// возвращает ошибку бд или nil
+	DeadLetterDelivery(ctx context.Context, id int64, lastError string) error
+}
+
+// EventRepo Методы репозитория журнала событий
+type EventRepo interface {
+	// GetEvents метод получения распределённых событий журнала после указанного номера в потоке,
+	// на вход принимает номер последнего полученного события, [опционально] id пользователя
+	// (вместе с событиями пользователя возвращаются события сегментов), [опционально] название сегмента
+	// и максимальное количество событий,
+	// возвращает события в порядке номеров и ошибку бд или nil
+	GetEvents(ctx context.Context, afterSeq int64, userId int, segment string, limit int) ([]entity.Event, error)
+
+	// GetLastEventSeq метод получения номера последнего распределённого события журнала,
+	// возвращает номер (0 для пустого журнала) и ошибку бд или nil
+	GetLastEventSeq(ctx context.Context) (int64, error)
 }
 
 type Repositories struct {
 	SegmentRepo
 	UserRepo
 	ReportRepo
+	ReportJobRepo
+	PurgeRepo
+	LayerRepo
+	WebhookRepo
+	EventRepo
 }
 
 func NewRepositories(pg *postgresdb.Postgres) *Repositories {
 	return &Repositories{
-		SegmentRepo: pgdb.NewSegmentRepo(pg),
-		UserRepo:    pgdb.NewUserRepo(pg),
-		ReportRepo:  pgdb.NewReportRepo(pg),
+		SegmentRepo:   pgdb.NewSegmentRepo(pg),
+		UserRepo:      pgdb.NewUserRepo(pg),
+		ReportRepo:    pgdb.NewReportRepo(pg),
+		ReportJobRepo: pgdb.NewReportJobRepo(pg),
+		PurgeRepo:     pgdb.NewPurgeRepo(pg),
+		LayerRepo:     pgdb.NewLayerRepo(pg),
+		WebhookRepo:   pgdb.NewWebhookRepo(pg),
+		EventRepo:     pgdb.NewEventRepo(pg),
 	}
 }
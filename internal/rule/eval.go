@@ -0,0 +1,195 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindNumber
+	kindDate
+	kindBool
+)
+
+type value struct {
+	kind valueKind
+	str  string
+	num  float64
+	date time.Time
+	b    bool
+}
+
+type node interface {
+	eval(attributes map[string]any) bool
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n orNode) eval(attributes map[string]any) bool {
+	return n.left.eval(attributes) || n.right.eval(attributes)
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n andNode) eval(attributes map[string]any) bool {
+	return n.left.eval(attributes) && n.right.eval(attributes)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(attributes map[string]any) bool {
+	return !n.operand.eval(attributes)
+}
+
+type compareNode struct {
+	attr  string
+	op    string
+	value value
+}
+
+// eval сравнивает атрибут с литералом, отсутствующий атрибут не удовлетворяет ни одному сравнению.
+// Для атрибутов-массивов достаточно совпадения хотя бы одного элемента.
+func (n compareNode) eval(attributes map[string]any) bool {
+	attr, ok := attributes[n.attr]
+	if !ok || attr == nil {
+		return false
+	}
+
+	if list, ok := attr.([]any); ok {
+		for _, item := range list {
+			if n.compare(item) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return n.compare(attr)
+}
+
+func (n compareNode) compare(attr any) bool {
+	cmp, ok := compare(attr, n.value)
+	if !ok {
+		return false
+	}
+
+	switch n.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<", "before":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">", "after":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+
+	return false
+}
+
+type inNode struct {
+	attr   string
+	values []value
+}
+
+func (n inNode) eval(attributes map[string]any) bool {
+	for _, val := range n.values {
+		if (compareNode{attr: n.attr, op: "==", value: val}).eval(attributes) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compare возвращает -1, 0 или 1 в зависимости от соотношения атрибута и литерала,
+// второе значение false, если атрибут нельзя привести к типу литерала
+func compare(attr any, val value) (int, bool) {
+	switch val.kind {
+	case kindNumber:
+		var num float64
+		switch a := attr.(type) {
+		case float64:
+			num = a
+		case int:
+			num = float64(a)
+		case string:
+			parsed, err := strconv.ParseFloat(a, 64)
+			if err != nil {
+				return 0, false
+			}
+			num = parsed
+		default:
+			return 0, false
+		}
+
+		return compareOrdered(num, val.num), true
+	case kindDate:
+		a, ok := attr.(string)
+		if !ok {
+			return 0, false
+		}
+		date, err := parseTime(a)
+		if err != nil {
+			return 0, false
+		}
+
+		return date.Compare(val.date), true
+	case kindBool:
+		a, ok := attr.(bool)
+		if !ok {
+			return 0, false
+		}
+		if a == val.b {
+			return 0, true
+		}
+		if !a {
+			return -1, true
+		}
+
+		return 1, true
+	default:
+		return strings.Compare(fmt.Sprint(attr), val.str), true
+	}
+}
+
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isDate(s string) bool {
+	_, err := parseTime(s)
+	return err == nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	date, err := time.Parse(time.DateOnly, s)
+	if err == nil {
+		return date, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
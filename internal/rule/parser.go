@@ -0,0 +1,251 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rule разобранное правило таргетинга сегмента.
+//
+// Грамматика:
+//
+//	expr       = and { "OR" and }
+//	and        = not { "AND" not }
+//	not        = "NOT" not | "(" expr ")" | comparison
+//	comparison = attr ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "before" | "after" ) value
+//	           | attr [ "NOT" ] "IN" "(" value { "," value } ")"
+//	           | attr_before value | attr_after value
+//	value      = string | number | date | "true" | "false"
+//
+// Ключевые слова регистронезависимы, строки задаются в двойных или одинарных кавычках,
+// даты в формате 2006-01-02 или RFC3339.
+type Rule struct {
+	root node
+}
+
+// Parse разбирает правило, возвращает ошибку с позицией при синтаксической ошибке
+func Parse(src string) (*Rule, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.value, t.pos)
+	}
+
+	return &Rule{root: root}, nil
+}
+
+// Match проверяет, удовлетворяют ли атрибуты пользователя правилу
+func (r *Rule) Match(attributes map[string]any) bool {
+	return r.root.eval(attributes)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.value, keyword)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.isKeyword("not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return notNode{operand: operand}, nil
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, fmt.Errorf("expected \")\" at position %d", t.pos)
+		}
+
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	attr := p.next()
+	if attr.kind != tokenIdent {
+		return nil, fmt.Errorf("expected attribute name at position %d", attr.pos)
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokenOperator:
+		p.next()
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		return compareNode{attr: attr.value, op: t.value, value: val}, nil
+	case p.isKeyword("before") || p.isKeyword("after"):
+		p.next()
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		return compareNode{attr: attr.value, op: strings.ToLower(t.value), value: val}, nil
+	case p.isKeyword("in"):
+		p.next()
+
+		return p.parseIn(attr.value, false)
+	case p.isKeyword("not"):
+		p.next()
+		if !p.isKeyword("in") {
+			return nil, fmt.Errorf("expected IN at position %d", p.peek().pos)
+		}
+		p.next()
+
+		return p.parseIn(attr.value, true)
+	}
+
+	// Сокращённая запись вида registered_before 2023-01-01
+	name := strings.ToLower(attr.value)
+	for _, op := range []string{"before", "after"} {
+		if strings.HasSuffix(name, "_"+op) && len(name) > len(op)+1 {
+			val, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+
+			return compareNode{attr: attr.value[:len(attr.value)-len(op)-1], op: op, value: val}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("expected operator after %q at position %d", attr.value, t.pos)
+}
+
+func (p *parser) parseIn(attr string, negate bool) (node, error) {
+	if t := p.next(); t.kind != tokenLParen {
+		return nil, fmt.Errorf("expected \"(\" at position %d", t.pos)
+	}
+
+	var values []value
+	for {
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+
+		t := p.next()
+		if t.kind == tokenRParen {
+			break
+		}
+		if t.kind != tokenComma {
+			return nil, fmt.Errorf("expected \",\" or \")\" at position %d", t.pos)
+		}
+	}
+
+	var n node = inNode{attr: attr, values: values}
+	if negate {
+		n = notNode{operand: n}
+	}
+
+	return n, nil
+}
+
+func (p *parser) parseValue() (value, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return value{kind: kindString, str: t.value}, nil
+	case tokenNumber:
+		num, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return value{}, fmt.Errorf("invalid number %q at position %d", t.value, t.pos)
+		}
+
+		return value{kind: kindNumber, num: num, str: t.value}, nil
+	case tokenDate:
+		date, err := parseTime(t.value)
+		if err != nil {
+			return value{}, fmt.Errorf("invalid date %q at position %d: %w", t.value, t.pos, err)
+		}
+
+		return value{kind: kindDate, date: date, str: t.value}, nil
+	case tokenIdent:
+		switch strings.ToLower(t.value) {
+		case "true":
+			return value{kind: kindBool, b: true, str: "true"}, nil
+		case "false":
+			return value{kind: kindBool, b: false, str: "false"}, nil
+		}
+	}
+
+	return value{}, fmt.Errorf("expected value at position %d", t.pos)
+}
@@ -0,0 +1,96 @@
+package rule_test
+
+import (
+	"avito-internship/internal/rule"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	attributes := map[string]any{
+		"city":       "Kazan",
+		"platform":   "ios",
+		"registered": "2022-05-17",
+		"age":        float64(27),
+		"premium":    true,
+		"tags":       []any{"seller", "pro"},
+	}
+
+	testCases := []struct {
+		name string
+		rule string
+		want bool
+	}{
+		{
+			name: "In_and_date_and_eq",
+			rule: `city in ("Moscow","Kazan") AND registered_before 2023-01-01 AND platform == "ios"`,
+			want: true,
+		},
+		{
+			name: "Not_in",
+			rule: `city not in ('Moscow', 'Kazan')`,
+			want: false,
+		},
+		{
+			name: "Date_after",
+			rule: `registered after 2023-01-01`,
+			want: false,
+		},
+		{
+			name: "Number_comparison",
+			rule: `age >= 18 and age < 30`,
+			want: true,
+		},
+		{
+			name: "Or_with_parens",
+			rule: `(platform == "android" OR premium == true) AND NOT city = "Moscow"`,
+			want: true,
+		},
+		{
+			name: "List_attribute",
+			rule: `tags in ("pro")`,
+			want: true,
+		},
+		{
+			name: "Missing_attribute",
+			rule: `country == "RU"`,
+			want: false,
+		},
+		{
+			name: "Missing_attribute_negated",
+			rule: `NOT country == "RU"`,
+			want: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := rule.Parse(tc.rule)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.want, r.Match(attributes))
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule string
+	}{
+		{name: "Empty", rule: ``},
+		{name: "No_operator", rule: `city "Moscow"`},
+		{name: "No_value", rule: `city ==`},
+		{name: "Unclosed_paren", rule: `(city == "Moscow"`},
+		{name: "Unclosed_list", rule: `city in ("Moscow", "Kazan"`},
+		{name: "Unterminated_string", rule: `city == "Moscow`},
+		{name: "Trailing_tokens", rule: `city == "Moscow" platform == "ios"`},
+		{name: "Unknown_symbol", rule: `city ~ "Moscow"`},
+		{name: "Impossible_date", rule: `registered_before 2023-13-45`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := rule.Parse(tc.rule)
+			assert.Error(t, err)
+		})
+	}
+}
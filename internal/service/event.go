@@ -0,0 +1,49 @@
+package service
+
+import (
+	"avito-internship/internal/entity"
+	"avito-internship/internal/repository"
+	"context"
+	"fmt"
+)
+
+// eventsBatchSize максимальное количество событий, возвращаемых за один запрос к журналу
+const eventsBatchSize = 100
+
+type EventService struct {
+	eventRepo repository.EventRepo
+}
+
+func NewEventService(eventRepo repository.EventRepo) *EventService {
+	return &EventService{eventRepo: eventRepo}
+}
+
+func (s *EventService) StreamEvents(ctx context.Context, req entity.EventStreamRequest, afterSeq int64, fn func(entity.Event) error) error {
+	// Журнал читается до конца, чтобы подписчик не отставал после массовых изменений (например, раскатки)
+	for {
+		events, err := s.eventRepo.GetEvents(ctx, afterSeq, req.UserId, req.Segment, eventsBatchSize)
+		if err != nil {
+			return fmt.Errorf("eventRepo.GetEvents: %w", err)
+		}
+
+		for _, event := range events {
+			if err = fn(event); err != nil {
+				return err
+			}
+			afterSeq = event.Seq
+		}
+
+		if len(events) < eventsBatchSize {
+			return nil
+		}
+	}
+}
+
+func (s *EventService) GetLastEventSeq(ctx context.Context) (int64, error) {
+	lastSeq, err := s.eventRepo.GetLastEventSeq(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("eventRepo.GetLastEventSeq: %w", err)
+	}
+
+	return lastSeq, nil
+}
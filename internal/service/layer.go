@@ -0,0 +1,25 @@
+package service
+
+import (
+	"avito-internship/internal/entity"
+	"avito-internship/internal/repository"
+	"context"
+	"fmt"
+)
+
+type LayerService struct {
+	layerRepo repository.LayerRepo
+}
+
+func NewLayerService(layerRepo repository.LayerRepo) *LayerService {
+	return &LayerService{layerRepo: layerRepo}
+}
+
+func (s *LayerService) CreateLayer(ctx context.Context, req entity.LayerRequest) error {
+	err := s.layerRepo.CreateLayer(ctx, req.Layer)
+	if err != nil {
+		return fmt.Errorf("layerRepo.CreateLayer: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/repository"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// purgeJobLease время, на которое задание захватывается воркером, продлевается после каждой пачки
+	purgeJobLease = 5 * time.Minute
+	// purgeJobMaxAttempts количество попыток, после которого задание завершается с ошибкой
+	purgeJobMaxAttempts = 3
+	// purgeBatchSize количество записей членства, удаляемых одним запросом
+	purgeBatchSize = 1000
+)
+
+type PurgeService struct {
+	purgeRepo        repository.PurgeRepo
+	retentionMonths  int
+	retentionArchive bool
+}
+
+func NewPurgeService(purgeRepo repository.PurgeRepo, retentionMonths int, retentionArchive bool) *PurgeService {
+	return &PurgeService{
+		purgeRepo:        purgeRepo,
+		retentionMonths:  retentionMonths,
+		retentionArchive: retentionArchive,
+	}
+}
+
+func (s *PurgeService) CreatePurgeJob(ctx context.Context, req entity.PurgeJobRequest) (int64, error) {
+	id, err := s.purgeRepo.CreateSegmentPurgeJob(ctx, req.Segment, req.Archive)
+	if err != nil {
+		return 0, fmt.Errorf("purgeRepo.CreateSegmentPurgeJob: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *PurgeService) GetPurgeJob(ctx context.Context, id int64) (entity.PurgeJob, error) {
+	job, err := s.purgeRepo.GetPurgeJob(ctx, id)
+	if err != nil {
+		return entity.PurgeJob{}, fmt.Errorf("purgeRepo.GetPurgeJob: %w", err)
+	}
+
+	return job, nil
+}
+
+func (s *PurgeService) WritePurgeJobFile(ctx context.Context, id int64, w io.Writer) error {
+	// Заголовок записывается перед первой частью, чтобы до проверки готовности архива в w ничего не попало
+	header := false
+	writeHeader := func() error {
+		if header {
+			return nil
+		}
+		header = true
+
+		cw := csv.NewWriter(w)
+		_ = cw.Write(reportCSVHeader)
+		cw.Flush()
+
+		return cw.Error()
+	}
+
+	err := s.purgeRepo.StreamPurgeJobFile(ctx, id, func(data []byte) error {
+		if err := writeHeader(); err != nil {
+			return err
+		}
+		_, err := w.Write(data)
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("purgeRepo.StreamPurgeJobFile: %w", err)
+	}
+
+	return writeHeader()
+}
+
+func (s *PurgeService) EnforceRetention(ctx context.Context) (int64, error) {
+	if s.retentionMonths <= 0 {
+		return 0, nil
+	}
+
+	cutoff := time.Now().AddDate(0, -s.retentionMonths, 0)
+	id, err := s.purgeRepo.CreateRetentionPurgeJob(ctx, cutoff, s.retentionArchive)
+	if err != nil {
+		return 0, fmt.Errorf("purgeRepo.CreateRetentionPurgeJob: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *PurgeService) ProcessPurgeJobs(ctx context.Context) (int, error) {
+	processed := 0
+	for ctx.Err() == nil {
+		job, err := s.purgeRepo.ClaimPurgeJob(ctx, purgeJobLease)
+		if err != nil {
+			if errors.Is(err, apperror.ErrNoPurgeJob) {
+				return processed, nil
+			}
+
+			return processed, fmt.Errorf("purgeRepo.ClaimPurgeJob: %w", err)
+		}
+
+		err = s.processPurgeJob(ctx, job)
+		if err != nil {
+			return processed, err
+		}
+		processed++
+	}
+
+	return processed, nil
+}
+
+// processPurgeJob выполняет задание и сохраняет результат. При остановке сервиса и ошибках задание
+// возвращается в очередь и продолжается с места остановки, после исчерпания попыток завершается с ошибкой.
+func (s *PurgeService) processPurgeJob(ctx context.Context, job entity.PurgeJob) error {
+	purgeErr := s.purge(ctx, job)
+	if purgeErr == nil {
+		err := s.purgeRepo.CompletePurgeJob(ctx, job.Id, job.Attempts)
+		if err != nil {
+			return fmt.Errorf("purgeRepo.CompletePurgeJob: %w", err)
+		}
+
+		return nil
+	}
+
+	if errors.Is(purgeErr, apperror.ErrPurgeJobLost) {
+		// Задание выполняет другой воркер
+		return fmt.Errorf("purge job %d: %w", job.Id, purgeErr)
+	}
+
+	if ctx.Err() != nil || job.Attempts < purgeJobMaxAttempts {
+		err := releaseJob(ctx, func(ctx context.Context) error {
+			return s.purgeRepo.ReleasePurgeJob(ctx, job.Id, job.Attempts)
+		})
+		if err != nil {
+			return fmt.Errorf("purgeRepo.ReleasePurgeJob: %w", err)
+		}
+
+		return fmt.Errorf("purge job %d: %w", job.Id, purgeErr)
+	}
+
+	err := s.purgeRepo.FailPurgeJob(ctx, job.Id, job.Attempts, purgeErr.Error())
+	if err != nil {
+		return fmt.Errorf("purgeRepo.FailPurgeJob: %w", err)
+	}
+
+	return fmt.Errorf("purge job %d: %w", job.Id, purgeErr)
+}
+
+// purge удаляет историю задания пачками, сохраняя операции удалённых записей в архив, если он запрошен
+func (s *PurgeService) purge(ctx context.Context, job entity.PurgeJob) error {
+	var archive func([]entity.ReportUserHistory) ([]byte, error)
+	if job.Archive {
+		archive = encodeArchive
+	}
+
+	if job.Type == entity.PurgeJobTypeSegment {
+		return s.purgeSegment(ctx, job, job.SegmentId, archive)
+	}
+
+	err := s.purgeBatches(ctx, job, func(ctx context.Context) (int64, error) {
+		return s.purgeRepo.PurgeClosedHistory(ctx, job.Id, job.Attempts, *job.Cutoff, purgeBatchSize, archive)
+	})
+	if err != nil {
+		return err
+	}
+
+	segmentIds, err := s.purgeRepo.GetSegmentsDeletedBefore(ctx, *job.Cutoff)
+	if err != nil {
+		return fmt.Errorf("purgeRepo.GetSegmentsDeletedBefore: %w", err)
+	}
+
+	for _, segmentId := range segmentIds {
+		err = s.purgeSegment(ctx, job, segmentId, archive)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeSegment удаляет историю членства удалённого сегмента пачками, затем сам сегмент
+func (s *PurgeService) purgeSegment(ctx context.Context, job entity.PurgeJob, segmentId int,
+	archive func([]entity.ReportUserHistory) ([]byte, error)) error {
+	err := s.purgeBatches(ctx, job, func(ctx context.Context) (int64, error) {
+		return s.purgeRepo.PurgeSegmentHistory(ctx, job.Id, job.Attempts, segmentId, purgeBatchSize, archive)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.purgeRepo.PurgeSegment(ctx, segmentId)
+	if err != nil {
+		return fmt.Errorf("purgeRepo.PurgeSegment: %w", err)
+	}
+
+	return nil
+}
+
+// purgeBatches вызывает purge, пока удаляются полные пачки, продлевая аренду задания после каждой пачки
+func (s *PurgeService) purgeBatches(ctx context.Context, job entity.PurgeJob, purge func(ctx context.Context) (int64, error)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		purged, err := purge(ctx)
+		if err != nil {
+			return fmt.Errorf("purge batch: %w", err)
+		}
+		if purged == 0 {
+			return nil
+		}
+
+		err = s.purgeRepo.ExtendPurgeJobLease(ctx, job.Id, job.Attempts, purgeJobLease)
+		if err != nil {
+			return fmt.Errorf("purgeRepo.ExtendPurgeJobLease: %w", err)
+		}
+
+		if purged < purgeBatchSize {
+			return nil
+		}
+	}
+}
+
+// encodeArchive возвращает строки csv отчета (без заголовка) для операций удалённой пачки
+func encodeArchive(history []entity.ReportUserHistory) ([]byte, error) {
+	b := bytes.Buffer{}
+	cw := csv.NewWriter(&b)
+	for _, item := range history {
+		err := cw.Write(reportCSVRecord(item))
+		if err != nil {
+			return nil, fmt.Errorf("purgeService.encodeArchive - w.Write: %w", err)
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return nil, fmt.Errorf("purgeService.encodeArchive - w.Error(): %w", err)
+	}
+
+	return b.Bytes(), nil
+}
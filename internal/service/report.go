@@ -8,47 +8,267 @@ import (
 	"bytes"
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
-	"sort"
+	"hash/fnv"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// reportJobLease время, на которое задание захватывается воркером, продлевается при обновлении прогресса
+	reportJobLease = 5 * time.Minute
+	// reportJobMaxAttempts количество попыток, после которого задание завершается с ошибкой
+	reportJobMaxAttempts = 3
+	// reportProgressStep количество строк отчета, после записи которых сохраняется прогресс задания
+	reportProgressStep = 10000
+	// reportProgressCounted прогресс задания после подсчета строк отчета
+	reportProgressCounted = 0.1
+	// reportProgressWritten прогресс задания после записи всех строк отчета
+	reportProgressWritten = 0.9
+	// reportFilePartSize минимальный размер части файла отчета задания, сохраняемой в бд
+	reportFilePartSize = 1 << 20
 )
 
 type ReportService struct {
-	reportRepo repository.ReportRepo
-	gDrive     webapi.GDrive
+	reportRepo    repository.ReportRepo
+	reportJobRepo repository.ReportJobRepo
+	gDrive        webapi.GDrive
 }
 
-func NewReportService(reportRepo repository.ReportRepo, gDrive webapi.GDrive) *ReportService {
+func NewReportService(reportRepo repository.ReportRepo, reportJobRepo repository.ReportJobRepo, gDrive webapi.GDrive) *ReportService {
 	return &ReportService{
-		reportRepo: reportRepo,
-		gDrive:     gDrive,
+		reportRepo:    reportRepo,
+		reportJobRepo: reportJobRepo,
+		gDrive:        gDrive,
 	}
 }
 
 func (s *ReportService) GetUserHistory(ctx context.Context, req entity.ReportRequest) ([]entity.ReportUserHistory, error) {
-	userHistory, err := s.reportRepo.GetSegmentHistoryFromUser(ctx, req.Month, req.Year)
+	var userHistory []entity.ReportUserHistory
+	err := s.StreamUserHistory(ctx, req, func(record entity.ReportUserHistory) error {
+		userHistory = append(userHistory, record)
+
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("reportRepo.GetSegmentHistoryFromUser: %w", err)
+		return nil, err
 	}
-	sortByDate := func(i, j int) bool {
-		return userHistory[i].Date.Before(userHistory[j].Date)
+
+	return userHistory, nil
+}
+
+func (s *ReportService) StreamUserHistory(ctx context.Context, req entity.ReportRequest, fn func(entity.ReportUserHistory) error) error {
+	req, err := normalizeReportRequest(req)
+	if err != nil {
+		return err
 	}
 
-	sort.SliceStable(userHistory, sortByDate)
+	err = s.reportRepo.StreamSegmentHistory(ctx, req, fn)
+	if err != nil {
+		return fmt.Errorf("reportRepo.StreamSegmentHistory: %w", err)
+	}
 
-	return userHistory, nil
+	return nil
 }
 
 func (s *ReportService) MakeReportLink(ctx context.Context, req entity.ReportRequest) (string, error) {
+	req, err := normalizeReportRequest(req)
+	if err != nil {
+		return "", err
+	}
+
 	if !s.gDrive.IsAvailable() {
 		return "", apperror.ErrGDriveNotAvailable
 	}
 
-	file, err := s.MakeReportFile(ctx, req)
+	return s.uploadReport(ctx, req, nil)
+}
+
+func (s *ReportService) WriteReportFile(ctx context.Context, req entity.ReportRequest, w io.Writer) error {
+	return s.writeReportCSV(ctx, req, w, nil)
+}
+
+func (s *ReportService) CreateReportJob(ctx context.Context, req entity.ReportJobRequest) (int64, error) {
+	if req.Type != entity.ReportJobTypeFile && req.Type != entity.ReportJobTypeLink || req.Month < 1 || req.Month > 12 {
+		return 0, apperror.ErrWrongReportJob
+	}
+
+	if req.Type == entity.ReportJobTypeLink && !s.gDrive.IsAvailable() {
+		return 0, apperror.ErrGDriveNotAvailable
+	}
+
+	id, err := s.reportJobRepo.CreateReportJob(ctx, req.Type, req.Month, req.Year)
+	if err != nil {
+		return 0, fmt.Errorf("reportJobRepo.CreateReportJob: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *ReportService) GetReportJob(ctx context.Context, id int64) (entity.ReportJob, error) {
+	job, err := s.reportJobRepo.GetReportJob(ctx, id)
 	if err != nil {
-		return "", fmt.Errorf("reportRepo.MakeReportFile: %w", err)
+		return entity.ReportJob{}, fmt.Errorf("reportJobRepo.GetReportJob: %w", err)
 	}
 
-	url, err := s.gDrive.UploadCSVFile(ctx, fmt.Sprintf("report_%d_%d.csv", req.Month, req.Year), file)
+	return job, nil
+}
+
+func (s *ReportService) WriteReportJobFile(ctx context.Context, id int64, w io.Writer) error {
+	err := s.reportJobRepo.StreamReportJobFile(ctx, id, func(data []byte) error {
+		_, err := w.Write(data)
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("reportJobRepo.StreamReportJobFile: %w", err)
+	}
+
+	return nil
+}
+
+func (s *ReportService) ProcessReportJobs(ctx context.Context) (int, error) {
+	processed := 0
+	for ctx.Err() == nil {
+		job, err := s.reportJobRepo.ClaimReportJob(ctx, reportJobLease)
+		if err != nil {
+			if errors.Is(err, apperror.ErrNoReportJob) {
+				return processed, nil
+			}
+
+			return processed, fmt.Errorf("reportJobRepo.ClaimReportJob: %w", err)
+		}
+
+		err = s.processReportJob(ctx, job)
+		if err != nil {
+			return processed, err
+		}
+		processed++
+	}
+
+	return processed, nil
+}
+
+// processReportJob строит отчет задания и сохраняет результат. При остановке сервиса и временных ошибках
+// задание возвращается в очередь, после исчерпания попыток или при недоступности Google Drive завершается с ошибкой.
+func (s *ReportService) processReportJob(ctx context.Context, job entity.ReportJob) error {
+	link, buildErr := s.buildReportJob(ctx, job)
+	if buildErr == nil {
+		err := s.reportJobRepo.CompleteReportJob(ctx, job.Id, job.Attempts, link)
+		if err != nil {
+			return fmt.Errorf("reportJobRepo.CompleteReportJob: %w", err)
+		}
+
+		return nil
+	}
+
+	if errors.Is(buildErr, apperror.ErrReportJobLost) {
+		// Задание выполняет другой воркер, результат этой попытки отброшен
+		return fmt.Errorf("report job %d: %w", job.Id, buildErr)
+	}
+
+	if ctx.Err() != nil || job.Attempts < reportJobMaxAttempts && !errors.Is(buildErr, apperror.ErrGDriveNotAvailable) {
+		err := releaseJob(ctx, func(ctx context.Context) error {
+			return s.reportJobRepo.ReleaseReportJob(ctx, job.Id, job.Attempts)
+		})
+		if err != nil {
+			return fmt.Errorf("reportJobRepo.ReleaseReportJob: %w", err)
+		}
+
+		return fmt.Errorf("report job %d: %w", job.Id, buildErr)
+	}
+
+	err := s.reportJobRepo.FailReportJob(ctx, job.Id, job.Attempts, buildErr.Error())
+	if err != nil {
+		return fmt.Errorf("reportJobRepo.FailReportJob: %w", err)
+	}
+
+	return fmt.Errorf("report job %d: %w", job.Id, buildErr)
+}
+
+// releaseJob возвращает задание в очередь. Контекст воркера может быть уже отменён,
+// задание возвращается в очередь в любом случае
+func releaseJob(ctx context.Context, release func(ctx context.Context) error) error {
+	return release(context.WithoutCancel(ctx))
+}
+
+// buildReportJob строит отчет задания с сохранением прогресса, для заданий типа file сохраняет файл отчета
+// в бд частями по мере записи, для заданий типа link возвращает ссылку на Google Drive
+func (s *ReportService) buildReportJob(ctx context.Context, job entity.ReportJob) (string, error) {
+	if job.Type == entity.ReportJobTypeLink && !s.gDrive.IsAvailable() {
+		return "", apperror.ErrGDriveNotAvailable
+	}
+
+	req, err := normalizeReportRequest(entity.ReportRequest{Month: job.Month, Year: job.Year})
+	if err != nil {
+		return "", err
+	}
+
+	total, err := s.reportRepo.CountSegmentHistory(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("reportRepo.CountSegmentHistory: %w", err)
+	}
+
+	err = s.reportJobRepo.UpdateReportJobProgress(ctx, job.Id, job.Attempts, reportProgressCounted, reportJobLease)
+	if err != nil {
+		return "", fmt.Errorf("reportJobRepo.UpdateReportJobProgress: %w", err)
+	}
+
+	progress := func(written int) error {
+		// История могла пополниться после подсчета, прогресс не должен выходить за reportProgressWritten
+		done := min(float32(written)/float32(max(total, 1)), 1)
+		err := s.reportJobRepo.UpdateReportJobProgress(ctx, job.Id, job.Attempts,
+			reportProgressCounted+(reportProgressWritten-reportProgressCounted)*done, reportJobLease)
+		if err != nil {
+			return fmt.Errorf("reportJobRepo.UpdateReportJobProgress: %w", err)
+		}
+
+		return nil
+	}
+
+	if job.Type == entity.ReportJobTypeLink {
+		url, err := s.uploadReport(ctx, req, progress)
+		if err != nil {
+			return "", err
+		}
+
+		return url, nil
+	}
+
+	file := &filePartWriter{size: reportFilePartSize, save: func(part int, data []byte) error {
+		err := s.reportJobRepo.SaveReportJobFilePart(ctx, job.Id, job.Attempts, part, data)
+		if err != nil {
+			return fmt.Errorf("reportJobRepo.SaveReportJobFilePart: %w", err)
+		}
+
+		return nil
+	}}
+	err = s.writeReportCSV(ctx, req, file, progress)
+	if err != nil {
+		return "", err
+	}
+
+	return "", file.Close()
+}
+
+// uploadReport загружает отчет в Google Drive, передавая csv через pipe по мере чтения истории из бд
+func (s *ReportService) uploadReport(ctx context.Context, req entity.ReportRequest, progress func(written int) error) (string, error) {
+	pr, pw := io.Pipe()
+	writeErr := make(chan error, 1)
+	go func() {
+		err := s.writeReportCSV(ctx, req, pw, progress)
+		_ = pw.CloseWithError(err)
+		writeErr <- err
+	}()
+
+	url, err := s.gDrive.UploadCSVFile(ctx, reportFileName(req), pr)
+	// Закрытие pipe прерывает запись отчета, если загрузка завершилась раньше
+	_ = pr.Close()
+	if wErr := <-writeErr; wErr != nil && !errors.Is(wErr, io.ErrClosedPipe) {
+		return "", wErr
+	}
 	if err != nil {
 		return "", fmt.Errorf("gDrive.UploadCSVFile: %w", err)
 	}
@@ -56,41 +276,191 @@ func (s *ReportService) MakeReportLink(ctx context.Context, req entity.ReportReq
 	return url, nil
 }
 
-func (s *ReportService) MakeReportFile(ctx context.Context, req entity.ReportRequest) ([]byte, error) {
-	report, err := s.GetUserHistory(ctx, req)
+// writeReportCSV записывает отчет в формате csv в w по мере чтения истории из бд,
+// progress (если задан) вызывается после каждых reportProgressStep строк с количеством записанных строк
+func (s *ReportService) writeReportCSV(ctx context.Context, req entity.ReportRequest, w io.Writer, progress func(written int) error) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(reportCSVHeader)
 	if err != nil {
-		return nil, fmt.Errorf("reportService.GetUserHistory: %w", err)
+		return fmt.Errorf("reportService.writeReportCSV - w.Write Header: %w", err)
 	}
 
-	b := bytes.Buffer{}
-	w := csv.NewWriter(&b)
+	written := 0
+	err = s.StreamUserHistory(ctx, req, func(item entity.ReportUserHistory) error {
+		err := cw.Write(reportCSVRecord(item))
+		if err != nil {
+			return fmt.Errorf("reportService.writeReportCSV - w.Write: %w", err)
+		}
+
+		written++
+		if progress != nil && written%reportProgressStep == 0 {
+			return progress(written)
+		}
 
-	err = w.Write([]string{
-		"user_id",
-		"segment",
-		"operation",
-		"date",
+		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("reportService.MakeReportFile - w.Write Header: %w", err)
+		return err
 	}
 
-	for _, item := range report {
-		err = w.Write([]string{
-			item.UserId,
-			item.Segment,
-			item.Operation,
-			item.Date.String(),
-		})
-		if err != nil {
-			return nil, fmt.Errorf("reportService.MakeReportFile - w.Write: %w", err)
+	cw.Flush()
+	if err = cw.Error(); err != nil {
+		return fmt.Errorf("reportService.writeReportCSV - w.Error(): %w", err)
+	}
+
+	return nil
+}
+
+// filePartWriter передаёт записанные данные в save частями не меньше size байт (кроме последней).
+// Часть заканчивается концом строки, поэтому каждая часть файла csv содержит целые строки.
+type filePartWriter struct {
+	size int
+	save func(part int, data []byte) error
+	buf  []byte
+	part int
+}
+
+func (w *filePartWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.size {
+		return len(p), nil
+	}
+
+	end := bytes.LastIndexByte(w.buf, '\n') + 1
+	if end == 0 {
+		return len(p), nil
+	}
+
+	if err := w.flush(end); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close сохраняет оставшиеся данные последней частью
+func (w *filePartWriter) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	return w.flush(len(w.buf))
+}
+
+func (w *filePartWriter) flush(end int) error {
+	err := w.save(w.part, w.buf[:end])
+	if err != nil {
+		return err
+	}
+
+	w.part++
+	w.buf = append(w.buf[:0:0], w.buf[end:]...)
+
+	return nil
+}
+
+// reportCSVHeader заголовок csv отчета, архивы удалённой истории используют тот же формат
+var reportCSVHeader = []string{
+	"user_id",
+	"segment",
+	"variant",
+	"operation",
+	"reason",
+	"date",
+	"segment_at_event",
+}
+
+// reportCSVRecord возвращает строку csv отчета для операции
+func reportCSVRecord(item entity.ReportUserHistory) []string {
+	return []string{
+		item.UserId,
+		item.Segment,
+		item.Variant,
+		item.Operation,
+		item.Reason,
+		item.Date.String(),
+		item.SegmentAtEvent,
+	}
+}
+
+// normalizeReportRequest проверяет запрос отчета и приводит период, заданный месяцем и годом, к границам from и to
+func normalizeReportRequest(req entity.ReportRequest) (entity.ReportRequest, error) {
+	if req.Month != 0 || req.Year != 0 {
+		if req.Month < 1 || req.Month > 12 || req.Year < 1 || !req.From.IsZero() || !req.To.IsZero() {
+			return req, apperror.ErrWrongReportPeriod
 		}
+
+		req.From = time.Date(req.Year, time.Month(req.Month), 1, 0, 0, 0, 0, time.Local)
+		req.To = req.From.AddDate(0, 1, 0)
+		req.Month, req.Year = 0, 0
+	}
+
+	if req.From.IsZero() || !req.From.Before(req.To) {
+		return req, apperror.ErrWrongReportPeriod
+	}
+
+	if req.Operation != "" && req.Operation != entity.ReportOperationAdd && req.Operation != entity.ReportOperationRemove {
+		return req, apperror.ErrWrongReportPeriod
+	}
+
+	return req, nil
+}
+
+// reportFileTimeLayout формат границ периода в имени файла отчета, границы записываются точно,
+// чтобы отчеты за разные периоды внутри одного дня не перезаписывали друг друга
+const reportFileTimeLayout = "20060102T150405.999999999"
+
+// reportFileDateLayout формат границ периода в именах файлов отчетов, загруженных до перехода на точные границы
+const reportFileDateLayout = "20060102"
+
+// reportFileName возвращает имя файла отчета за период, отчеты с фильтрами получают суффикс,
+// чтобы не перезаписывать файл полного отчета за тот же период
+func reportFileName(req entity.ReportRequest) string {
+	name := fmt.Sprintf("report_%s_%s",
+		req.From.In(time.Local).Format(reportFileTimeLayout), req.To.In(time.Local).Format(reportFileTimeLayout))
+	if req.Operation == "" && len(req.Segments) == 0 && len(req.UserIds) == 0 {
+		return name + ".csv"
+	}
+
+	h := fnv.New32a()
+	_, _ = fmt.Fprint(h, req.Operation, req.Segments, req.UserIds)
+
+	return fmt.Sprintf("%s_%08x.csv", name, h.Sum32())
+}
+
+// parseReportFileName возвращает период отчета по имени файла, составленному reportFileName,
+// для остальных файлов возвращает false. Файлы прежнего формата содержат только даты границ,
+// их период расширяется до конца последнего дня.
+func parseReportFileName(name string) (time.Time, time.Time, bool) {
+	name, ok := strings.CutSuffix(name, ".csv")
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+
+	parts := strings.Split(name, "_")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] != "report" {
+		return time.Time{}, time.Time{}, false
+	}
+
+	layout := reportFileTimeLayout
+	if len(parts[1]) == len(reportFileDateLayout) && len(parts[2]) == len(reportFileDateLayout) {
+		layout = reportFileDateLayout
+	}
+
+	from, err := time.ParseInLocation(layout, parts[1], time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	to, err := time.ParseInLocation(layout, parts[2], time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
 	}
 
-	w.Flush()
-	if err = w.Error(); err != nil {
-		return nil, fmt.Errorf("reportService.MakeReportFile - w.Error(): %w", err)
+	if layout == reportFileDateLayout {
+		to = to.AddDate(0, 0, 1)
 	}
 
-	return b.Bytes(), nil
+	return from, to, true
 }
@@ -1,50 +1,342 @@
 package service
 
 import (
+	"avito-internship/internal/apperror"
 	"avito-internship/internal/entity"
 	"avito-internship/internal/repository"
+	"avito-internship/internal/rule"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultMembersLimit размер страницы пользователей сегмента по умолчанию
+	defaultMembersLimit = 100
+	// maxMembersLimit максимальный размер страницы пользователей сегмента
+	maxMembersLimit = 1000
+	// defaultSegmentsLimit размер страницы каталога сегментов по умолчанию
+	defaultSegmentsLimit = 100
+	// maxSegmentsLimit максимальный размер страницы каталога сегментов
+	maxSegmentsLimit = 500
+	// defaultAliasPeriod срок, в течение которого прежнее название переименованного сегмента остаётся псевдонимом
+	defaultAliasPeriod = 90 * 24 * time.Hour
 )
 
 type SegmentService struct {
 	segmentRepo repository.SegmentRepo
+	layerRepo   repository.LayerRepo
 }
 
-func NewSegmentService(segmentRepo repository.SegmentRepo) *SegmentService {
-	return &SegmentService{segmentRepo: segmentRepo}
+func NewSegmentService(segmentRepo repository.SegmentRepo, layerRepo repository.LayerRepo) *SegmentService {
+	return &SegmentService{
+		segmentRepo: segmentRepo,
+		layerRepo:   layerRepo,
+	}
 }
 
 func (s *SegmentService) CreateSegment(ctx context.Context, req entity.SegmentRequest) error {
-	segmentId, err := s.segmentRepo.CreateSegment(ctx, req.Segment)
+	if req.Percent < 0.0 || req.Percent > 1.0 {
+		return apperror.ErrWrongPercent
+	}
+
+	if req.Rule != "" {
+		if _, err := rule.Parse(req.Rule); err != nil {
+			return fmt.Errorf("%w: %v", apperror.ErrWrongRule, err)
+		}
+
+		// Сегменты по правилам вычисляются без учета членства в слоях
+		if req.Layer != "" {
+			return apperror.ErrRuleInLayer
+		}
+	}
+
+	err := validateVariants(req.Variants)
 	if err != nil {
-		return fmt.Errorf("segmentRepo.CreateSegment: %w", err)
+		return err
+	}
+
+	payload, err := validatePayload(req.Payload)
+	if err != nil {
+		return err
+	}
+
+	if req.EndsAt != nil && (!req.EndsAt.After(time.Now()) || req.StartsAt != nil && !req.EndsAt.After(*req.StartsAt)) {
+		return apperror.ErrWrongWindow
 	}
 
-	if segmentId != 0 && req.Percent != 0.0 && (req.Percent < 1.0 || req.Percent > 0.0) {
-		err = s.segmentRepo.RandomUserToSegment(ctx, segmentId, req.Percent)
+	err = validateMetadata(req.TicketUrl, req.Tags)
+	if err != nil {
+		return err
+	}
+
+	segment := entity.Segment{
+		Name:        req.Segment,
+		Percent:     req.Percent,
+		Rule:        req.Rule,
+		Variants:    req.Variants,
+		Payload:     payload,
+		StartsAt:    req.StartsAt,
+		EndsAt:      req.EndsAt,
+		Description: req.Description,
+		OwnerTeam:   req.OwnerTeam,
+		Tags:        req.Tags,
+		TicketUrl:   req.TicketUrl,
+		CreatedBy:   req.Actor,
+	}
+	if req.Layer != "" {
+		segment.LayerId, err = s.layerRepo.GetLayerIdByName(ctx, req.Layer)
 		if err != nil {
-			return fmt.Errorf("segmentRepo.RandomUserToSegment: %w", err)
+			return fmt.Errorf("layerRepo.GetLayerIdByName: %w", err)
 		}
 	}
 
+	// Сегмент создаётся вместе с вариантами и добавлением пользователей по проценту в одной транзакции
+	_, err = s.segmentRepo.CreateSegment(ctx, segment)
+	if err != nil {
+		return fmt.Errorf("segmentRepo.CreateSegment: %w", err)
+	}
+
 	return nil
 }
 
-func (s *SegmentService) DeleteSegment(ctx context.Context, req entity.SegmentRequest) error {
-	exist, err := s.segmentRepo.CheckExistSegment(ctx, req.Segment)
+func (s *SegmentService) UpdateSegment(ctx context.Context, req entity.SegmentUpdateRequest) error {
+	if req.Percent != nil && (*req.Percent < 0.0 || *req.Percent > 1.0) {
+		return apperror.ErrWrongPercent
+	}
+
+	if req.Rule != nil && *req.Rule != "" {
+		if _, err := rule.Parse(*req.Rule); err != nil {
+			return fmt.Errorf("%w: %v", apperror.ErrWrongRule, err)
+		}
+	}
+
+	_, err := validatePayload(req.Payload)
 	if err != nil {
-		return fmt.Errorf("segmentRepo.CheckExistSegment: %w", err)
+		return err
 	}
 
-	if !exist {
-		return nil
+	ticketUrl := ""
+	if req.TicketUrl != nil {
+		ticketUrl = *req.TicketUrl
+	}
+	err = validateMetadata(ticketUrl, req.Tags)
+	if err != nil {
+		return err
 	}
 
-	err = s.segmentRepo.DeleteSegment(ctx, req.Segment)
+	// Описание, правило, данные и процент изменяются в одной транзакции
+	err = s.segmentRepo.UpdateSegment(ctx, req)
+	if err != nil {
+		return fmt.Errorf("segmentRepo.UpdateSegment: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SegmentService) DeleteSegment(ctx context.Context, req entity.SegmentRequest) error {
+	err := s.segmentRepo.DeleteSegment(ctx, req.Segment)
 	if err != nil {
 		return fmt.Errorf("segmentRepo.DeleteSegment: %w", err)
 	}
 
 	return nil
 }
+
+func (s *SegmentService) RestoreSegment(ctx context.Context, req entity.SegmentRestoreRequest) (entity.SegmentRestoreResponse, error) {
+	reenrolled, err := s.segmentRepo.RestoreSegment(ctx, req.Segment, req.Reenroll, req.Actor)
+	if err != nil {
+		return entity.SegmentRestoreResponse{}, fmt.Errorf("segmentRepo.RestoreSegment: %w", err)
+	}
+
+	return entity.SegmentRestoreResponse{Segment: req.Segment, Reenrolled: reenrolled}, nil
+}
+
+func (s *SegmentService) RenameSegment(ctx context.Context, req entity.SegmentRenameRequest) (entity.SegmentAlias, error) {
+	aliasUntil := time.Now().Add(defaultAliasPeriod)
+	if req.AliasUntil != nil {
+		aliasUntil = *req.AliasUntil
+	}
+	if req.NewName == req.Segment || !aliasUntil.After(time.Now()) {
+		return entity.SegmentAlias{}, apperror.ErrWrongRename
+	}
+
+	err := s.segmentRepo.RenameSegment(ctx, req.Segment, req.NewName, aliasUntil, req.Actor)
+	if err != nil {
+		return entity.SegmentAlias{}, fmt.Errorf("segmentRepo.RenameSegment: %w", err)
+	}
+
+	return entity.SegmentAlias{Alias: req.Segment, Segment: req.NewName, AliasUntil: aliasUntil}, nil
+}
+
+func (s *SegmentService) ExpireSegments(ctx context.Context) (int64, error) {
+	expired, err := s.segmentRepo.ExpireSegments(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("segmentRepo.ExpireSegments: %w", err)
+	}
+
+	return expired, nil
+}
+
+func (s *SegmentService) GetSegments(ctx context.Context, req entity.SegmentListRequest) (entity.SegmentListResponse, error) {
+	if req.Limit == 0 {
+		req.Limit = defaultSegmentsLimit
+	}
+	if req.Limit < 0 || req.Limit > maxSegmentsLimit || req.Status != "" &&
+		req.Status != entity.SegmentStatusActive && req.Status != entity.SegmentStatusScheduled &&
+		req.Status != entity.SegmentStatusEnded && req.Status != entity.SegmentStatusDeleted {
+		return entity.SegmentListResponse{}, apperror.ErrWrongSegmentsQuery
+	}
+
+	segments, err := s.segmentRepo.GetSegments(ctx, req, req.Limit+1)
+	if err != nil {
+		return entity.SegmentListResponse{}, fmt.Errorf("segmentRepo.GetSegments: %w", err)
+	}
+
+	response := entity.SegmentListResponse{}
+	var more bool
+	response.Segments, more = page(segments, req.Limit)
+	if more {
+		response.NextCursor = response.Segments[req.Limit-1].Name
+	}
+	if response.Segments == nil {
+		response.Segments = []entity.SegmentInfo{}
+	}
+
+	return response, nil
+}
+
+func (s *SegmentService) GetMembers(ctx context.Context, req entity.SegmentMembersRequest) (entity.SegmentMembersResponse, error) {
+	if req.Limit == 0 {
+		req.Limit = defaultMembersLimit
+	}
+	if req.Limit < 0 || req.Limit > maxMembersLimit || req.Membership != "" &&
+		req.Membership != entity.SegmentMembershipTtl && req.Membership != entity.SegmentMembershipPermanent {
+		return entity.SegmentMembersResponse{}, apperror.ErrWrongMembersQuery
+	}
+
+	afterUserId := 0
+	if req.Cursor != "" {
+		var err error
+		afterUserId, err = strconv.Atoi(req.Cursor)
+		if err != nil || afterUserId < 0 {
+			return entity.SegmentMembersResponse{}, apperror.ErrWrongMembersQuery
+		}
+	}
+
+	asOf, err := asOfTime(req.AsOf)
+	if err != nil {
+		return entity.SegmentMembersResponse{}, err
+	}
+
+	segmentId, err := s.segmentRepo.GetSegmentId(ctx, req.Segment)
+	if err != nil {
+		return entity.SegmentMembersResponse{}, fmt.Errorf("segmentRepo.GetSegmentId: %w", err)
+	}
+
+	total, err := s.segmentRepo.CountSegmentMembersAt(ctx, segmentId, asOf, req.Membership)
+	if err != nil {
+		return entity.SegmentMembersResponse{}, fmt.Errorf("segmentRepo.CountSegmentMembersAt: %w", err)
+	}
+
+	members, err := s.segmentRepo.GetSegmentMembersAt(ctx, segmentId, asOf, req.Membership, afterUserId, req.Limit+1)
+	if err != nil {
+		return entity.SegmentMembersResponse{}, fmt.Errorf("segmentRepo.GetSegmentMembersAt: %w", err)
+	}
+
+	response := entity.SegmentMembersResponse{Segment: req.Segment, AsOf: asOf, Total: total}
+	var more bool
+	response.Users, more = page(members, req.Limit)
+	if more {
+		response.NextCursor = strconv.Itoa(response.Users[req.Limit-1].UserId)
+	}
+	if response.Users == nil {
+		response.Users = []entity.SegmentMember{}
+	}
+
+	return response, nil
+}
+
+// page обрезает выборку до limit записей. Страница запрашивается из репозитория с limit+1 записями:
+// лишняя запись показывает, что за страницей есть продолжение
+func page[T any](items []T, limit int) ([]T, bool) {
+	if len(items) > limit {
+		return items[:limit], true
+	}
+
+	return items, false
+}
+
+// asOfTime возвращает момент запроса на определённое время, по умолчанию - текущее время
+func asOfTime(asOf *time.Time) (time.Time, error) {
+	now := time.Now()
+	if asOf == nil {
+		return now, nil
+	}
+	if asOf.After(now) {
+		return time.Time{}, apperror.ErrWrongAsOf
+	}
+
+	return *asOf, nil
+}
+
+// validateVariants проверяет, что у вариантов заданы уникальные названия и положительные веса
+func validateVariants(variants []entity.Variant) error {
+	names := make(map[string]struct{}, len(variants))
+	for _, variant := range variants {
+		if variant.Name == "" || variant.Weight <= 0 {
+			return apperror.ErrWrongVariants
+		}
+
+		if _, ok := names[variant.Name]; ok {
+			return apperror.ErrWrongVariants
+		}
+		names[variant.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// validateMetadata проверяет, что ссылка на задачу (если задана) является абсолютной http(s) ссылкой,
+// а теги непустые и не повторяются
+func validateMetadata(ticketUrl string, tags []string) error {
+	if ticketUrl != "" {
+		u, err := url.Parse(ticketUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return apperror.ErrWrongMetadata
+		}
+	}
+
+	names := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return apperror.ErrWrongMetadata
+		}
+
+		if _, ok := names[tag]; ok {
+			return apperror.ErrWrongMetadata
+		}
+		names[tag] = struct{}{}
+	}
+
+	return nil
+}
+
+// validatePayload проверяет, что данные сегмента являются JSON объектом,
+// для отсутствующих данных или null возвращает nil
+func validatePayload(payload json.RawMessage) (json.RawMessage, error) {
+	if len(payload) == 0 || string(payload) == "null" {
+		return nil, nil
+	}
+
+	var object map[string]any
+	if err := json.Unmarshal(payload, &object); err != nil {
+		return nil, fmt.Errorf("%w: %v", apperror.ErrWrongPayload, err)
+	}
+
+	return payload, nil
+}
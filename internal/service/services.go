@@ -5,74 +5,281 @@ import (
 	"avito-internship/internal/repository"
 	"avito-internship/internal/webapi"
 	"context"
+	"io"
 )
 
 // Segment методы сервиса сегментов
 type Segment interface {
 	// CreateSegment метод, создающий сегмент,
-	// на вход принимает название сегмента и [опционально] необходимый процент пользователей,
-	// возвращает ошибку или nil
+	// на вход принимает название сегмента, [опционально] необходимый процент пользователей,
+	// [опционально] правило таргетинга по атрибутам пользователей,
+	// [опционально] варианты эксперимента с весами, [опционально] слой взаимоисключающих сегментов,
+	// [опционально] данные (JSON объект), передаваемые клиентам вместе с сегментом,
+	// [опционально] время начала и окончания действия сегмента
+	// и [опционально] описание, команду-владельца, теги и ссылку на задачу для каталога,
+	// возвращает ошибку или nil.
+	// Название удалённого сегмента занято, пока сегмент не восстановлен (apperror.ErrSegmentDeleted).
+	// До начала и после окончания окна сегмент не выдаётся пользователям.
 	CreateSegment(ctx context.Context, req entity.SegmentRequest) error
 
+	// UpdateSegment метод, изменяющий процент пользователей, правило таргетинга, данные и/или описание сегмента,
+	// на вход принимает название сегмента, новый процент пользователей, новое правило, новые данные сегмента
+	// и описание для каталога (не указанные поля не меняются) с инициатором изменения,
+	// возвращает ошибку или nil.
+	// При увеличении процента пользователи, уже попавшие в сегмент, остаются в нём.
+	UpdateSegment(ctx context.Context, req entity.SegmentUpdateRequest) error
+
 	// DeleteSegment метод, удаляющий сегмент,
 	// на вход принимает название сегмента,
 	// возвращает ошибку или nil
 	DeleteSegment(ctx context.Context, req entity.SegmentRequest) error
+
+	// RestoreSegment метод, восстанавливающий удалённый сегмент,
+	// на вход принимает название сегмента, признак возврата пользователей, исключённых при удалении,
+	// и инициатора восстановления,
+	// возвращает количество возвращённых пользователей и ошибку или nil.
+	// Пользователи, уже состоящие в другом сегменте того же слоя, не возвращаются.
+	RestoreSegment(ctx context.Context, req entity.SegmentRestoreRequest) (entity.SegmentRestoreResponse, error)
+
+	// RenameSegment метод, переименовывающий сегмент с сохранением членства и истории,
+	// на вход принимает текущее и новое название, [опционально] момент, до которого прежнее название
+	// остаётся псевдонимом (по умолчанию через 90 дней), и инициатора изменения,
+	// возвращает псевдоним и ошибку или nil.
+	RenameSegment(ctx context.Context, req entity.SegmentRenameRequest) (entity.SegmentAlias, error)
+
+	// ExpireSegments метод, исключающий пользователей из сегментов, окно действия которых закончилось,
+	// в историю записывается время окончания сегмента,
+	// возвращает количество исключённых пользователей и ошибку или nil
+	ExpireSegments(ctx context.Context) (int64, error)
+
+	// GetSegments метод, возвращающий страницу каталога сегментов,
+	// на вход принимает [опционально] префикс названия, теги, статус (active, scheduled, ended, deleted),
+	// курсор и размер страницы,
+	// возвращает сегменты с описанием, статусом и количеством пользователей, курсор следующей страницы и ошибку или nil.
+	GetSegments(ctx context.Context, req entity.SegmentListRequest) (entity.SegmentListResponse, error)
+
+	// GetMembers метод, возвращающий страницу пользователей, состоявших в сегменте в указанный момент,
+	// на вход принимает название сегмента, [опционально] момент времени (по умолчанию текущий),
+	// тип членства (ttl или permanent), курсор и размер страницы,
+	// возвращает пользователей с вариантами и периодами членства, их общее количество,
+	// курсор следующей страницы и ошибку или nil.
+	GetMembers(ctx context.Context, req entity.SegmentMembersRequest) (entity.SegmentMembersResponse, error)
 }
 
 // User методы сервиса пользователей
 type User interface {
 	// AddSegment метод, добавляющий пользователя в сегменты,
-	// на вход принимает id пользователя, массив из названий сегментов и время нахождения пользователя в указанных сегментах,
-	// возвращает ошибку или nil.
-	// При отсутствии ttl, конечное время не указывается.
-	// ttl задаётся в часах.
-	AddSegment(ctx context.Context, req entity.UserAddToSegmentRequest) error
+	// на вход принимает id пользователя, массив из названий сегментов и время нахождения пользователя в указанных сегментах:
+	// ttl в часах или абсолютные время начала и окончания,
+	// возвращает использованные устаревшие названия (псевдонимы переименованных сегментов) и ошибку или nil.
+	// При отсутствии ttl и времени окончания, конечное время не указывается,
+	// при отсутствии времени начала пользователь добавляется сразу.
+	// Если пользователь уже состоит в другом сегменте того же слоя, возвращается ошибка конфликта.
+	AddSegment(ctx context.Context, req entity.UserAddToSegmentRequest) ([]entity.SegmentAlias, error)
+
+	// ImportSegments метод, массово добавляющий пользователей в сегменты из файла CSV или JSON Lines
+	// со строками user_id,segment,ttl,
+	// на вход принимает файл, его формат и признак пробного запуска,
+	// возвращает количество принятых строк, отклонённые строки с причинами и ошибку или nil.
+	// Все строки применяются в одной транзакции, при пробном запуске изменения не сохраняются.
+	ImportSegments(ctx context.Context, req entity.UserImportRequest) (entity.UserImportResponse, error)
 
 	// RemoveSegment метод, исключающий пользователя из сегментов,
 	// на вход принимает id пользователя и массив из названий сегментов,
-	// возвращает ошибку или nil.
-	RemoveSegment(ctx context.Context, req entity.UserRemoveFromSegmentRequest) error
+	// возвращает использованные устаревшие названия сегментов и ошибку или nil.
+	RemoveSegment(ctx context.Context, req entity.UserRemoveFromSegmentRequest) ([]entity.SegmentAlias, error)
 
 	// GetActiveSegments метод, возвращающий массив сегментов в которых состоит пользователь,
 	// на вход принимает id пользователя и массив из названий сегментов,
 	// помимо массива активных сегментов пользователя возвращает ошибку или nil.
-	GetActiveSegments(ctx context.Context, req entity.UserActiveSegmentRequest) ([]string, error)
+	// Явно добавленные сегменты объединяются с сегментами, правилам которых удовлетворяют атрибуты пользователя.
+	// Для сегментов с вариантами эксперимента возвращается также вариант пользователя.
+	// При заданном as_of возвращаются сегменты на указанный момент только по истории членства.
+	GetActiveSegments(ctx context.Context, req entity.UserActiveSegmentRequest) ([]entity.UserSegment, error)
+
+	// GetActiveSegmentsBatch метод, возвращающий активные сегменты нескольких пользователей,
+	// на вход принимает массив из id пользователей (не более 500),
+	// возвращает map id пользователя -> сегменты и ошибку или nil.
+	// Сегменты определяются так же, как в GetActiveSegments, неизвестным пользователям соответствует пустой массив.
+	GetActiveSegmentsBatch(ctx context.Context, req entity.UserBatchActiveSegmentRequest) (map[int][]entity.UserSegment, error)
+
+	// GetConfig метод, возвращающий активные сегменты пользователя вместе с объединёнными данными сегментов,
+	// на вход принимает id пользователя,
+	// возвращает сегменты и данные, объединённые в порядке создания сегментов
+	// (при совпадении ключей побеждает более новый сегмент), и ошибку или nil.
+	GetConfig(ctx context.Context, req entity.UserActiveSegmentRequest) (entity.UserConfigResponse, error)
+
+	// SetAttributes метод, сохраняющий атрибуты пользователя для таргетинга по правилам,
+	// на вход принимает id пользователя и атрибуты,
+	// возвращает ошибку или nil.
+	SetAttributes(ctx context.Context, req entity.UserAttributesRequest) error
+
+	// GetHistory метод, возвращающий страницу истории членства пользователя в сегментах,
+	// на вход принимает id пользователя, [опционально] сегменты, период, курсор и размер страницы,
+	// возвращает записи истории с курсором следующей страницы и ошибку или nil.
+	GetHistory(ctx context.Context, req entity.UserHistoryRequest) (entity.UserHistoryResponse, error)
+
+	// FinalizeExpiredMemberships метод, фиксирующий истёкшее по ttl членство пользователей в сегментах
+	// и записывающий события исключения (причина expired) в журнал,
+	// возвращает количество исключённых пользователей и ошибку или nil.
+	FinalizeExpiredMemberships(ctx context.Context) (int64, error)
+
+	// EraseUser метод, удаляющий пользователя по запросу (право на забвение),
+	// на вход принимает id пользователя и инициатора удаления,
+	// возвращает квитанцию с количеством обезличенных записей, отчетами в Google Drive, которые содержат исходный id
+	// пользователя и должны быть перестроены, заданиями на отчеты, возвращёнными в очередь для перестроения,
+	// и ошибку или nil.
+	// История членства сохраняется под псевдонимом (отрицательный id), поэтому отчеты, построенные после удаления,
+	// исходный id не содержат.
+	EraseUser(ctx context.Context, req entity.UserEraseRequest) (entity.UserErasureReceipt, error)
 }
 
 // Report методы сервиса отчетов
 type Report interface {
 	// GetUserHistory метод, составляющий историю операций за конкретный период времени,
-	// на вход принимает месяц и год (int),
+	// на вход принимает месяц и год (int) или период from/to и [опционально] фильтры,
 	// возвращает массив из полей отчета и их значений, также возвращает ошибку или nil.
 	GetUserHistory(ctx context.Context, req entity.ReportRequest) ([]entity.ReportUserHistory, error)
 
 	// MakeReportLink метод, создающий ссылку с отчетом в формате csv на Google Drive,
-	// на вход принимает месяц и год (int),
+	// на вход принимает месяц и год (int) или период from/to и [опционально] фильтры,
 	// возвращает ссылку на отчет в Google Drive и ошибку или nil.
 	MakeReportLink(ctx context.Context, req entity.ReportRequest) (string, error)
 
-	// MakeReportFile метод, создающий отчет в формате массива байтов,
-	// на вход принимает месяц и год (int),
-	// возвращает массив байтов и ошибку или nil.
-	MakeReportFile(ctx context.Context, req entity.ReportRequest) ([]byte, error)
+	// StreamUserHistory метод, передающий историю операций за период по одной записи без накопления в памяти,
+	// на вход принимает период и фильтры отчета и функцию, вызываемую для каждой записи,
+	// возвращает ошибку или nil.
+	StreamUserHistory(ctx context.Context, req entity.ReportRequest, fn func(entity.ReportUserHistory) error) error
+
+	// WriteReportFile метод, записывающий отчет в формате csv в w по мере чтения из бд,
+	// на вход принимает период и фильтры отчета и получателя отчета,
+	// возвращает ошибку или nil.
+	WriteReportFile(ctx context.Context, req entity.ReportRequest, w io.Writer) error
+
+	// CreateReportJob метод, создающий задание на построение отчета в фоне,
+	// на вход принимает тип отчета (file — файл csv, link — ссылка на Google Drive), месяц и год,
+	// возвращает id задания и ошибку или nil.
+	CreateReportJob(ctx context.Context, req entity.ReportJobRequest) (int64, error)
+
+	// GetReportJob метод, возвращающий статус и прогресс задания,
+	// на вход принимает id задания,
+	// возвращает задание (для выполненных заданий типа link — со ссылкой на отчет) и ошибку или nil.
+	GetReportJob(ctx context.Context, id int64) (entity.ReportJob, error)
+
+	// WriteReportJobFile метод, записывающий в w файл отчета выполненного задания типа file по мере чтения из бд,
+	// на вход принимает id задания и получателя файла,
+	// возвращает ошибку или nil.
+	WriteReportJobFile(ctx context.Context, id int64, w io.Writer) error
+
+	// ProcessReportJobs метод, выполняющий задания из очереди, пока они есть,
+	// возвращает количество выполненных заданий и ошибку или nil.
+	// Задания, прерванные остановкой сервиса, возвращаются в очередь.
+	ProcessReportJobs(ctx context.Context) (int, error)
+}
+
+// Purge методы сервиса окончательного удаления истории
+type Purge interface {
+	// CreatePurgeJob метод, создающий задание на окончательное удаление сегмента со всей историей членства,
+	// вариантами и псевдонимами, на вход принимает название удалённого сегмента и признак сохранения архива
+	// истории (csv отчет) перед удалением,
+	// возвращает id задания и ошибку или nil.
+	CreatePurgeJob(ctx context.Context, req entity.PurgeJobRequest) (int64, error)
+
+	// GetPurgeJob метод, возвращающий статус задания и количество удалённых записей,
+	// на вход принимает id задания,
+	// возвращает задание и ошибку или nil.
+	GetPurgeJob(ctx context.Context, id int64) (entity.PurgeJob, error)
+
+	// WritePurgeJobFile метод, записывающий в w архив истории выполненного задания по мере чтения из бд,
+	// на вход принимает id задания и получателя архива,
+	// возвращает ошибку или nil.
+	WritePurgeJobFile(ctx context.Context, id int64, w io.Writer) error
+
+	// EnforceRetention метод, создающий задание на удаление завершённого членства и удалённых сегментов
+	// старше срока хранения истории (если срок задан и такое задание ещё не выполняется),
+	// возвращает id задания (0, если задание не создано) и ошибку или nil.
+	EnforceRetention(ctx context.Context) (int64, error)
+
+	// ProcessPurgeJobs метод, выполняющий задания из очереди, пока они есть,
+	// возвращает количество выполненных заданий и ошибку или nil.
+	// История удаляется небольшими пачками, прерванные задания продолжаются с места остановки.
+	ProcessPurgeJobs(ctx context.Context) (int, error)
+}
+
+// Layer методы сервиса слоёв взаимоисключающих сегментов
+type Layer interface {
+	// CreateLayer метод, создающий слой,
+	// на вход принимает название слоя,
+	// возвращает ошибку или nil
+	CreateLayer(ctx context.Context, req entity.LayerRequest) error
+}
+
+// Webhook методы сервиса вебхуков
+type Webhook interface {
+	// CreateWebhook метод, создающий подписку на события,
+	// на вход принимает url, типы событий и секрет для подписи HMAC-SHA256,
+	// возвращает id подписки и ошибку или nil
+	CreateWebhook(ctx context.Context, req entity.WebhookRequest) (int, error)
+
+	// DeleteWebhook метод, удаляющий подписку,
+	// на вход принимает id подписки,
+	// возвращает ошибку или nil
+	DeleteWebhook(ctx context.Context, req entity.WebhookDeleteRequest) error
+
+	// GetWebhooks метод, возвращающий активные подписки и ошибку или nil
+	GetWebhooks(ctx context.Context) ([]entity.Webhook, error)
+
+	// DispatchEvents метод, распределяющий новые события журнала по подпискам и присваивающий им номера
+	// в потоке событий, возвращает количество распределённых событий и ошибку или nil
+	DispatchEvents(ctx context.Context) (int, error)
+
+	// DeliverEvents метод, отправляющий доставки, время попытки которых наступило. Неудачные доставки повторяются с экспоненциальной задержкой,
+	// после исчерпания попыток переносятся в недоставленные.
+	// Возвращает количество успешных доставок и ошибку или nil.
+	DeliverEvents(ctx context.Context) (int, error)
+}
+
+// Event методы сервиса журнала событий
+type Event interface {
+	// StreamEvents метод, передающий в fn все события журнала после указанного номера в потоке,
+	// на вход принимает фильтры по id пользователя и названию сегмента и номер последнего полученного события,
+	// события читаются пачками в порядке номеров, пока журнал не будет прочитан до конца,
+	// возвращает ошибку fn, ошибку бд или nil
+	StreamEvents(ctx context.Context, req entity.EventStreamRequest, afterSeq int64, fn func(entity.Event) error) error
+
+	// GetLastEventSeq метод, возвращающий номер последнего события потока и ошибку или nil
+	GetLastEventSeq(ctx context.Context) (int64, error)
 }
 
 type Services struct {
 	Segment Segment
 	User    User
 	Report  Report
+	Purge   Purge
+	Layer   Layer
+	Webhook Webhook
+	Event   Event
 }
 
 type ServicesDependencies struct {
 	Repos  *repository.Repositories
 	GDrive webapi.GDrive
+	Sender webapi.WebhookSender
+
+	// RetentionMonths срок хранения завершённой истории в месяцах (0 - история хранится бессрочно),
+	// RetentionArchive - сохранять архив истории перед удалением по сроку хранения
+	RetentionMonths  int
+	RetentionArchive bool
 }
 
 func NewServices(deps ServicesDependencies) *Services {
 	return &Services{
-		Segment: NewSegmentService(deps.Repos.SegmentRepo),
-		User:    NewUserService(deps.Repos.UserRepo),
-		Report:  NewReportService(deps.Repos.ReportRepo, deps.GDrive),
+		Segment: NewSegmentService(deps.Repos.SegmentRepo, deps.Repos.LayerRepo),
+		User:    NewUserService(deps.Repos.UserRepo, deps.Repos.SegmentRepo, deps.GDrive),
+		Report:  NewReportService(deps.Repos.ReportRepo, deps.Repos.ReportJobRepo, deps.GDrive),
+		Purge:   NewPurgeService(deps.Repos.PurgeRepo, deps.RetentionMonths, deps.RetentionArchive),
+		Layer:   NewLayerService(deps.Repos.LayerRepo),
+		Webhook: NewWebhookService(deps.Repos.WebhookRepo, deps.Sender),
+		Event:   NewEventService(deps.Repos.EventRepo),
 	}
 }
@@ -4,65 +4,349 @@ import (
 	"avito-internship/internal/apperror"
 	"avito-internship/internal/entity"
 	"avito-internship/internal/repository"
+	"avito-internship/internal/rule"
+	"avito-internship/internal/webapi"
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"sort"
+	"time"
 )
 
+// maxBatchUsers максимальное количество пользователей в одном пакетном запросе сегментов
+const maxBatchUsers = 500
+
 type UserService struct {
-	userRepo repository.UserRepo
+	userRepo    repository.UserRepo
+	segmentRepo repository.SegmentRepo
+	gDrive      webapi.GDrive
 }
 
-func NewUserService(userRepo repository.UserRepo) *UserService {
-	return &UserService{userRepo: userRepo}
+func NewUserService(userRepo repository.UserRepo, segmentRepo repository.SegmentRepo, gDrive webapi.GDrive) *UserService {
+	return &UserService{
+		userRepo:    userRepo,
+		segmentRepo: segmentRepo,
+		gDrive:      gDrive,
+	}
 }
 
-func (s *UserService) AddSegment(ctx context.Context, req entity.UserAddToSegmentRequest) error {
-	segmentsId, err := s.userRepo.GetActiveSegmentsIdByName(ctx, req.Segments)
+func (s *UserService) AddSegment(ctx context.Context, req entity.UserAddToSegmentRequest) ([]entity.SegmentAlias, error) {
+	// Начало в прошлом изменило бы историю за периоды, по которым отчеты уже построены
+	now := time.Now()
+	if req.StartAt != nil && req.StartAt.Before(now) {
+		return nil, apperror.ErrWrongSchedule
+	}
+
+	if req.EndAt != nil && (req.Ttl > 0 || !req.EndAt.After(now) ||
+		req.StartAt != nil && !req.EndAt.After(*req.StartAt)) {
+		return nil, apperror.ErrWrongSchedule
+	}
+
+	segmentsId, aliases, missing, err := s.userRepo.GetActiveSegmentsIdByName(ctx, req.Segments)
 	if err != nil {
-		return fmt.Errorf("userRepo.GetActiveSegmentsIdByName: %w", err)
+		return nil, fmt.Errorf("userRepo.GetActiveSegmentsIdByName: %w", err)
 	}
 
-	if len(segmentsId) != len(req.Segments) {
-		return apperror.ErrNoSegment
+	if len(missing) > 0 {
+		return nil, apperror.ErrNoSegment
 	}
 
-	err = s.userRepo.AddSegmentToUser(ctx, req.UserId, segmentsId, req.Ttl)
+	// ttl отсчитывается от начала членства
+	endAt := req.EndAt
+	if req.Ttl > 0 {
+		ttlStart := now
+		if req.StartAt != nil {
+			ttlStart = *req.StartAt
+		}
+		ttlEnd := ttlStart.Add(time.Duration(req.Ttl) * time.Hour)
+		endAt = &ttlEnd
+	}
+
+	err = s.userRepo.AddSegmentToUser(ctx, req.UserId, segmentsId, req.StartAt, endAt, req.Actor)
 	if err != nil {
-		return fmt.Errorf("userRepo.AddSegmentToUser: %w", err)
+		return nil, fmt.Errorf("userRepo.AddSegmentToUser: %w", err)
 	}
 
-	return nil
+	return aliases, nil
 }
 
-func (s *UserService) RemoveSegment(ctx context.Context, req entity.UserRemoveFromSegmentRequest) error {
+func (s *UserService) RemoveSegment(ctx context.Context, req entity.UserRemoveFromSegmentRequest) ([]entity.SegmentAlias, error) {
 	err := s.userRepo.CheckExistUser(ctx, req.UserId)
 	if err != nil {
-		return fmt.Errorf("userRepo.CheckExistUser: %w", err)
+		return nil, fmt.Errorf("userRepo.CheckExistUser: %w", err)
 	}
 
-	segmentsId, err := s.userRepo.GetActiveSegmentsIdByName(ctx, req.Segments)
+	segmentsId, aliases, _, err := s.userRepo.GetActiveSegmentsIdByName(ctx, req.Segments)
 	if err != nil {
-		return fmt.Errorf("userRepo.GetActiveSegmentsIdByName: %w", err)
+		return nil, fmt.Errorf("userRepo.GetActiveSegmentsIdByName: %w", err)
 	}
 
-	err = s.userRepo.RemoveSegmentFromUser(ctx, req.UserId, segmentsId)
+	err = s.userRepo.RemoveSegmentFromUser(ctx, req.UserId, segmentsId, req.Actor)
 	if err != nil {
-		return fmt.Errorf("userRepo.RemoveSegmentFromUser: %w", err)
+		return nil, fmt.Errorf("userRepo.RemoveSegmentFromUser: %w", err)
 	}
 
-	return nil
+	return aliases, nil
 }
 
-func (s *UserService) GetActiveSegments(ctx context.Context, req entity.UserActiveSegmentRequest) ([]string, error) {
+func (s *UserService) GetActiveSegments(ctx context.Context, req entity.UserActiveSegmentRequest) ([]entity.UserSegment, error) {
 	err := s.userRepo.CheckExistUser(ctx, req.UserId)
 	if err != nil {
 		return nil, fmt.Errorf("userRepo.CheckExistUser: %w", err)
 	}
 
+	// Правила таргетинга вычисляются по текущим атрибутам, поэтому на прошлый момент
+	// возвращается только членство, записанное в истории
+	if req.AsOf != nil {
+		asOf, err := asOfTime(req.AsOf)
+		if err != nil {
+			return nil, err
+		}
+
+		segments, err := s.userRepo.GetSegmentsFromUserAt(ctx, req.UserId, asOf)
+		if err != nil {
+			return nil, fmt.Errorf("userRepo.GetSegmentsFromUserAt: %w", err)
+		}
+
+		return segments, nil
+	}
+
 	segments, err := s.userRepo.GetActiveSegmentFromUser(ctx, req.UserId)
 	if err != nil {
 		return nil, fmt.Errorf("userRepo.GetActiveSegmentFromUser: %w", err)
 	}
 
+	ruleSegments, err := s.matchRuleSegments(ctx, req.UserId)
+	if err != nil {
+		return nil, fmt.Errorf("userService.matchRuleSegments: %w", err)
+	}
+
+	return mergeSegments(segments, ruleSegments), nil
+}
+
+func (s *UserService) GetActiveSegmentsBatch(ctx context.Context, req entity.UserBatchActiveSegmentRequest) (map[int][]entity.UserSegment, error) {
+	if len(req.UserIds) == 0 || len(req.UserIds) > maxBatchUsers {
+		return nil, apperror.ErrWrongBatch
+	}
+
+	segments, err := s.userRepo.GetActiveSegmentsFromUsers(ctx, req.UserIds)
+	if err != nil {
+		return nil, fmt.Errorf("userRepo.GetActiveSegmentsFromUsers: %w", err)
+	}
+
+	ruleSegments, err := s.matchRuleSegmentsBatch(ctx, req.UserIds)
+	if err != nil {
+		return nil, fmt.Errorf("userService.matchRuleSegmentsBatch: %w", err)
+	}
+
+	result := make(map[int][]entity.UserSegment, len(req.UserIds))
+	for _, id := range req.UserIds {
+		result[id] = mergeSegments(segments[id], ruleSegments[id])
+		if result[id] == nil {
+			result[id] = []entity.UserSegment{}
+		}
+	}
+
+	return result, nil
+}
+
+func (s *UserService) GetConfig(ctx context.Context, req entity.UserActiveSegmentRequest) (entity.UserConfigResponse, error) {
+	segments, err := s.GetActiveSegments(ctx, req)
+	if err != nil {
+		return entity.UserConfigResponse{}, fmt.Errorf("userService.GetActiveSegments: %w", err)
+	}
+
+	payload := map[string]any{}
+	for _, segment := range segments {
+		if len(segment.Payload) == 0 {
+			continue
+		}
+
+		var segmentPayload map[string]any
+		err = json.Unmarshal(segment.Payload, &segmentPayload)
+		if err != nil {
+			return entity.UserConfigResponse{}, fmt.Errorf("json.Unmarshal %s: %w", segment.Segment, err)
+		}
+
+		for key, value := range segmentPayload {
+			payload[key] = value
+		}
+	}
+
+	if segments == nil {
+		segments = []entity.UserSegment{}
+	}
+
+	return entity.UserConfigResponse{Segments: segments, Payload: payload}, nil
+}
+
+func (s *UserService) SetAttributes(ctx context.Context, req entity.UserAttributesRequest) error {
+	err := s.userRepo.SetUserAttributes(ctx, req.UserId, req.Attributes)
+	if err != nil {
+		return fmt.Errorf("userRepo.SetUserAttributes: %w", err)
+	}
+
+	return nil
+}
+
+func (s *UserService) FinalizeExpiredMemberships(ctx context.Context) (int64, error) {
+	expired, err := s.userRepo.FinalizeExpiredMemberships(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("userRepo.FinalizeExpiredMemberships: %w", err)
+	}
+
+	return expired, nil
+}
+
+// matchRuleSegments возвращает сегменты, правилам которых удовлетворяют атрибуты пользователя,
+// вместе с вариантами пользователя в этих сегментах
+func (s *UserService) matchRuleSegments(ctx context.Context, id int) ([]entity.UserSegment, error) {
+	ruleSegments, err := s.segmentRepo.GetActiveRuleSegments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("segmentRepo.GetActiveRuleSegments: %w", err)
+	}
+
+	if len(ruleSegments) == 0 {
+		return nil, nil
+	}
+
+	attributes, err := s.userRepo.GetUserAttributes(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("userRepo.GetUserAttributes: %w", err)
+	}
+
+	var matched []entity.Segment
+	for _, segment := range ruleSegments {
+		r, err := rule.Parse(segment.Rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule.Parse %s: %w", segment.Name, err)
+		}
+
+		if r.Match(attributes) {
+			matched = append(matched, segment)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, nil
+	}
+
+	segmentIds := make([]int, 0, len(matched))
+	for _, segment := range matched {
+		segmentIds = append(segmentIds, segment.Id)
+	}
+
+	variants, err := s.segmentRepo.GetUserVariants(ctx, id, segmentIds)
+	if err != nil {
+		return nil, fmt.Errorf("segmentRepo.GetUserVariants: %w", err)
+	}
+
+	segments := make([]entity.UserSegment, 0, len(matched))
+	for _, segment := range matched {
+		segments = append(segments, entity.UserSegment{
+			SegmentId: segment.Id,
+			Segment:   segment.Name,
+			Variant:   variants[segment.Id],
+			Payload:   segment.Payload,
+		})
+	}
+
 	return segments, nil
 }
+
+// matchRuleSegmentsBatch возвращает для каждого пользователя сегменты, правилам которых удовлетворяют его атрибуты,
+// вместе с вариантами пользователей в этих сегментах. Количество запросов к бд не зависит от числа пользователей.
+func (s *UserService) matchRuleSegmentsBatch(ctx context.Context, ids []int) (map[int][]entity.UserSegment, error) {
+	ruleSegments, err := s.segmentRepo.GetActiveRuleSegments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("segmentRepo.GetActiveRuleSegments: %w", err)
+	}
+
+	if len(ruleSegments) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]*rule.Rule, 0, len(ruleSegments))
+	for _, segment := range ruleSegments {
+		r, err := rule.Parse(segment.Rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule.Parse %s: %w", segment.Name, err)
+		}
+		rules = append(rules, r)
+	}
+
+	attributes, err := s.userRepo.GetUsersAttributes(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("userRepo.GetUsersAttributes: %w", err)
+	}
+
+	matched := make(map[int][]entity.Segment)
+	var userIds, segmentIds []int
+	for _, id := range ids {
+		if _, ok := matched[id]; ok {
+			continue
+		}
+
+		// Неизвестные пользователи не вычисляются по правилам: отрицание совпало бы и с пустыми атрибутами
+		userAttributes, ok := attributes[id]
+		if !ok {
+			continue
+		}
+
+		for i, segment := range ruleSegments {
+			if !rules[i].Match(userAttributes) {
+				continue
+			}
+			matched[id] = append(matched[id], segment)
+			if !slices.Contains(segmentIds, segment.Id) {
+				segmentIds = append(segmentIds, segment.Id)
+			}
+		}
+		if len(matched[id]) > 0 {
+			userIds = append(userIds, id)
+		}
+	}
+
+	if len(userIds) == 0 {
+		return nil, nil
+	}
+
+	variants, err := s.segmentRepo.GetUsersVariants(ctx, userIds, segmentIds)
+	if err != nil {
+		return nil, fmt.Errorf("segmentRepo.GetUsersVariants: %w", err)
+	}
+
+	segments := make(map[int][]entity.UserSegment, len(userIds))
+	for _, id := range userIds {
+		for _, segment := range matched[id] {
+			segments[id] = append(segments[id], entity.UserSegment{
+				SegmentId: segment.Id,
+				Segment:   segment.Name,
+				Variant:   variants[id][segment.Id],
+				Payload:   segment.Payload,
+			})
+		}
+	}
+
+	return segments, nil
+}
+
+// mergeSegments объединяет явно добавленные сегменты пользователя с сегментами по правилам
+// (явное членство в приоритете) и упорядочивает их по id сегментов
+func mergeSegments(segments []entity.UserSegment, ruleSegments []entity.UserSegment) []entity.UserSegment {
+	for _, ruleSegment := range ruleSegments {
+		explicit := slices.ContainsFunc(segments, func(segment entity.UserSegment) bool {
+			return segment.SegmentId == ruleSegment.SegmentId
+		})
+		if !explicit {
+			segments = append(segments, ruleSegment)
+		}
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].SegmentId < segments[j].SegmentId
+	})
+
+	return segments
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *UserService) EraseUser(ctx context.Context, req entity.UserEraseRequest) (entity.UserErasureReceipt, error) {
+	receipt, err := s.userRepo.EraseUser(ctx, req.UserId, req.Actor, func(dates []time.Time) ([]int, []string, error) {
+		reports, err := s.uploadedReports(ctx, dates)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return historyMonths(dates), reports, nil
+	})
+	if err != nil {
+		return entity.UserErasureReceipt{}, fmt.Errorf("userRepo.EraseUser: %w", err)
+	}
+
+	return receipt, nil
+}
+
+// uploadedReports возвращает отчеты в Google Drive, период которых содержит хотя бы одну из дат
+func (s *UserService) uploadedReports(ctx context.Context, dates []time.Time) ([]string, error) {
+	if len(dates) == 0 || !s.gDrive.IsAvailable() {
+		return nil, nil
+	}
+
+	// Без списка отчетов пользователь не удаляется, чтобы запрос можно было повторить
+	names, err := s.gDrive.GetAllFilenames(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("gDrive.GetAllFilenames: %w", errors.Join(apperror.ErrGDriveNotAvailable, err))
+	}
+
+	var reports []string
+	for _, name := range names {
+		from, to, ok := parseReportFileName(name)
+		if !ok {
+			continue
+		}
+		for _, date := range dates {
+			if !date.Before(from) && date.Before(to) {
+				reports = append(reports, name)
+
+				break
+			}
+		}
+	}
+
+	return reports, nil
+}
+
+// historyMonths возвращает месяцы дат в виде год * 100 + месяц без повторов (даты упорядочены)
+func historyMonths(dates []time.Time) []int {
+	var months []int
+	for _, date := range dates {
+		date = date.In(time.Local)
+		month := date.Year()*100 + int(date.Month())
+		if len(months) == 0 || months[len(months)-1] != month {
+			months = append(months, month)
+		}
+	}
+
+	return months
+}
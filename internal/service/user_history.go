@@ -0,0 +1,100 @@
+package service
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultHistoryLimit размер страницы истории пользователя по умолчанию
+	defaultHistoryLimit = 50
+	// maxHistoryLimit максимальный размер страницы истории пользователя
+	maxHistoryLimit = 500
+)
+
+func (s *UserService) GetHistory(ctx context.Context, req entity.UserHistoryRequest) (entity.UserHistoryResponse, error) {
+	if req.Limit == 0 {
+		req.Limit = defaultHistoryLimit
+	}
+	if req.Limit < 0 || req.Limit > maxHistoryLimit ||
+		!req.From.IsZero() && !req.To.IsZero() && !req.From.Before(req.To) {
+		return entity.UserHistoryResponse{}, apperror.ErrWrongHistoryQuery
+	}
+
+	var after *entity.UserHistoryCursor
+	if req.Cursor != "" {
+		cursor, err := decodeHistoryCursor(req.Cursor)
+		if err != nil {
+			return entity.UserHistoryResponse{}, apperror.ErrWrongHistoryQuery
+		}
+		after = &cursor
+	}
+
+	err := s.userRepo.CheckExistUser(ctx, req.UserId)
+	if err != nil {
+		return entity.UserHistoryResponse{}, fmt.Errorf("userRepo.CheckExistUser: %w", err)
+	}
+
+	history, err := s.userRepo.GetUserHistory(ctx, req, after, req.Limit+1)
+	if err != nil {
+		return entity.UserHistoryResponse{}, fmt.Errorf("userRepo.GetUserHistory: %w", err)
+	}
+
+	response := entity.UserHistoryResponse{}
+	var more bool
+	response.History, more = page(history, req.Limit)
+	if more {
+		last := response.History[req.Limit-1]
+		response.NextCursor = encodeHistoryCursor(entity.UserHistoryCursor{
+			Date:         last.Date,
+			MembershipId: last.MembershipId,
+			Operation:    last.Operation,
+		})
+	}
+	if response.History == nil {
+		response.History = []entity.ReportUserHistory{}
+	}
+
+	return response, nil
+}
+
+// encodeHistoryCursor кодирует позицию записи в непрозрачную для клиента строку,
+// дата хранится в микросекундах - с точностью timestamptz
+func encodeHistoryCursor(cursor entity.UserHistoryCursor) string {
+	raw := fmt.Sprintf("%d:%d:%s", cursor.Date.UnixMicro(), cursor.MembershipId, cursor.Operation)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeHistoryCursor(s string) (entity.UserHistoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return entity.UserHistoryCursor{}, err
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 || parts[2] != entity.ReportOperationAdd && parts[2] != entity.ReportOperationRemove {
+		return entity.UserHistoryCursor{}, fmt.Errorf("malformed cursor %q", raw)
+	}
+
+	date, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return entity.UserHistoryCursor{}, err
+	}
+	membershipId, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return entity.UserHistoryCursor{}, err
+	}
+
+	return entity.UserHistoryCursor{
+		Date:         time.UnixMicro(date),
+		MembershipId: membershipId,
+		Operation:    parts[2],
+	}, nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxImportRows максимальное количество строк в файле импорта
+	maxImportRows = 500000
+	// maxImportRejectedRows максимальное количество отклонённых строк, возвращаемых с причинами
+	maxImportRejectedRows = 1000
+	// maxImportLineSize максимальная длина строки файла JSON Lines
+	maxImportLineSize = 64 * 1024
+)
+
+func (s *UserService) ImportSegments(ctx context.Context, req entity.UserImportRequest) (entity.UserImportResponse, error) {
+	var (
+		rows     []entity.UserImportRow
+		rejected []entity.UserImportRejectedRow
+		err      error
+	)
+	switch req.Format {
+	case entity.ImportFormatCSV:
+		rows, rejected, err = parseImportCSV(req.File)
+	case entity.ImportFormatJSONL:
+		rows, rejected, err = parseImportJSONL(req.File)
+	default:
+		return entity.UserImportResponse{}, apperror.ErrWrongImportFile
+	}
+	if err != nil {
+		return entity.UserImportResponse{}, err
+	}
+
+	accepted := 0
+	if len(rows) > 0 {
+		var rejectedRows []entity.UserImportRejectedRow
+		accepted, rejectedRows, err = s.userRepo.ImportSegmentsToUsers(ctx, rows, req.DryRun)
+		if err != nil {
+			return entity.UserImportResponse{}, fmt.Errorf("userRepo.ImportSegmentsToUsers: %w", err)
+		}
+		rejected = mergeRejectedRows(rejected, rejectedRows)
+	}
+
+	response := entity.UserImportResponse{
+		DryRun:       req.DryRun,
+		Accepted:     accepted,
+		Rejected:     len(rejected),
+		RejectedRows: []entity.UserImportRejectedRow{},
+	}
+	if len(rejected) > 0 {
+		response.RejectedRows = rejected[:min(len(rejected), maxImportRejectedRows)]
+	}
+
+	return response, nil
+}
+
+// parseImportCSV разбирает файл CSV со столбцами user_id,segment,ttl (ttl необязателен),
+// строка заголовка пропускается. Возвращает корректные строки и строки, отклонённые при разборе.
+func parseImportCSV(file io.Reader) ([]entity.UserImportRow, []entity.UserImportRejectedRow, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	var (
+		rows     []entity.UserImportRow
+		rejected []entity.UserImportRejectedRow
+	)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if len(rows)+len(rejected) >= maxImportRows {
+			return nil, nil, apperror.ErrWrongImportFile
+		}
+
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, fmt.Errorf("csv.Read: %w", err)
+			}
+			rejected = append(rejected, entity.UserImportRejectedRow{Line: parseErr.StartLine, Reason: entity.ImportReasonMalformed})
+
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(rows) == 0 && len(rejected) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "user_id") {
+			continue
+		}
+
+		if len(record) < 2 || len(record) > 3 {
+			rejected = append(rejected, entity.UserImportRejectedRow{Line: line, Reason: entity.ImportReasonMalformed})
+
+			continue
+		}
+
+		row := entity.UserImportRow{Line: line, Segment: strings.TrimSpace(record[1])}
+		row.UserId, err = strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			rejected = append(rejected, entity.UserImportRejectedRow{Line: line, Segment: row.Segment, Reason: entity.ImportReasonWrongUserId})
+
+			continue
+		}
+		if len(record) == 3 && strings.TrimSpace(record[2]) != "" {
+			row.Ttl, err = strconv.Atoi(strings.TrimSpace(record[2]))
+			if err != nil {
+				rejected = append(rejected, entity.UserImportRejectedRow{Line: line, UserId: row.UserId, Segment: row.Segment, Reason: entity.ImportReasonWrongTtl})
+
+				continue
+			}
+		}
+
+		if reason := validateImportRow(row); reason != "" {
+			rejected = append(rejected, entity.UserImportRejectedRow{Line: line, UserId: row.UserId, Segment: row.Segment, Reason: reason})
+
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, rejected, nil
+}
+
+// parseImportJSONL разбирает файл JSON Lines с объектами {"user_id": ..., "segment": ..., "ttl": ...},
+// пустые строки пропускаются. Возвращает корректные строки и строки, отклонённые при разборе.
+func parseImportJSONL(file io.Reader) ([]entity.UserImportRow, []entity.UserImportRejectedRow, error) {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxImportLineSize)
+
+	var (
+		rows     []entity.UserImportRow
+		rejected []entity.UserImportRejectedRow
+	)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(rows)+len(rejected) >= maxImportRows {
+			return nil, nil, apperror.ErrWrongImportFile
+		}
+
+		row := entity.UserImportRow{Line: line}
+		if err := json.Unmarshal(data, &row); err != nil {
+			rejected = append(rejected, entity.UserImportRejectedRow{Line: line, Reason: entity.ImportReasonMalformed})
+
+			continue
+		}
+
+		if reason := validateImportRow(row); reason != "" {
+			rejected = append(rejected, entity.UserImportRejectedRow{Line: line, UserId: row.UserId, Segment: row.Segment, Reason: reason})
+
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, nil, apperror.ErrWrongImportFile
+		}
+
+		return nil, nil, fmt.Errorf("scanner.Scan: %w", err)
+	}
+
+	return rows, rejected, nil
+}
+
+// validateImportRow возвращает причину отклонения строки или пустую строку, если строка корректна
+func validateImportRow(row entity.UserImportRow) string {
+	switch {
+	case row.UserId <= 0:
+		return entity.ImportReasonWrongUserId
+	case row.Segment == "":
+		return entity.ImportReasonNoSegment
+	case row.Ttl < 0:
+		return entity.ImportReasonWrongTtl
+	}
+
+	return ""
+}
+
+// mergeRejectedRows объединяет упорядоченные по номеру строки отклонённые строки разбора и проверки в бд
+func mergeRejectedRows(a, b []entity.UserImportRejectedRow) []entity.UserImportRejectedRow {
+	merged := make([]entity.UserImportRejectedRow, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].Line <= b[0].Line {
+			merged = append(merged, a[0])
+			a = a[1:]
+		} else {
+			merged = append(merged, b[0])
+			b = b[1:]
+		}
+	}
+	merged = append(merged, a...)
+
+	return append(merged, b...)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"avito-internship/internal/apperror"
+	"avito-internship/internal/entity"
+	"avito-internship/internal/repository"
+	"avito-internship/internal/webapi"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const (
+	// dispatchBatchSize количество событий журнала, распределяемых по подпискам за один запуск
+	dispatchBatchSize = 500
+	// deliveryBatchSize количество доставок, отправляемых за один запуск
+	deliveryBatchSize = 100
+	// deliveryLease время, на которое захватывается доставка
+	deliveryLease = time.Minute
+	// deliveryMaxAttempts количество попыток, после которого доставка переносится в недоставленные
+	deliveryMaxAttempts = 8
+	// deliveryBaseBackoff задержка перед второй попыткой, далее задержка удваивается
+	deliveryBaseBackoff = 30 * time.Second
+	// deliveryMaxBackoff максимальная задержка между попытками
+	deliveryMaxBackoff = time.Hour
+)
+
+type WebhookService struct {
+	webhookRepo repository.WebhookRepo
+	sender      webapi.WebhookSender
+}
+
+func NewWebhookService(webhookRepo repository.WebhookRepo, sender webapi.WebhookSender) *WebhookService {
+	return &WebhookService{
+		webhookRepo: webhookRepo,
+		sender:      sender,
+	}
+}
+
+func (s *WebhookService) CreateWebhook(ctx context.Context, req entity.WebhookRequest) (int, error) {
+	u, err := url.Parse(req.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return 0, apperror.ErrWrongWebhook
+	}
+
+	if len(req.Events) == 0 {
+		return 0, apperror.ErrWrongWebhook
+	}
+
+	for _, event := range req.Events {
+		if !slices.Contains(entity.EventTypes, event) {
+			return 0, apperror.ErrWrongWebhook
+		}
+	}
+
+	webhookId, err := s.webhookRepo.CreateWebhook(ctx, entity.Webhook{
+		Url:    req.Url,
+		Events: req.Events,
+		Secret: req.Secret,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("webhookRepo.CreateWebhook: %w", err)
+	}
+
+	return webhookId, nil
+}
+
+func (s *WebhookService) DeleteWebhook(ctx context.Context, req entity.WebhookDeleteRequest) error {
+	err := s.webhookRepo.DeleteWebhook(ctx, req.Id)
+	if err != nil {
+		return fmt.Errorf("webhookRepo.DeleteWebhook: %w", err)
+	}
+
+	return nil
+}
+
+func (s *WebhookService) GetWebhooks(ctx context.Context) ([]entity.Webhook, error) {
+	webhooks, err := s.webhookRepo.GetWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("webhookRepo.GetWebhooks: %w", err)
+	}
+
+	if webhooks == nil {
+		webhooks = []entity.Webhook{}
+	}
+
+	return webhooks, nil
+}
+
+func (s *WebhookService) DispatchEvents(ctx context.Context) (int, error) {
+	dispatched, err := s.webhookRepo.DispatchEvents(ctx, dispatchBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("webhookRepo.DispatchEvents: %w", err)
+	}
+
+	return dispatched, nil
+}
+
+func (s *WebhookService) DeliverEvents(ctx context.Context) (int, error) {
+	deliveries, err := s.webhookRepo.ClaimDeliveries(ctx, deliveryBatchSize, deliveryLease)
+	if err != nil {
+		return 0, fmt.Errorf("webhookRepo.ClaimDeliveries: %w", err)
+	}
+
+	var (
+		delivered int
+		errs      []error
+	)
+	for _, delivery := range deliveries {
+		err = s.deliver(ctx, delivery)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered++
+	}
+
+	return delivered, errors.Join(errs...)
+}
+
+// deliver отправляет событие подписчику и сохраняет результат попытки,
+// при ошибке отправки доставка переносится на следующую попытку или в недоставленные
+func (s *WebhookService) deliver(ctx context.Context, delivery entity.WebhookDelivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return fmt.Errorf("json.Marshal event %d: %w", delivery.Event.Id, err)
+	}
+
+	sendErr := s.sender.Send(ctx, delivery.Url, delivery.Secret, body)
+	if sendErr == nil {
+		err = s.webhookRepo.MarkDelivered(ctx, delivery.Id)
+		if err != nil {
+			return fmt.Errorf("webhookRepo.MarkDelivered: %w", err)
+		}
+
+		return nil
+	}
+
+	if delivery.Attempts >= deliveryMaxAttempts {
+		err = s.webhookRepo.DeadLetterDelivery(ctx, delivery.Id, sendErr.Error())
+		if err != nil {
+			return fmt.Errorf("webhookRepo.DeadLetterDelivery: %w", err)
+		}
+
+		return fmt.Errorf("delivery %d moved to dead letters: %w", delivery.Id, sendErr)
+	}
+
+	err = s.webhookRepo.RetryDelivery(ctx, delivery.Id, time.Now().Add(backoff(delivery.Attempts)), sendErr.Error())
+	if err != nil {
+		return fmt.Errorf("webhookRepo.RetryDelivery: %w", err)
+	}
+
+	return fmt.Errorf("delivery %d attempt %d: %w", delivery.Id, delivery.Attempts, sendErr)
+}
+
+// backoff возвращает задержку перед следующей попыткой после attempts неудачных попыток
+func backoff(attempts int) time.Duration {
+	delay := deliveryBaseBackoff
+	for i := 1; i < attempts && delay < deliveryMaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, deliveryMaxBackoff)
+}
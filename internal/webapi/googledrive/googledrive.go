@@ -2,12 +2,12 @@ package googledrive
 
 import (
 	"avito-internship/internal/apperror"
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
+	"io"
 )
 
 type GDriveWebAPI struct {
@@ -35,7 +35,7 @@ func (w *GDriveWebAPI) IsAvailable() bool {
 	return w.isAvailable
 }
 
-func (w *GDriveWebAPI) UploadCSVFile(ctx context.Context, name string, data []byte) (string, error) {
+func (w *GDriveWebAPI) UploadCSVFile(ctx context.Context, name string, data io.Reader) (string, error) {
 	fileId, err := w.getFileIdByName(ctx, name)
 	if err != nil {
 		if !errors.Is(err, apperror.ErrFileNotFound) {
@@ -86,7 +86,7 @@ func (w *GDriveWebAPI) GetAllFilenames(ctx context.Context) ([]string, error) {
 	return names, nil
 }
 
-func (w *GDriveWebAPI) createFile(ctx context.Context, name string, content []byte) (string, error) {
+func (w *GDriveWebAPI) createFile(ctx context.Context, name string, content io.Reader) (string, error) {
 	file := &drive.File{
 		Name:     name,
 		MimeType: "text/csv",
@@ -97,7 +97,7 @@ func (w *GDriveWebAPI) createFile(ctx context.Context, name string, content []by
 		Role: "reader",
 	}
 
-	_, err := w.driveService.Files.Create(file).Context(ctx).Media(bytes.NewReader(content)).Do()
+	_, err := w.driveService.Files.Create(file).Context(ctx).Media(content).Do()
 	if err != nil {
 		return "", fmt.Errorf("GDriveWebAPI.createFile - w.driveService.Files.Create: %w", err)
 	}
@@ -115,13 +115,13 @@ func (w *GDriveWebAPI) createFile(ctx context.Context, name string, content []by
 	return fileId, nil
 }
 
-func (w *GDriveWebAPI) updateFile(ctx context.Context, id string, content []byte, name string) error {
+func (w *GDriveWebAPI) updateFile(ctx context.Context, id string, content io.Reader, name string) error {
 	file := &drive.File{
 		Name:     name,
 		MimeType: "text/csv",
 	}
 
-	_, err := w.driveService.Files.Update(id, file).Context(ctx).Media(bytes.NewReader(content)).Do()
+	_, err := w.driveService.Files.Update(id, file).Context(ctx).Media(content).Do()
 	if err != nil {
 		return fmt.Errorf("GDriveWebAPI.updateFile - w.driveService.Files.Update: %w", err)
 	}
@@ -1,10 +1,18 @@
 package webapi
 
-import "context"
+import (
+	"context"
+	"io"
+)
 
 type GDrive interface {
-	UploadCSVFile(ctx context.Context, name string, data []byte) (string, error)
+	UploadCSVFile(ctx context.Context, name string, data io.Reader) (string, error)
 	DeleteFile(ctx context.Context, name string) error
 	GetAllFilenames(ctx context.Context) ([]string, error)
 	IsAvailable() bool
 }
+
+// WebhookSender отправляет подписанное тело события на url подписчика
+type WebhookSender interface {
+	Send(ctx context.Context, url string, secret string, body []byte) error
+}
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+
+	// SignatureHeader заголовок с подписью тела запроса HMAC-SHA256 в формате sha256=<hex>
+	SignatureHeader = "X-Signature-256"
+)
+
+type WebhookWebAPI struct {
+	client *http.Client
+}
+
+func New() *WebhookWebAPI {
+	return &WebhookWebAPI{
+		client: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+func (w *WebhookWebAPI) Send(ctx context.Context, url string, secret string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(secret, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign возвращает подпись тела запроса HMAC-SHA256 в hex
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
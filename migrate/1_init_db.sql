@@ -1,10 +1,66 @@
+CREATE TABLE IF NOT EXISTS Layers
+(
+    id         BIGSERIAL PRIMARY KEY,
+    name       VARCHAR     NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (name)
+);
+
+
 CREATE TABLE IF NOT EXISTS Segments
 (
     id         BIGSERIAL PRIMARY KEY,
     name       VARCHAR   NOT NULL,
+    percent    REAL      NOT NULL DEFAULT 0,
+    salt       VARCHAR   NOT NULL DEFAULT md5(random()::text),
+    rule       VARCHAR            DEFAULT NULL,
+    layer_id   INTEGER            DEFAULT NULL REFERENCES Layers (id),
+    payload    JSONB              DEFAULT NULL,
+    starts_at  timestamptz NOT NULL DEFAULT now(),
+    ends_at    timestamptz          DEFAULT NULL,
     created_at timestamptz NOT NULL DEFAULT now(),
     deleted_at    timestamptz          DEFAULT NULL,
-    UNIQUE (name)
+    -- Описание сегмента в каталоге, created_by и updated_by - инициаторы изменений (заголовок X-Actor)
+    description VARCHAR             DEFAULT NULL,
+    owner_team  VARCHAR             DEFAULT NULL,
+    tags        TEXT[]      NOT NULL DEFAULT '{}',
+    ticket_url  VARCHAR             DEFAULT NULL,
+    created_by  VARCHAR             DEFAULT NULL,
+    updated_at  timestamptz NOT NULL DEFAULT now(),
+    updated_by  VARCHAR             DEFAULT NULL,
+    UNIQUE (name),
+    CHECK (ends_at IS NULL OR ends_at > starts_at),
+    -- Членство по правилу не учитывает исключительность слоя
+    CHECK (rule IS NULL OR layer_id IS NULL)
+);
+
+-- Поиск в каталоге по префиксу названия и по тегам
+CREATE INDEX ON Segments (name varchar_pattern_ops);
+CREATE INDEX ON Segments USING GIN (tags);
+
+
+-- Прежние названия переименованных сегментов: до alias_until название продолжает находить сегмент,
+-- renamed_at позволяет восстановить название сегмента на момент события
+CREATE TABLE IF NOT EXISTS Segments_alias
+(
+    id          BIGSERIAL PRIMARY KEY,
+    segment_id  INTEGER     NOT NULL REFERENCES Segments (id),
+    name        VARCHAR     NOT NULL,
+    renamed_at  timestamptz NOT NULL DEFAULT now(),
+    alias_until timestamptz NOT NULL
+);
+
+CREATE INDEX ON Segments_alias (name);
+CREATE INDEX ON Segments_alias (segment_id, renamed_at);
+
+
+CREATE TABLE IF NOT EXISTS Segments_variant
+(
+    id         BIGSERIAL PRIMARY KEY,
+    segment_id INTEGER NOT NULL REFERENCES Segments (id),
+    name       VARCHAR NOT NULL,
+    weight     INTEGER NOT NULL CHECK (weight > 0),
+    UNIQUE (segment_id, name)
 );
 
 
@@ -14,13 +70,225 @@ CREATE TABLE IF NOT EXISTS Users
 );
 
 
+CREATE TABLE IF NOT EXISTS Users_attributes
+(
+    user_id    INTEGER PRIMARY KEY REFERENCES Users (id),
+    attributes JSONB       NOT NULL DEFAULT '{}',
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+
 CREATE TABLE IF NOT EXISTS Users_segment
 (
     id         BIGSERIAL PRIMARY KEY,
     user_id    INTEGER   NOT NULL REFERENCES Users (id),
     segment_id INTEGER   not null REFERENCES Segments (id),
+    source     VARCHAR   NOT NULL DEFAULT 'manual',
+    variant    VARCHAR            DEFAULT NULL,
     added_at timestamptz NOT NULL DEFAULT now(),
-    left_at    timestamptz          DEFAULT NULL
+    left_at    timestamptz          DEFAULT NULL,
+    -- Момент фиксации исключения и его причина (manual, expired, rollout, segment_ended, segment_deleted, user_erased)
+    finalized_at   timestamptz      DEFAULT NULL,
+    removal_reason VARCHAR          DEFAULT NULL,
+    -- Инициатор добавления и исключения (заголовок X-Actor), если он был передан
+    added_by       VARCHAR          DEFAULT NULL,
+    removed_by     VARCHAR          DEFAULT NULL
 );
 
 CREATE INDEX ON Users_segment (user_id);
+-- Список пользователей сегмента с постраничным обходом по id пользователя
+CREATE INDEX ON Users_segment (segment_id, user_id);
+CREATE INDEX ON Users_segment (left_at) WHERE finalized_at IS NULL AND left_at IS NOT NULL;
+-- Отчеты отбирают добавления и исключения по их собственным датам
+CREATE INDEX ON Users_segment (added_at);
+CREATE INDEX ON Users_segment (left_at) WHERE left_at IS NOT NULL;
+
+
+-- Удаление пользователя по запросу: членство и события переносятся на псевдоним (отрицательный id из последовательности),
+-- сам пользователь и его атрибуты удаляются. Запись об удалении не содержит исходный id пользователя,
+-- reports - отчеты в Google Drive, которые содержат исходный id и должны быть перестроены, report_jobs - задания на отчеты
+-- (готовые и ещё выполняющиеся), файлы которых содержали исходный id и удалены, а задания возвращены в очередь,
+-- purge_archives - задания на удаление истории, в архивах которых исходный id заменён псевдонимом.
+CREATE SEQUENCE IF NOT EXISTS Erased_users_seq;
+
+CREATE TABLE IF NOT EXISTS Users_erasure
+(
+    id             BIGSERIAL PRIMARY KEY,
+    pseudonym      INTEGER     NOT NULL,
+    memberships    INTEGER     NOT NULL DEFAULT 0,
+    events         INTEGER     NOT NULL DEFAULT 0,
+    reports        TEXT[]      NOT NULL DEFAULT '{}',
+    report_jobs    BIGINT[]    NOT NULL DEFAULT '{}',
+    purge_archives BIGINT[]    NOT NULL DEFAULT '{}',
+    requested_by   VARCHAR              DEFAULT NULL,
+    erased_at      timestamptz NOT NULL DEFAULT now()
+);
+
+
+-- Журнал событий изменения сегментов и членства пользователей (transactional outbox).
+-- События записываются в тех же транзакциях, что и сами изменения, dispatched_at заполняется
+-- после распределения события по подпискам вебхуков. Событие распределяется и отдаётся в поток
+-- не раньше occurred_at, поэтому запланированное добавление публикуется в момент начала членства.
+-- Транзакции фиксируются не в порядке id, поэтому поток читается по seq: номер присваивается
+-- при распределении, которое выполняется последовательно, и не уменьшается в порядке фиксации.
+CREATE TABLE IF NOT EXISTS Events
+(
+    id            BIGSERIAL PRIMARY KEY,
+    type          VARCHAR     NOT NULL,
+    -- Запись членства события (для событий членства), по ней отменяется событие ещё не начавшегося членства
+    membership_id BIGINT               DEFAULT NULL,
+    user_id       INTEGER              DEFAULT NULL,
+    segment       VARCHAR     NOT NULL,
+    variant       VARCHAR              DEFAULT NULL,
+    reason        VARCHAR              DEFAULT NULL,
+    occurred_at   timestamptz NOT NULL DEFAULT now(),
+    created_at    timestamptz NOT NULL DEFAULT now(),
+    dispatched_at timestamptz          DEFAULT NULL,
+    -- Номер события в потоке, присваивается при распределении
+    seq           BIGINT               DEFAULT NULL
+);
+
+CREATE INDEX ON Events (id) WHERE dispatched_at IS NULL;
+CREATE UNIQUE INDEX ON Events (seq);
+CREATE INDEX ON Events (membership_id) WHERE dispatched_at IS NULL;
+-- Перенос событий пользователя на псевдоним при удалении пользователя
+CREATE INDEX ON Events (user_id) WHERE user_id IS NOT NULL;
+
+
+CREATE TABLE IF NOT EXISTS Webhooks
+(
+    id         BIGSERIAL PRIMARY KEY,
+    url        VARCHAR     NOT NULL,
+    events     VARCHAR[]   NOT NULL,
+    secret     VARCHAR     NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    deleted_at timestamptz          DEFAULT NULL
+);
+
+
+CREATE TABLE IF NOT EXISTS Webhook_deliveries
+(
+    id              BIGSERIAL PRIMARY KEY,
+    webhook_id      INTEGER     NOT NULL REFERENCES Webhooks (id),
+    event_id        BIGINT      NOT NULL REFERENCES Events (id),
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    last_error      VARCHAR              DEFAULT NULL,
+    delivered_at    timestamptz          DEFAULT NULL,
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX ON Webhook_deliveries (next_attempt_at) WHERE delivered_at IS NULL;
+
+
+-- Доставки, не выполненные после исчерпания попыток
+CREATE TABLE IF NOT EXISTS Webhook_dead_letters
+(
+    id         BIGSERIAL PRIMARY KEY,
+    webhook_id INTEGER     NOT NULL REFERENCES Webhooks (id),
+    event_id   BIGINT      NOT NULL REFERENCES Events (id),
+    attempts   INTEGER     NOT NULL,
+    last_error VARCHAR              DEFAULT NULL,
+    failed_at  timestamptz NOT NULL DEFAULT now()
+);
+
+
+-- Задания на построение отчетов. Задание захватывается воркером на время аренды (locked_until),
+-- которая продлевается при обновлении прогресса; задания с истёкшей арендой (например, после перезапуска
+-- сервиса) захватываются повторно. attempts увеличивается при каждом захвате и служит меткой владения:
+-- изменения задания и запись файла принимаются, только если задание выполняется с той же попыткой,
+-- поэтому воркер, у которого задание перехватили, не может его изменить. attempts не уменьшается.
+CREATE TABLE IF NOT EXISTS Report_jobs
+(
+    id           BIGSERIAL PRIMARY KEY,
+    type         VARCHAR     NOT NULL,
+    month        INTEGER     NOT NULL,
+    year         INTEGER     NOT NULL,
+    status       VARCHAR     NOT NULL DEFAULT 'pending',
+    progress     REAL        NOT NULL DEFAULT 0,
+    attempts     INTEGER     NOT NULL DEFAULT 0,
+    locked_until timestamptz          DEFAULT NULL,
+    link         VARCHAR              DEFAULT NULL,
+    error        VARCHAR              DEFAULT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    started_at   timestamptz          DEFAULT NULL,
+    finished_at  timestamptz          DEFAULT NULL
+);
+
+CREATE INDEX ON Report_jobs (id) WHERE status IN ('pending', 'running');
+
+-- Файл отчета задания типа file, записывается частями по мере построения отчета, поэтому размер отчета
+-- не ограничен памятью сервиса. Каждая часть содержит целые строки csv, part - порядковый номер части.
+CREATE TABLE IF NOT EXISTS Report_jobs_file
+(
+    job_id BIGINT  NOT NULL REFERENCES Report_jobs (id),
+    part   INTEGER NOT NULL,
+    data   BYTEA   NOT NULL,
+    PRIMARY KEY (job_id, part)
+);
+
+
+-- Задания на окончательное удаление истории: segment - удалённый сегмент со всей историей,
+-- retention - завершённое членство, исключённое до cutoff, и сегменты, удалённые до cutoff.
+-- Строки удаляются небольшими пачками, purged - количество уже удалённых строк членства, увеличивается
+-- в транзакции удаления пачки. attempts служит меткой владения так же, как в Report_jobs.
+CREATE TABLE IF NOT EXISTS Purge_jobs
+(
+    id           BIGSERIAL PRIMARY KEY,
+    type         VARCHAR     NOT NULL,
+    segment_id   INTEGER              DEFAULT NULL,
+    segment      VARCHAR              DEFAULT NULL,
+    cutoff       timestamptz          DEFAULT NULL,
+    archive      BOOLEAN     NOT NULL DEFAULT false,
+    status       VARCHAR     NOT NULL DEFAULT 'pending',
+    purged       BIGINT      NOT NULL DEFAULT 0,
+    attempts     INTEGER     NOT NULL DEFAULT 0,
+    locked_until timestamptz          DEFAULT NULL,
+    error        VARCHAR              DEFAULT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    started_at   timestamptz          DEFAULT NULL,
+    finished_at  timestamptz          DEFAULT NULL
+);
+
+CREATE INDEX ON Purge_jobs (id) WHERE status IN ('pending', 'running');
+-- Для сегмента может быть только одно незавершённое задание
+CREATE UNIQUE INDEX ON Purge_jobs (segment_id) WHERE status IN ('pending', 'running');
+-- Удаление сегментов по retention отбирает сегменты по времени удаления
+CREATE INDEX ON Segments (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Архив удалённой истории задания с archive (строки csv отчета без заголовка): операции удалённых записей
+-- членства сохраняются очередной частью в той же транзакции, что и удаление пачки.
+CREATE TABLE IF NOT EXISTS Purge_jobs_file
+(
+    job_id BIGINT  NOT NULL REFERENCES Purge_jobs (id),
+    part   INTEGER NOT NULL,
+    data   BYTEA   NOT NULL,
+    PRIMARY KEY (job_id, part)
+);
+
+
+-- Номер бакета пользователя (0-9999) в сегменте, вычисляется по соли сегмента и id пользователя.
+-- Пользователь попадает в сегмент с процентом p, если его бакет меньше p * 10000.
+CREATE OR REPLACE FUNCTION segment_bucket(salt VARCHAR, user_id INTEGER) RETURNS INTEGER AS
+$$
+SELECT (('x' || substr(md5(salt || ':' || user_id), 1, 8))::bit(32)::bigint % 10000)::integer
+$$ LANGUAGE sql IMMUTABLE;
+
+
+-- Вариант эксперимента, выдаваемый пользователю при попадании в сегмент.
+-- Вариант выбирается по весам детерминированно, отдельно от бакета процентной раскатки,
+-- для сегмента без вариантов возвращается NULL.
+CREATE OR REPLACE FUNCTION segment_variant(segment BIGINT, user_id INTEGER) RETURNS VARCHAR AS
+$$
+SELECT v.name
+FROM (SELECT name,
+             sum(weight) OVER (ORDER BY id) AS upper_bound,
+             sum(weight) OVER ()            AS total
+      FROM Segments_variant
+      WHERE segment_id = segment) AS v,
+     Segments AS s
+WHERE s.id = segment
+  AND v.upper_bound > ('x' || substr(md5(s.salt || ':variant:' || user_id), 1, 8))::bit(32)::bigint % v.total
+ORDER BY v.upper_bound
+LIMIT 1
+$$ LANGUAGE sql STABLE;
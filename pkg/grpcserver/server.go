@@ -0,0 +1,90 @@
+package grpcserver
+
+import (
+	"errors"
+	"google.golang.org/grpc"
+	"net"
+	"time"
+)
+
+const (
+	defaultAddr            = ":9090"
+	defaultShutdownTimeout = 3 * time.Second
+)
+
+var (
+	ErrShutdownTimeout = errors.New("grpcserver: shutdown timeout exceeded")
+	ErrEmptyPort       = errors.New("grpcserver: empty port")
+)
+
+type Server struct {
+	server          *grpc.Server
+	addr            string
+	notify          chan error
+	shutdownTimeout time.Duration
+}
+
+// New создаёт gRPC сервер, register регистрирует на нём сервисы до запуска.
+func New(register func(*grpc.Server), opts ...Option) *Server {
+	s := &Server{
+		server:          grpc.NewServer(),
+		addr:            defaultAddr,
+		notify:          make(chan error, 1),
+		shutdownTimeout: defaultShutdownTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	register(s.server)
+	s.start()
+
+	return s
+}
+
+func (s *Server) start() {
+	go func() {
+		// Пустой порт привёл бы к прослушиванию случайного порта
+		if _, port, _ := net.SplitHostPort(s.addr); port == "" {
+			s.notify <- ErrEmptyPort
+			close(s.notify)
+
+			return
+		}
+
+		listener, err := net.Listen("tcp", s.addr)
+		if err != nil {
+			s.notify <- err
+			close(s.notify)
+
+			return
+		}
+
+		s.notify <- s.server.Serve(listener)
+		close(s.notify)
+	}()
+}
+
+func (s *Server) Notify() <-chan error {
+	return s.notify
+}
+
+// Shutdown дожидается завершения активных вызовов (в том числе потоковых),
+// по истечении shutdownTimeout соединения закрываются принудительно.
+func (s *Server) Shutdown() error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-time.After(s.shutdownTimeout):
+		s.server.Stop()
+
+		return ErrShutdownTimeout
+	}
+}
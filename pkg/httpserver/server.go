@@ -2,6 +2,7 @@ package httpserver
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"time"
 )
@@ -16,9 +17,20 @@ const (
 type Server struct {
 	server          *http.Server
 	notify          chan error
+	shutdown        chan struct{}
 	shutdownTimeout time.Duration
 }
 
+type shutdownKey struct{}
+
+// ShuttingDown возвращает канал, закрываемый при начале остановки сервера, обработавшего запрос.
+// Долгие запросы (потоки) должны завершаться по нему, иначе остановка ждёт их до таймаута.
+func ShuttingDown(ctx context.Context) <-chan struct{} {
+	shutdown, _ := ctx.Value(shutdownKey{}).(chan struct{})
+
+	return shutdown
+}
+
 func New(handler http.Handler, opts ...Option) *Server {
 	httpServer := &http.Server{
 		Handler:      handler,
@@ -30,9 +42,17 @@ func New(handler http.Handler, opts ...Option) *Server {
 	s := &Server{
 		server:          httpServer,
 		notify:          make(chan error, 1),
+		shutdown:        make(chan struct{}),
 		shutdownTimeout: defaultShutdownTimeout,
 	}
 
+	httpServer.BaseContext = func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), shutdownKey{}, s.shutdown)
+	}
+	httpServer.RegisterOnShutdown(func() {
+		close(s.shutdown)
+	})
+
 	for _, opt := range opts {
 		opt(s)
 	}
@@ -0,0 +1,23 @@
+package worker
+
+import "time"
+
+type Option func(*Worker)
+
+func Interval(interval time.Duration) Option {
+	return func(w *Worker) {
+		w.interval = interval
+	}
+}
+
+func ErrorHandler(handler func(error)) Option {
+	return func(w *Worker) {
+		w.errorHandler = handler
+	}
+}
+
+func ShutdownTimeout(timeout time.Duration) Option {
+	return func(w *Worker) {
+		w.shutdownTimeout = timeout
+	}
+}
@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	defaultInterval        = time.Minute
+	defaultShutdownTimeout = 3 * time.Second
+)
+
+var ErrShutdownTimeout = errors.New("worker: shutdown timeout exceeded")
+
+// Job задача, периодически выполняемая воркером
+type Job func(ctx context.Context) error
+
+type Worker struct {
+	job             Job
+	interval        time.Duration
+	errorHandler    func(error)
+	shutdownTimeout time.Duration
+	cancel          context.CancelFunc
+	done            chan struct{}
+}
+
+func New(job Job, opts ...Option) *Worker {
+	w := &Worker{
+		job:             job,
+		interval:        defaultInterval,
+		errorHandler:    func(error) {},
+		shutdownTimeout: defaultShutdownTimeout,
+		done:            make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	w.start()
+
+	return w
+}
+
+func (w *Worker) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := w.job(ctx); err != nil && ctx.Err() == nil {
+				w.errorHandler(err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Shutdown останавливает воркер и ожидает завершения текущего запуска задачи
+func (w *Worker) Shutdown() error {
+	w.cancel()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-time.After(w.shutdownTimeout):
+		return ErrShutdownTimeout
+	}
+}